@@ -2,54 +2,160 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/example/avito-pr-service/internal/config"
+	"github.com/example/avito-pr-service/internal/digest"
+	"github.com/example/avito-pr-service/internal/github"
+	"github.com/example/avito-pr-service/internal/health"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/metrics"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/example/avito-pr-service/internal/notify"
+	"github.com/example/avito-pr-service/internal/outbox"
+	"github.com/example/avito-pr-service/internal/repo"
 	"github.com/example/avito-pr-service/internal/server"
 	"github.com/example/avito-pr-service/internal/storage"
+	"github.com/example/avito-pr-service/internal/tracing"
+	"github.com/example/avito-pr-service/internal/webhook"
 )
 
+var logger = logging.Logger("app")
+
+// fatal logs err as the reason the service cannot run and exits.
+func fatal(msg string, err error) {
+	logger.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
 func main() {
-	cfg := storage.ConfigFromEnv()
+	cfg, cfgErr := config.Load(os.Getenv(config.FileEnv))
+	if cfgErr == nil {
+		cfgErr = cfg.Validate()
+	}
+	logging.Setup(os.Stdout, cfg.Log)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	pool, err := storage.Open(ctx, cfg)
+	if len(os.Args) > 1 {
+		code := runCommand(ctx, cfg, cfgErr, os.Args[1:])
+		stop()
+		os.Exit(code)
+	}
+	if cfgErr != nil {
+		fatal("invalid configuration (see `app config print`)", cfgErr)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		fatal("tracing", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error("tracing shutdown", logging.Err(err))
+		}
+	}()
+
+	pool, err := storage.Open(ctx, cfg.Database)
 	if err != nil {
-		log.Fatalf("db open: %v", err)
+		fatal("db open", err)
 	}
 	defer pool.Close()
 
-	r := server.NewRouter(pool)
+	schema := migrate.Embedded(pool)
+	if cfg.Migrate.OnStart {
+		if err := schema.Up(ctx); err != nil {
+			fatal("migrate", err)
+		}
+	}
+	if err := schema.Check(ctx); err != nil {
+		if cfg.Migrate.Check == migrate.CheckStrict {
+			fatal("schema check failed (run `app migrate up` or set SCHEMA_CHECK=warn)", err)
+		}
+		logger.Warn("schema check failed", logging.Err(err))
+	}
+
+	if !cfg.Auth.Enabled {
+		logger.Warn("authentication disabled (set AUTH_ENABLED=true to require bearer tokens)")
+	}
+	dispatcher := webhook.NewDispatcher(repo.New(pool), cfg.Webhook)
+	go dispatcher.Run(ctx)
+
+	sinks, err := outbox.BuildSinks(cfg.Outbox, repo.New(pool))
+	if err != nil {
+		fatal("outbox", err)
+	}
+	if cfg.Features.GitHubSync && cfg.GitHub.SyncEnabled() {
+		sinks = append(sinks, github.NewReviewerSync(github.NewClient(cfg.GitHub), repo.New(pool)))
+	}
+	if cfg.Features.Notify && cfg.Notify.Enabled() {
+		notifier, err := notify.NewNotifier(cfg.Notify, repo.New(pool))
+		if err != nil {
+			fatal("notify", err)
+		}
+		sinks = append(sinks, notifier)
+	}
+	relay := outbox.NewRelay(repo.New(pool), sinks, cfg.Outbox, outbox.WithPublishedHook(dispatcher.Notify))
+	go relay.Run(ctx)
+
+	if cfg.Features.Digest && cfg.Digest.Enabled() {
+		job, err := digest.NewJob(cfg.Digest, repo.New(pool), digest.NewMailer(cfg.Digest))
+		if err != nil {
+			fatal("digest", err)
+		}
+		go job.Run(ctx)
+	}
+
+	ready := health.New(pool, cfg.Health)
+	opts := []server.Option{
+		server.WithHealth(ready),
+		server.WithAuth(cfg.Auth),
+		server.WithOutbox(relay),
+		server.WithGitHub(cfg.GitHub),
+		server.WithGitLab(cfg.GitLab),
+		server.WithTeamDefaults(cfg.Teams.Settings()),
+	}
+	if cfg.Features.Metrics {
+		opts = append(opts, server.WithMetrics(metrics.New(pool)))
+	}
+	r := server.NewRouter(pool, opts...)
 
 	srv := &http.Server{
-		Addr:              ":8080",
+		Addr:              cfg.HTTP.Addr,
 		Handler:           r,
-		ReadHeaderTimeout: 5 * time.Second,
-		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      10 * time.Second,
-		IdleTimeout:       60 * time.Second,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
 	}
 
 	go func() {
-		log.Printf("server listening on %s", srv.Addr)
+		logger.Info("server listening", slog.String("addr", srv.Addr))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %v", err)
+			fatal("listen", err)
 		}
 	}()
 
 	<-ctx.Done()
 	stop()
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Fail readiness first and keep serving while load balancers take the
+	// instance out of rotation; only then drain open connections.
+	ready.Drain()
+	time.Sleep(ready.DrainDelay())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("server shutdown: %v", err)
+		logger.Error("server shutdown", logging.Err(err))
 		os.Exit(1)
 	}
 }
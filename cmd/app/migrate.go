@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/config"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/example/avito-pr-service/internal/storage"
+)
+
+// errUsage makes a subcommand exit with status 2 after printing usage.
+var errUsage = errors.New("usage")
+
+const usage = `usage:
+  app                          run the service
+  app migrate up               apply every pending migration
+  app migrate down [N]         revert the last N applied migrations (default 1)
+  app migrate goto VERSION     apply or revert until VERSION is the newest applied (0 reverts all)
+  app migrate status           list migrations; exits 1 unless the schema matches this build
+  app config print             print the effective configuration with secrets redacted;
+                               exits 1 if it is invalid
+`
+
+// runCommand runs the subcommand in args and returns the process exit code.
+// cfgErr is why cfg failed to load or validate, if it did.
+func runCommand(ctx context.Context, cfg config.Config, cfgErr error, args []string) int {
+	var err error
+	switch args[0] {
+	case "migrate":
+		err = runMigrate(ctx, cfg.Database, cfgErr, args[1:])
+	case "config":
+		err = runConfig(cfg, cfgErr, args[1:])
+	default:
+		err = errUsage
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if err != nil {
+		logger.Error(args[0], logging.Err(err))
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(ctx context.Context, db storage.Config, cfgErr error, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	// Parse before connecting, so a typo does not need a database.
+	var n int64
+	switch {
+	case args[0] == "down" && len(args) == 1:
+		n = 1
+	case args[0] == "down" && len(args) == 2, args[0] == "goto" && len(args) == 2:
+		v, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || v < 0 {
+			return errUsage
+		}
+		n = v
+	case (args[0] == "up" || args[0] == "status") && len(args) == 1:
+	default:
+		return errUsage
+	}
+
+	if cfgErr != nil {
+		return cfgErr
+	}
+	pool, err := storage.Open(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	r := migrate.Embedded(pool)
+	switch args[0] {
+	case "up":
+		return r.Up(ctx)
+	case "down":
+		return r.Down(ctx, int(n))
+	case "goto":
+		return r.Goto(ctx, n)
+	}
+	entries, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, e := range entries {
+		applied := "-"
+		if e.AppliedAt != nil {
+			applied = e.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.Version, e.Name, e.State, applied)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return migrate.Mismatch(entries)
+}
+
+func runConfig(cfg config.Config, cfgErr error, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errUsage
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		return err
+	}
+	return cfgErr
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/domain"
+)
+
+// TokenPrefix marks tokens issued by the service so they are easy to spot in
+// configs and secret scanners.
+const TokenPrefix = "prs_"
+
+// Actor is the authenticated caller: either a user or a service account.
+type Actor struct {
+	UserID         string
+	ServiceAccount string
+	// TokenID is zero for the bootstrap token.
+	TokenID int64
+	Role    domain.Role
+}
+
+// Name identifies the actor in logs and attribution columns.
+func (a Actor) Name() string {
+	if a.UserID != "" {
+		return "user:" + a.UserID
+	}
+	return "service:" + a.ServiceAccount
+}
+
+type ctxKey struct{}
+
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, ctxKey{}, a)
+}
+
+// ActorFrom returns the actor attached by the middleware, if any.
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(ctxKey{}).(Actor)
+	return a, ok
+}
+
+// GenerateToken returns a new random token and the hash to store for it.
+func GenerateToken() (string, []byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken is what gets stored and looked up. Tokens carry 256 bits of
+// entropy, so a plain SHA-256 is enough; no salt or slow hash is needed.
+func HashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Authenticator resolves a bearer token into an actor. It returns an error for
+// unknown, revoked or expired tokens.
+type Authenticator func(ctx context.Context, token string) (Actor, error)
+
+// BearerToken extracts the token from an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// Middleware rejects requests without a valid bearer token and attaches the
+// actor to the request context. A non-empty bootstrap token is accepted as an
+// admin service account, so the first real tokens can be issued. fail writes
+// the response for a rejected request; err is nil when no token was sent.
+func Middleware(authenticate Authenticator, bootstrap string, fail func(http.ResponseWriter, *http.Request, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := BearerToken(r)
+			if !ok {
+				fail(w, r, nil)
+				return
+			}
+			if bootstrap != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bootstrap)) == 1 {
+				next.ServeHTTP(w, r.WithContext(WithActor(r.Context(), Actor{ServiceAccount: "bootstrap", Role: domain.RoleAdmin})))
+				return
+			}
+			actor, err := authenticate(r.Context(), token)
+			if err != nil {
+				fail(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithActor(r.Context(), actor)))
+		})
+	}
+}
@@ -0,0 +1,9 @@
+package auth
+
+type Config struct {
+	Enabled bool `yaml:"enabled" env:"AUTH_ENABLED"`
+	// BootstrapToken, when set, authenticates as an admin service account.
+	BootstrapToken string `yaml:"bootstrap_token" env:"AUTH_BOOTSTRAP_TOKEN" secret:"true"`
+	// JWT enables SSO tokens alongside API tokens when a JWKS is configured.
+	JWT JWTConfig `yaml:"jwt"`
+}
@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// missRefetchInterval limits how often an unknown kid forces a reload, so
+// tokens with made-up key ids cannot hammer the identity provider.
+const missRefetchInterval = 30 * time.Second
+
+// Failed reloads are retried after failedReloadBackoff*2^(n-1) for the n-th
+// failure in a row, at most maxFailedReloadBackoff. Until then the previous
+// keys stay in use, and requests do not wait on an identity provider that is
+// down.
+const (
+	failedReloadBackoff    = 5 * time.Second
+	maxFailedReloadBackoff = 5 * time.Minute
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// JWKS is a JSON Web Key Set read from a file or URL. Keys are cached and
+// reloaded every refresh interval, or earlier when a token names a key id
+// that is not in the cache. Reloads run one at a time and never hold up
+// requests that the cached keys can already answer.
+type JWKS struct {
+	file    string
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	group   singleflight.Group
+
+	mu       sync.RWMutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+	missAt   time.Time
+	// triedAt is the last reload attempt; failures counts the failed ones
+	// since the last success, and lastErr is the latest failure.
+	triedAt  time.Time
+	failures int
+	lastErr  error
+}
+
+func NewJWKS(file, url string, refresh time.Duration) *JWKS {
+	return &JWKS{file: file, url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Key returns the public key for kid. An empty kid matches the only key of a
+// single-key set. Stale keys keep being served while a background reload
+// replaces them; only a cold cache or an unknown kid waits for the reload.
+func (k *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	now := time.Now()
+	k.mu.RLock()
+	cold, stale, backingOff := k.keys == nil, now.Sub(k.loadedAt) > k.refresh, k.backingOff(now)
+	k.mu.RUnlock()
+	switch {
+	case backingOff:
+	case cold:
+		if err := k.reload(ctx); err != nil {
+			return nil, err
+		}
+	case stale:
+		k.group.DoChan("reload", k.load)
+	}
+
+	k.mu.RLock()
+	if k.keys == nil {
+		defer k.mu.RUnlock()
+		return nil, k.lastErr
+	}
+	key, ok := k.lookup(kid)
+	k.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	k.mu.Lock()
+	if now.Sub(k.missAt) < missRefetchInterval || k.backingOff(now) {
+		k.mu.Unlock()
+		return nil, errUnknownKey
+	}
+	k.missAt = now
+	k.mu.Unlock()
+	if err := k.reload(ctx); err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+func (k *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// backingOff reports whether the last reload failed too recently to retry.
+// The caller holds k.mu.
+func (k *JWKS) backingOff(now time.Time) bool {
+	if k.failures == 0 {
+		return false
+	}
+	wait := maxFailedReloadBackoff
+	if shift := k.failures - 1; shift < 16 && failedReloadBackoff<<shift < wait {
+		wait = failedReloadBackoff << shift
+	}
+	return now.Sub(k.triedAt) < wait
+}
+
+// reload waits for the shared reload, starting one if none is running. The
+// reload itself does not depend on ctx: a caller that gives up stops waiting
+// but neither cancels the fetch nor counts as a failure.
+func (k *JWKS) reload(ctx context.Context) error {
+	select {
+	case res := <-k.group.DoChan("reload", k.load):
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load fetches the set and replaces the cached keys; on failure the previous
+// keys stay in use.
+func (k *JWKS) load() (any, error) {
+	keys, err := k.read(context.Background())
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.triedAt = now
+	if err != nil {
+		k.failures, k.lastErr = k.failures+1, err
+		return nil, err
+	}
+	k.keys, k.loadedAt, k.failures, k.lastErr = keys, now, 0, nil
+	return nil, nil
+}
+
+func (k *JWKS) read(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	raw, err := k.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load jwks: %w", err)
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+	return keys, nil
+}
+
+func (k *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	if k.file != "" {
+		return os.ReadFile(k.file)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", k.url, res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS keeps the RSA and EC signing keys of a set and skips the rest.
+func parseJWKS(raw []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch j.Kty {
+		case "RSA":
+			key, err = rsaKey(j)
+		case "EC":
+			key, err = ecKey(j)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", j.Kid, err)
+		}
+		keys[j.Kid] = key
+	}
+	return keys, nil
+}
+
+func rsaKey(j jwk) (*rsa.PublicKey, error) {
+	n, err := b64Int(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := b64Int(j.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 {
+		return nil, errors.New("bad RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func ecKey(j jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch j.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+	}
+	x, err := b64Int(j.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := b64Int(j.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig describes how SSO-issued JWTs are validated and mapped onto actors.
+type JWTConfig struct {
+	// JWKSFile or JWKSURL locates the signing keys; the file wins if both are set.
+	// Audience must be set along with them; see config.Validate.
+	JWKSFile string `yaml:"jwks_file" env:"AUTH_JWKS_FILE"`
+	JWKSURL  string `yaml:"jwks_url" env:"AUTH_JWKS_URL"`
+	// JWKSRefresh is how long fetched keys are trusted before reloading.
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" env:"AUTH_JWKS_REFRESH"`
+	Issuer      string        `yaml:"issuer" env:"AUTH_JWT_ISSUER"`
+	Audience    string        `yaml:"audience" env:"AUTH_JWT_AUDIENCE"`
+	// UserClaim holds the user_id, RoleClaim a role name or list of names.
+	UserClaim string `yaml:"user_claim" env:"AUTH_JWT_USER_CLAIM"`
+	RoleClaim string `yaml:"role_claim" env:"AUTH_JWT_ROLE_CLAIM"`
+	// RoleMap translates IdP role or group names, and once set only mapped
+	// names count. Without a map, names that equal a role are used as is.
+	RoleMap map[string]domain.Role `yaml:"role_map" env:"AUTH_JWT_ROLE_MAP"`
+	Leeway  time.Duration          `yaml:"leeway" env:"AUTH_JWT_LEEWAY"`
+}
+
+func (c JWTConfig) Enabled() bool { return c.JWKSFile != "" || c.JWKSURL != "" }
+
+// rolePriority orders roles so the strongest role in a token wins.
+var rolePriority = map[domain.Role]int{domain.RoleBot: 1, domain.RoleMember: 2, domain.RoleTeamLead: 3, domain.RoleAdmin: 4}
+
+type JWTValidator struct {
+	cfg    JWTConfig
+	keys   *JWKS
+	parser *jwt.Parser
+}
+
+func NewJWTValidator(cfg JWTConfig) *JWTValidator {
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "sub"
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "roles"
+	}
+	if cfg.JWKSRefresh <= 0 {
+		cfg.JWKSRefresh = 15 * time.Minute
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTValidator{cfg: cfg, keys: NewJWKS(cfg.JWKSFile, cfg.JWKSURL, cfg.JWKSRefresh), parser: jwt.NewParser(opts...)}
+}
+
+// LooksLikeJWT tells JWTs apart from opaque API tokens.
+func LooksLikeJWT(token string) bool {
+	return !strings.HasPrefix(token, TokenPrefix) && strings.Count(token, ".") == 2
+}
+
+// Validate checks the signature and the standard claims and maps the token
+// onto an actor. Rejected tokens yield an UNAUTHORIZED error; failing to load
+// the key set at all yields a plain error.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (Actor, error) {
+	var keyErr error
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.keys.Key(ctx, kid)
+		if err != nil && !errors.Is(err, errUnknownKey) {
+			keyErr = err
+		}
+		return key, err
+	})
+	if keyErr != nil {
+		return Actor{}, keyErr
+	}
+	if err != nil {
+		return Actor{}, fmt.Errorf("%s: %w", domain.ErrUnauthorized, err)
+	}
+	user, _ := claims[v.cfg.UserClaim].(string)
+	if user == "" {
+		return Actor{}, fmt.Errorf("%s: claim %q missing", domain.ErrUnauthorized, v.cfg.UserClaim)
+	}
+	return Actor{UserID: user, Role: v.role(claims[v.cfg.RoleClaim])}, nil
+}
+
+// role picks the strongest role named in the claim; tokens without a known
+// role act as members. With a role map only its names are trusted, so an IdP
+// group that happens to be called "admin" grants nothing.
+func (v *JWTValidator) role(claim any) domain.Role {
+	var names []string
+	switch c := claim.(type) {
+	case string:
+		names = strings.Fields(c)
+	case []any:
+		for _, n := range c {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	best := domain.Role("")
+	for _, n := range names {
+		r, ok := v.cfg.RoleMap[n]
+		if !ok && len(v.cfg.RoleMap) == 0 {
+			r = domain.Role(n)
+		}
+		if rolePriority[r] > rolePriority[best] {
+			best = r
+		}
+	}
+	if best == "" {
+		return domain.RoleMember
+	}
+	return best
+}
+
+// Authenticator validates JWTs itself and hands every other token to next.
+func (v *JWTValidator) Authenticator(next Authenticator) Authenticator {
+	return func(ctx context.Context, token string) (Actor, error) {
+		if LooksLikeJWT(token) {
+			return v.Validate(ctx, token)
+		}
+		return next(ctx, token)
+	}
+}
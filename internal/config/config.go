@@ -0,0 +1,166 @@
+// Package config assembles the service configuration from defaults, an
+// optional YAML file and environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/digest"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/github"
+	"github.com/example/avito-pr-service/internal/gitlab"
+	"github.com/example/avito-pr-service/internal/health"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/example/avito-pr-service/internal/notify"
+	"github.com/example/avito-pr-service/internal/outbox"
+	"github.com/example/avito-pr-service/internal/service"
+	"github.com/example/avito-pr-service/internal/storage"
+	"github.com/example/avito-pr-service/internal/tracing"
+	"github.com/example/avito-pr-service/internal/webhook"
+)
+
+// FileEnv names the variable holding the path of the configuration file.
+const FileEnv = "CONFIG_FILE"
+
+// Config is everything the service reads at startup. Each field's yaml tag
+// is its key in the file, its env tag the variable overriding it.
+type Config struct {
+	HTTP     HTTP           `yaml:"http"`
+	Database storage.Config `yaml:"database"`
+	Log      logging.Config `yaml:"log"`
+	Tracing  tracing.Config `yaml:"tracing"`
+	Auth     auth.Config    `yaml:"auth"`
+	Features Features       `yaml:"features"`
+	Teams    TeamDefaults   `yaml:"team_defaults"`
+	Health   health.Config  `yaml:"health"`
+	Migrate  migrate.Config `yaml:"migrate"`
+	Webhook  webhook.Config `yaml:"webhook"`
+	Outbox   outbox.Config  `yaml:"outbox"`
+	GitHub   github.Config  `yaml:"github"`
+	GitLab   gitlab.Config  `yaml:"gitlab"`
+	Notify   notify.Config  `yaml:"notify"`
+	Digest   digest.Config  `yaml:"digest"`
+}
+
+type HTTP struct {
+	Addr              string        `yaml:"addr" env:"HTTP_ADDR"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	// ShutdownTimeout bounds draining open connections on shutdown, after
+	// health's drain delay.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+}
+
+// Features switch off optional parts of the service. An integration that is
+// switched on still needs its own settings, such as NOTIFY_WEBHOOK_URL.
+type Features struct {
+	Metrics    bool `yaml:"metrics" env:"METRICS_ENABLED"`
+	GitHubSync bool `yaml:"github_sync" env:"GITHUB_SYNC_ENABLED"`
+	Notify     bool `yaml:"notify" env:"NOTIFY_ENABLED"`
+	Digest     bool `yaml:"digest" env:"DIGEST_ENABLED"`
+}
+
+// TeamDefaults are the settings of teams that neither set nor inherit them.
+type TeamDefaults struct {
+	ReviewerCount int                       `yaml:"reviewer_count" env:"TEAM_DEFAULT_REVIEWER_COUNT"`
+	Strategy      domain.AssignmentStrategy `yaml:"assignment_strategy" env:"TEAM_DEFAULT_STRATEGY"`
+	MergePolicy   domain.MergePolicy        `yaml:"merge_policy" env:"TEAM_DEFAULT_MERGE_POLICY"`
+}
+
+func (t TeamDefaults) Settings() domain.EffectiveTeamSettings {
+	return domain.EffectiveTeamSettings{ReviewerCount: t.ReviewerCount, Strategy: t.Strategy, MergePolicy: t.MergePolicy}
+}
+
+func Default() Config {
+	teams := service.DefaultTeamSettings()
+	return Config{
+		HTTP: HTTP{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   10 * time.Second,
+		},
+		Database: storage.DefaultConfig(),
+		Log:      logging.DefaultConfig(),
+		Tracing:  tracing.DefaultConfig(),
+		Features: Features{Metrics: true, GitHubSync: true, Notify: true, Digest: true},
+		Teams:    TeamDefaults{ReviewerCount: teams.ReviewerCount, Strategy: teams.Strategy, MergePolicy: teams.MergePolicy},
+		Health:   health.DefaultConfig(),
+		Migrate:  migrate.DefaultConfig(),
+		Webhook:  webhook.DefaultConfig(),
+		Outbox:   outbox.DefaultConfig(),
+		GitHub:   github.DefaultConfig(),
+		Notify:   notify.DefaultConfig(),
+		Digest:   digest.DefaultConfig(),
+	}
+}
+
+// Load starts from Default, then applies the YAML file at path unless path
+// is empty, then the environment. A variable KEY may instead name a file in
+// KEY_FILE, which wins and is how secrets are usually mounted. Load does not
+// validate the result; see Validate.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := databaseFromParts(&cfg.Database); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// databaseFromParts builds the database URL from the variables the postgres
+// image takes, as long as DATABASE_URL itself is not set.
+func databaseFromParts(cfg *storage.Config) error {
+	if _, ok, err := lookupEnv("DATABASE_URL"); ok || err != nil {
+		return err
+	}
+	parts := map[string]string{}
+	for _, key := range []string{"POSTGRES_USER", "POSTGRES_PASSWORD", "POSTGRES_DB", "DB_HOST", "DB_PORT", "DB_SSLMODE"} {
+		v, ok, err := lookupEnv(key)
+		if err != nil {
+			return err
+		}
+		if !ok && key != "DB_SSLMODE" {
+			return nil
+		}
+		parts[key] = v
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(parts["POSTGRES_USER"], parts["POSTGRES_PASSWORD"]),
+		Host:   parts["DB_HOST"] + ":" + parts["DB_PORT"],
+		Path:   "/" + parts["POSTGRES_DB"],
+	}
+	if ssl := parts["DB_SSLMODE"]; ssl != "" {
+		u.RawQuery = "sslmode=" + url.QueryEscape(ssl)
+	}
+	cfg.URL = u.String()
+	return nil
+}
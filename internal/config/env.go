@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// lookupEnv returns KEY, or the trimmed contents of the file named by
+// KEY_FILE if that is set. Empty variables count as unset.
+func lookupEnv(key string) (string, bool, error) {
+	if file := os.Getenv(key + "_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+		}
+		return strings.TrimSpace(string(b)), true, nil
+	}
+	v := os.Getenv(key)
+	return v, v != "", nil
+}
+
+// applyEnv sets every field of the struct cfg points to that has an env tag
+// and a variable, descending into nested structs.
+func applyEnv(cfg any) error {
+	return applyEnvValue(reflect.ValueOf(cfg).Elem())
+}
+
+func applyEnvValue(v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, f := t.Field(i), v.Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			if f.Kind() == reflect.Struct {
+				if err := applyEnvValue(f); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		raw, ok, err := lookupEnv(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(f, raw); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses raw into f. Lists are comma-separated and maps are
+// comma-separated key=value pairs.
+func setValue(f reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	if f.Addr().Type().Implements(textUnmarshalerType) {
+		return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		list := reflect.MakeSlice(f.Type(), 0, 0)
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := setValue(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		f.Set(list)
+	case reflect.Map:
+		m := reflect.MakeMap(f.Type())
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(pair, "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := setValue(elem, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(name).Convert(f.Type().Key()), elem)
+		}
+		f.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
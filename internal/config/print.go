@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// Print writes c as a configuration file would hold it, each setting
+// followed by the variable that overrides it. Fields tagged secret:"true"
+// are replaced by <redacted>; secret:"url" only loses its password.
+func (c Config) Print(w io.Writer) error {
+	redact(reflect.ValueOf(&c).Elem())
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return err
+	}
+	annotate(&doc, reflect.TypeFor[Config]())
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := v.Field(i)
+		switch t.Field(i).Tag.Get("secret") {
+		case "true":
+			if f.String() != "" {
+				f.SetString(redacted)
+			}
+		case "url":
+			if u, err := url.Parse(f.String()); err == nil {
+				f.SetString(u.Redacted())
+			} else {
+				f.SetString(redacted)
+			}
+		default:
+			if f.Kind() == reflect.Struct {
+				redact(f)
+			}
+		}
+	}
+}
+
+// annotate comments each key of the mapping n, which encodes a value of
+// type t, with the field's environment variable.
+func annotate(n *yaml.Node, t reflect.Type) {
+	fields := map[string]reflect.StructField{}
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		fields[name] = t.Field(i)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			continue
+		}
+		if env := field.Tag.Get("env"); env != "" && len(value.Content) == 0 {
+			value.LineComment = env
+		} else if env != "" {
+			// A block list or map starts on the next line.
+			key.LineComment = env
+		} else if field.Type.Kind() == reflect.Struct && value.Kind == yaml.MappingNode {
+			annotate(value, field.Type)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/example/avito-pr-service/internal/tracing"
+)
+
+// Validate reports every setting the service cannot start with, naming each
+// by its key in the configuration file.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			fail("%s must be positive, got %s", name, d)
+		}
+	}
+	atLeastOne := func(name string, n int) {
+		if n < 1 {
+			fail("%s must be at least 1, got %d", name, n)
+		}
+	}
+
+	if c.HTTP.Addr == "" {
+		fail("http.addr is empty")
+	}
+	positive("http.read_header_timeout", c.HTTP.ReadHeaderTimeout)
+	positive("http.read_timeout", c.HTTP.ReadTimeout)
+	positive("http.write_timeout", c.HTTP.WriteTimeout)
+	positive("http.idle_timeout", c.HTTP.IdleTimeout)
+	positive("http.shutdown_timeout", c.HTTP.ShutdownTimeout)
+
+	if _, err := pgxpool.ParseConfig(c.Database.URL); err != nil {
+		// The parse error may quote the URL, password included.
+		fail("database.url does not parse")
+	}
+	if c.Database.MaxConns < 0 || c.Database.MinConns < 0 {
+		fail("database.max_conns and database.min_conns must not be negative")
+	} else if c.Database.MaxConns > 0 && c.Database.MinConns > c.Database.MaxConns {
+		fail("database.min_conns %d exceeds database.max_conns %d", c.Database.MinConns, c.Database.MaxConns)
+	}
+	if c.Database.MaxConnLifetime < 0 || c.Database.MaxConnIdleTime < 0 {
+		fail("database.max_conn_lifetime and database.max_conn_idle_time must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterConsole:
+	default:
+		fail("tracing.exporter %q is not one of none, otlp, stdout, console", c.Tracing.Exporter)
+	}
+
+	for name, role := range c.Auth.JWT.RoleMap {
+		switch role {
+		case domain.RoleAdmin, domain.RoleTeamLead, domain.RoleMember, domain.RoleBot:
+		default:
+			fail("auth.jwt.role_map: %s maps to unknown role %q", name, role)
+		}
+	}
+	if c.Auth.JWT.Enabled() && c.Auth.JWT.Audience == "" {
+		// Without it a token the same IdP issued for another service passes.
+		fail("auth.jwt.audience is required when auth.jwt.jwks_file or auth.jwt.jwks_url is set")
+	}
+	if c.Auth.JWT.Leeway < 0 {
+		fail("auth.jwt.leeway must not be negative")
+	}
+
+	if n := c.Teams.ReviewerCount; n < 0 || n > 10 {
+		fail("team_defaults.reviewer_count must be between 0 and 10, got %d", n)
+	}
+	switch c.Teams.Strategy {
+	case domain.StrategyRandom, domain.StrategyLeastLoaded:
+	default:
+		fail("team_defaults.assignment_strategy %q is not one of random, least_loaded", c.Teams.Strategy)
+	}
+	switch c.Teams.MergePolicy {
+	case domain.MergeAny, domain.MergeRequireReviewers:
+	default:
+		fail("team_defaults.merge_policy %q is not one of any, require_reviewers", c.Teams.MergePolicy)
+	}
+
+	positive("health.ping_timeout", c.Health.PingTimeout)
+	if s := c.Health.Saturation; s <= 0 || s > 1 {
+		fail("health.pool_saturation must be in (0, 1], got %g", s)
+	}
+	if c.Health.DrainDelay < 0 {
+		fail("health.drain_delay must not be negative")
+	}
+
+	if c.Migrate.Check != migrate.CheckStrict && c.Migrate.Check != migrate.CheckWarn {
+		fail("migrate.schema_check %q is not one of strict, warn", c.Migrate.Check)
+	}
+
+	atLeastOne("webhook.max_attempts", c.Webhook.MaxAttempts)
+	positive("webhook.backoff_base", c.Webhook.BaseBackoff)
+	positive("webhook.backoff_max", c.Webhook.MaxBackoff)
+	positive("webhook.timeout", c.Webhook.Timeout)
+	positive("webhook.poll_interval", c.Webhook.PollInterval)
+	atLeastOne("webhook.batch_size", c.Webhook.BatchSize)
+
+	if err := c.Outbox.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	positive("outbox.backoff_base", c.Outbox.BaseBackoff)
+	positive("outbox.backoff_max", c.Outbox.MaxBackoff)
+	positive("outbox.webhook_timeout", c.Outbox.Timeout)
+	positive("outbox.poll_interval", c.Outbox.PollInterval)
+	atLeastOne("outbox.batch_size", c.Outbox.BatchSize)
+	positive("outbox.lease", c.Outbox.Lease)
+
+	positive("github.timeout", c.GitHub.Timeout)
+
+	positive("notify.timeout", c.Notify.Timeout)
+
+	if (c.Digest.SMTPHost == "") != (c.Digest.From == "") {
+		fail("digest.smtp_host and digest.from must be set together")
+	}
+	if p := c.Digest.SMTPPort; p < 1 || p > 65535 {
+		fail("digest.smtp_port %d is not a port", p)
+	}
+	positive("digest.interval", c.Digest.Interval)
+	positive("digest.smtp_timeout", c.Digest.Timeout)
+
+	return errors.Join(errs...)
+}
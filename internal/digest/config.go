@@ -0,0 +1,26 @@
+package digest
+
+import "time"
+
+type Config struct {
+	// SMTPHost and From are required; the digest job is off without them.
+	SMTPHost string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	// SMTPUsername and SMTPPassword enable PLAIN auth, which net/smtp only
+	// performs over TLS or to localhost.
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	From         string `yaml:"from" env:"SMTP_FROM"`
+	// TemplatesDir optionally holds subject.tmpl, body.txt.tmpl and
+	// body.html.tmpl replacing the built-in templates.
+	TemplatesDir string `yaml:"templates_dir" env:"DIGEST_TEMPLATES_DIR"`
+	// Interval is how often schedules are checked for due digests.
+	Interval time.Duration `yaml:"interval" env:"DIGEST_INTERVAL"`
+	Timeout  time.Duration `yaml:"smtp_timeout" env:"SMTP_TIMEOUT"`
+}
+
+func (c Config) Enabled() bool { return c.SMTPHost != "" && c.From != "" }
+
+func DefaultConfig() Config {
+	return Config{SMTPPort: 587, Interval: time.Minute, Timeout: 30 * time.Second}
+}
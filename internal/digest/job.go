@@ -0,0 +1,147 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+	_ "time/tzdata" // user timezones must resolve in minimal images too
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("digest")
+
+// Sender delivers one rendered digest.
+type Sender interface {
+	Send(ctx context.Context, to, subject, text, html string) error
+}
+
+// Job emails users their open reviews on the schedule they chose. Each
+// schedule has one slot per day or week at the user's local hour; a slot is
+// due once it has passed, unless it predates the last change of the user's
+// preferences. Digests are claimed in the database before sending, so
+// replicas do not send twice, and a failed send is released to be tried
+// again on the next check.
+type Job struct {
+	cfg    Config
+	r      *repo.Repo
+	sender Sender
+	tmpl   *Templates
+}
+
+func NewJob(cfg Config, r *repo.Repo, sender Sender) (*Job, error) {
+	tmpl, err := LoadTemplates(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Job{cfg: cfg, r: r, sender: sender, tmpl: tmpl}, nil
+}
+
+// Run sends due digests every cfg.Interval until ctx is cancelled.
+func (j *Job) Run(ctx context.Context) {
+	t := time.NewTicker(j.cfg.Interval)
+	defer t.Stop()
+	for {
+		if _, err := j.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			logger.ErrorContext(ctx, "run failed", logging.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce sends the digests due at now and reports how many went out. Users
+// without open reviews are skipped; their slot still counts as done.
+func (j *Job) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	prefs, err := j.r.ScheduledDigests(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, p := range prefs {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			logger.WarnContext(ctx, "bad timezone", "user_id", p.UserID, logging.Err(err))
+			continue
+		}
+		slot := Slot(domain.DigestSchedule(p.Schedule), p.Hour, time.Weekday(p.Weekday), now.In(loc))
+		if slot.Before(p.UpdatedAt) || (p.LastSentAt != nil && !slot.After(*p.LastSentAt)) {
+			continue
+		}
+		ok, err := j.r.ClaimDigest(ctx, p.UserID, slot)
+		if err != nil {
+			return sent, err
+		}
+		if !ok {
+			continue
+		}
+		delivered, err := j.send(ctx, p, now.In(loc))
+		if err != nil {
+			logger.WarnContext(ctx, "send failed", "user_id", p.UserID, logging.Err(err))
+			if err := j.r.ReleaseDigest(context.WithoutCancel(ctx), p.UserID, slot, p.LastSentAt); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if delivered {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+func (j *Job) send(ctx context.Context, p repo.DigestPrefRow, now time.Time) (bool, error) {
+	rows, err := j.r.PRsForReviewer(ctx, p.UserID)
+	if err != nil {
+		return false, err
+	}
+	authors := map[string]string{}
+	d := Data{Username: p.Username, Schedule: p.Schedule, Now: now}
+	for _, row := range rows {
+		if row.Status != string(domain.PROpen) {
+			continue
+		}
+		name, ok := authors[row.Author]
+		if !ok {
+			name, _, _, err = j.r.GetUser(ctx, row.Author)
+			if errors.Is(err, repo.ErrNotFound) {
+				name = row.Author
+			} else if err != nil {
+				return false, err
+			}
+			authors[row.Author] = name
+		}
+		d.Reviews = append(d.Reviews, Review{ID: row.ID, Name: row.Name, Author: name, CreatedAt: row.CreatedAt.In(now.Location()), Age: now.Sub(row.CreatedAt)})
+	}
+	if len(d.Reviews) == 0 {
+		return false, nil
+	}
+	sort.SliceStable(d.Reviews, func(a, b int) bool { return d.Reviews[a].CreatedAt.Before(d.Reviews[b].CreatedAt) })
+	subject, text, html, err := j.tmpl.Render(d)
+	if err != nil {
+		return false, err
+	}
+	return true, j.sender.Send(ctx, p.Email, subject, text, html)
+}
+
+// Slot is the latest scheduled time at or before now, which carries the
+// user's timezone. Daily slots fall on every day at hour, weekly ones on
+// weekday at hour.
+func Slot(schedule domain.DigestSchedule, hour int, weekday time.Weekday, now time.Time) time.Time {
+	slot := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	step := 1
+	if schedule == domain.DigestWeekly {
+		slot = slot.AddDate(0, 0, -int((now.Weekday()-weekday+7)%7))
+		step = 7
+	}
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -step)
+	}
+	return slot
+}
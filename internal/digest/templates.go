@@ -0,0 +1,125 @@
+package digest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data is what the digest templates are executed with. Times are in the
+// recipient's timezone.
+type Data struct {
+	Username string
+	Schedule string
+	Now      time.Time
+	Reviews  []Review
+}
+
+// Review is one open PR waiting for the recipient, oldest first.
+type Review struct {
+	ID        string
+	Name      string
+	Author    string
+	CreatedAt time.Time
+	Age       time.Duration
+}
+
+const (
+	defaultSubject = `{{len .Reviews}} pull request{{if ne (len .Reviews) 1}}s{{end}} waiting for your review`
+	defaultText    = `Hi {{.Username}},
+
+{{len .Reviews}} open pull request{{if ne (len .Reviews) 1}}s are{{else}} is{{end}} waiting for your review as of {{.Now.Format "2006-01-02 15:04 MST"}}:
+{{range .Reviews}}
+- {{.Name}} ({{.ID}}) by {{.Author}}, open for {{age .Age}}{{end}}
+
+You get this {{.Schedule}} digest because you subscribed to it.
+`
+	defaultHTML = `<p>Hi {{.Username}},</p>
+<p>{{len .Reviews}} open pull request{{if ne (len .Reviews) 1}}s are{{else}} is{{end}} waiting for your review as of {{.Now.Format "2006-01-02 15:04 MST"}}:</p>
+<table>
+<tr><th>Pull request</th><th>Author</th><th>Open for</th></tr>
+{{range .Reviews}}<tr><td>{{.Name}} <small>{{.ID}}</small></td><td>{{.Author}}</td><td>{{age .Age}}</td></tr>
+{{end}}</table>
+<p><small>You get this {{.Schedule}} digest because you subscribed to it.</small></p>
+`
+)
+
+// Templates render the digest subject and its plain-text and HTML bodies;
+// the HTML body is escaped by html/template. Besides the builtins, templates
+// can call age, which prints a duration as e.g. "3d 4h".
+type Templates struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// LoadTemplates parses the built-in templates, replacing each with the
+// matching file in dir (subject.tmpl, body.txt.tmpl, body.html.tmpl) where
+// it exists. An empty dir keeps the built-ins.
+func LoadTemplates(dir string) (*Templates, error) {
+	read := func(name, fallback string) (string, error) {
+		if dir == "" {
+			return fallback, nil
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return fallback, nil
+		}
+		return string(b), err
+	}
+	funcs := map[string]any{"age": formatAge}
+	var ts Templates
+	src, err := read("subject.tmpl", defaultSubject)
+	if err != nil {
+		return nil, err
+	}
+	if ts.subject, err = template.New("subject").Funcs(funcs).Parse(strings.TrimSpace(src)); err != nil {
+		return nil, fmt.Errorf("digest: subject template: %w", err)
+	}
+	if src, err = read("body.txt.tmpl", defaultText); err != nil {
+		return nil, err
+	}
+	if ts.text, err = template.New("text").Funcs(funcs).Parse(src); err != nil {
+		return nil, fmt.Errorf("digest: text template: %w", err)
+	}
+	if src, err = read("body.html.tmpl", defaultHTML); err != nil {
+		return nil, err
+	}
+	if ts.html, err = htmltemplate.New("html").Funcs(funcs).Parse(src); err != nil {
+		return nil, fmt.Errorf("digest: html template: %w", err)
+	}
+	return &ts, nil
+}
+
+func (ts *Templates) Render(d Data) (subject, text, html string, err error) {
+	var sb, tb, hb bytes.Buffer
+	if err := ts.subject.Execute(&sb, d); err != nil {
+		return "", "", "", err
+	}
+	if err := ts.text.Execute(&tb, d); err != nil {
+		return "", "", "", err
+	}
+	if err := ts.html.Execute(&hb, d); err != nil {
+		return "", "", "", err
+	}
+	// A subject must stay on one header line.
+	return strings.Join(strings.Fields(sb.String()), " "), tb.String(), hb.String(), nil
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd %dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
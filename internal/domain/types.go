@@ -6,35 +6,229 @@ type TeamMember struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	IsActive bool   `json:"is_active"`
+	// Role is the member's role in this team; empty means MemberRoleMember.
+	Role MemberRole `json:"role,omitempty"`
 }
 
+// MemberRole is a user's role in one team. Only leads of a team or one of
+// its ancestors act as team_lead for it.
+type MemberRole string
+
+const (
+	MemberRoleMember MemberRole = "member"
+	MemberRoleLead   MemberRole = "lead"
+)
+
 type Team struct {
 	TeamName string       `json:"team_name"`
 	Members  []TeamMember `json:"members"`
 }
 
+type AssignmentStrategy string
+
+const (
+	StrategyRandom      AssignmentStrategy = "random"
+	StrategyLeastLoaded AssignmentStrategy = "least_loaded"
+)
+
+type MergePolicy string
+
+const (
+	// MergeAny lets a PR merge regardless of its reviewers.
+	MergeAny MergePolicy = "any"
+	// MergeRequireReviewers blocks merging until the PR has its required reviewer count.
+	MergeRequireReviewers MergePolicy = "require_reviewers"
+)
+
+// TeamSettings are a team's own overrides; nil fields are inherited from the parent.
+type TeamSettings struct {
+	ReviewerCount *int                `json:"reviewer_count"`
+	Strategy      *AssignmentStrategy `json:"assignment_strategy"`
+	MergePolicy   *MergePolicy        `json:"merge_policy"`
+}
+
+// EffectiveTeamSettings are the settings after inheritance and defaults.
+type EffectiveTeamSettings struct {
+	ReviewerCount int                `json:"reviewer_count"`
+	Strategy      AssignmentStrategy `json:"assignment_strategy"`
+	MergePolicy   MergePolicy        `json:"merge_policy"`
+}
+
+type TeamNode struct {
+	TeamName string     `json:"team_name"`
+	Parent   string     `json:"parent_team_name,omitempty"`
+	Children []TeamNode `json:"children"`
+}
+
+// TeamStats counts PRs targeting the team itself (Own) and its whole subtree (Total).
+type TeamStats struct {
+	TeamName string        `json:"team_name"`
+	Own      TeamStatsPart `json:"own"`
+	Total    TeamStatsPart `json:"total"`
+}
+
+type TeamStatsPart struct {
+	OpenPRs     int `json:"open_prs"`
+	MergedPRs   int `json:"merged_prs"`
+	Assignments int `json:"assignments"`
+}
+
+// AssignmentStat counts reviewer assignments in one group. Count is split by
+// outcome: Open, Completed (merged) and Closed assignments are still held,
+// Reassigned and Removed ones ended before the PR did. Grouping fields not
+// requested are empty; Week is the Monday starting the week.
+type AssignmentStat struct {
+	UserID     string `json:"user_id,omitempty"`
+	TeamName   string `json:"team_name,omitempty"`
+	Week       string `json:"week,omitempty"`
+	Count      int    `json:"count"`
+	Open       int    `json:"open"`
+	Completed  int    `json:"completed"`
+	Closed     int    `json:"closed"`
+	Reassigned int    `json:"reassigned"`
+	Removed    int    `json:"removed"`
+}
+
+// Percentiles summarise durations in seconds over Count samples; they are
+// zero when Count is.
+type Percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_seconds"`
+	P90   float64 `json:"p90_seconds"`
+	P99   float64 `json:"p99_seconds"`
+}
+
+// CycleTime describes the PRs opened in one team or one week: how long they
+// waited for their first review and, for merged ones, how long until merge.
+type CycleTime struct {
+	TeamName          string      `json:"team_name,omitempty"`
+	Week              string      `json:"week,omitempty"`
+	PRs               int         `json:"prs"`
+	TimeToFirstReview Percentiles `json:"time_to_first_review"`
+	TimeToMerge       Percentiles `json:"time_to_merge"`
+}
+
+// ReviewerResponseTime is how long a reviewer took from assignment to review.
+type ReviewerResponseTime struct {
+	UserID       string      `json:"user_id"`
+	ResponseTime Percentiles `json:"response_time"`
+}
+
+type CycleTimeReport struct {
+	Teams     []CycleTime            `json:"teams"`
+	Reviewers []ReviewerResponseTime `json:"reviewers"`
+	Weekly    []CycleTime            `json:"weekly"`
+}
+
+// MemberFairness compares a member's share of the team's assignments with
+// their share of the days its members were active. LoadRatio is the first
+// share over the second: 1 is exactly a fair share, and it is nil for members
+// with no active days. Overloaded marks ratios above the report's threshold.
+type MemberFairness struct {
+	UserID          string   `json:"user_id"`
+	Assignments     int      `json:"assignments"`
+	AssignmentShare float64  `json:"assignment_share"`
+	ActiveDays      float64  `json:"active_days"`
+	ActiveShare     float64  `json:"active_share"`
+	LoadRatio       *float64 `json:"load_ratio"`
+	Overloaded      bool     `json:"overloaded"`
+}
+
+// FairnessReport describes how evenly a team shared reviews over a window.
+// Gini is the Gini coefficient of assignments per active day across members
+// who were active: 0 when everyone carried the same load.
+type FairnessReport struct {
+	TeamName         string           `json:"team_name"`
+	From             time.Time        `json:"from"`
+	To               time.Time        `json:"to"`
+	ThresholdPercent float64          `json:"threshold_percent"`
+	Assignments      int              `json:"assignments"`
+	Gini             float64          `json:"gini"`
+	Members          []MemberFairness `json:"members"`
+}
+
+// Membership is a user's membership in one team; it can be deactivated
+// independently of the user's other teams.
+type Membership struct {
+	TeamName string     `json:"team_name"`
+	IsActive bool       `json:"is_active"`
+	Role     MemberRole `json:"role"`
+}
+
 type User struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
-	TeamName string `json:"team_name"`
-	IsActive bool   `json:"is_active"`
+	// TeamName is the primary team, used as the default target of the user's PRs.
+	TeamName string       `json:"team_name"`
+	IsActive bool         `json:"is_active"`
+	Teams    []Membership `json:"teams"`
 }
 
+// ReviewPolicy decides what happens to a user's open reviews when the user
+// leaves or changes a team.
+type ReviewPolicy string
+
+const (
+	// PolicyKeep leaves the user assigned.
+	PolicyKeep ReviewPolicy = "keep"
+	// PolicyReassignOld replaces the user with someone from the team being left.
+	PolicyReassignOld ReviewPolicy = "reassign_old"
+	// PolicyReassignNew replaces the user with someone from the team being joined.
+	PolicyReassignNew ReviewPolicy = "reassign_new"
+)
+
 type PRStatus string
 
 const (
 	PROpen   PRStatus = "OPEN"
 	PRMerged PRStatus = "MERGED"
+	// PRClosed PRs were closed without merging and may be reopened.
+	PRClosed PRStatus = "CLOSED"
 )
 
 type PullRequest struct {
 	ID        string     `json:"pull_request_id"`
 	Name      string     `json:"pull_request_name"`
 	AuthorID  string     `json:"author_id"`
+	TeamName  string     `json:"team_name,omitempty"`
 	Status    PRStatus   `json:"status"`
 	Reviewers []string   `json:"assigned_reviewers"`
 	CreatedAt time.Time  `json:"createdAt,omitempty"`
 	MergedAt  *time.Time `json:"mergedAt,omitempty"`
+	ClosedAt  *time.Time `json:"closedAt,omitempty"`
+	// RequiredReviewers is the reviewer count the team asked for at creation.
+	RequiredReviewers int    `json:"required_reviewers,omitempty"`
+	MergedBy          string `json:"merged_by,omitempty"`
+	// IsDraft PRs have no reviewers until they are marked ready for review.
+	IsDraft bool `json:"is_draft"`
+}
+
+// Role limits what a token may do.
+type Role string
+
+const (
+	// RoleAdmin may do everything, including token management.
+	RoleAdmin Role = "admin"
+	// RoleTeamLead manages the teams the user belongs to and their sub-teams.
+	RoleTeamLead Role = "team_lead"
+	// RoleMember creates PRs as themselves and reassigns themselves off reviews.
+	RoleMember Role = "member"
+	// RoleBot is read-only.
+	RoleBot Role = "bot"
+)
+
+// APIToken describes an issued token; the secret itself is never stored.
+type APIToken struct {
+	ID             int64      `json:"token_id"`
+	Name           string     `json:"name"`
+	UserID         string     `json:"user_id,omitempty"`
+	ServiceAccount string     `json:"service_account,omitempty"`
+	Role           Role       `json:"role"`
+	CreatedBy      string     `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
 }
 
 type PullRequestShort struct {
@@ -44,22 +238,185 @@ type PullRequestShort struct {
 	Status   PRStatus `json:"status"`
 }
 
+// AuditEntry records one mutating call. Result is "ok" or the error code.
+type AuditEntry struct {
+	ID            int64     `json:"audit_id"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	Actor         string    `json:"actor"`
+	Action        string    `json:"action"`
+	Target        string    `json:"target"`
+	PayloadDigest string    `json:"payload_digest"`
+	Result        string    `json:"result"`
+}
+
+// Event types published to webhook subscribers.
+const (
+	EventPRCreated         = "pull_request.created"
+	EventPRReassigned      = "pull_request.reassigned"
+	EventPRMerged          = "pull_request.merged"
+	EventPRDraftChanged    = "pull_request.draft_changed"
+	EventPRClosed          = "pull_request.closed"
+	EventPRReopened        = "pull_request.reopened"
+	EventPRReviewed        = "pull_request.reviewed"
+	EventUserActiveChanged = "user.active_changed"
+	EventTeamDeactivated   = "team.deactivated"
+	// EventAll subscribes to every event type.
+	EventAll = "*"
+)
+
+var EventTypes = []string{EventPRCreated, EventPRReassigned, EventPRMerged, EventPRDraftChanged, EventPRClosed, EventPRReopened, EventPRReviewed, EventUserActiveChanged, EventTeamDeactivated}
+
+// VCS providers whose webhooks the service ingests.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// VCSAccount links a login on a code host to a user.
+type VCSAccount struct {
+	Provider  string    `json:"provider"`
+	Login     string    `json:"login"`
+	UserID    string    `json:"user_id"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewSyncFailure is a reviewer change that could not be pushed to the code
+// host. Operation is "request" or "remove".
+type ReviewSyncFailure struct {
+	ID         int64     `json:"failure_id"`
+	Provider   string    `json:"provider"`
+	PRID       string    `json:"pull_request_id"`
+	EventID    string    `json:"event_id"`
+	Operation  string    `json:"operation"`
+	Logins     []string  `json:"logins"`
+	StatusCode *int      `json:"status_code,omitempty"`
+	Error      string    `json:"error"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// NotifyChannel is where chat notifications about a team's PRs are posted.
+// WebhookURL overrides the default incoming webhook, e.g. for a team on
+// another workspace; being a credential it is never returned by the API.
+type NotifyChannel struct {
+	TeamName      string    `json:"team_name"`
+	Channel       string    `json:"channel"`
+	WebhookURL    string    `json:"-"`
+	CustomWebhook bool      `json:"custom_webhook"`
+	UpdatedBy     string    `json:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// NotifyUser maps a user to a chat handle. Users opted in with DM get direct
+// messages about their own reviews.
+type NotifyUser struct {
+	UserID    string    `json:"user_id"`
+	Handle    string    `json:"handle"`
+	DM        bool      `json:"dm"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type DigestSchedule string
+
+const (
+	DigestOff    DigestSchedule = "off"
+	DigestDaily  DigestSchedule = "daily"
+	DigestWeekly DigestSchedule = "weekly"
+)
+
+// DigestPreferences schedule a user's email digest of pending reviews. Hour
+// and Weekday (0 = Sunday, weekly digests only) are local to Timezone, an
+// IANA zone name.
+type DigestPreferences struct {
+	UserID     string         `json:"user_id"`
+	Email      string         `json:"email"`
+	Schedule   DigestSchedule `json:"schedule"`
+	Hour       int            `json:"hour"`
+	Weekday    int            `json:"weekday"`
+	Timezone   string         `json:"timezone"`
+	LastSentAt *time.Time     `json:"last_sent_at,omitempty"`
+}
+
+// ReviewerChange is one review handed over or dropped by a bulk operation;
+// ReplacedBy is empty when nobody could take the review.
+type ReviewerChange struct {
+	PRID       string `json:"pull_request_id"`
+	OldUserID  string `json:"old_user_id"`
+	ReplacedBy string `json:"replaced_by,omitempty"`
+}
+
+// Event is the envelope delivered to subscribers.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// WebhookSubscription never exposes its secret after creation.
+type WebhookSubscription struct {
+	ID         int64     `json:"subscription_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryDead is a delivery that ran out of attempts.
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+type WebhookDelivery struct {
+	ID             int64          `json:"delivery_id"`
+	SubscriptionID int64          `json:"subscription_id"`
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  *time.Time     `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int           `json:"last_status_code,omitempty"`
+	LastError      string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at,omitempty"`
+}
+
+type BatchPRResult struct {
+	ID    string        `json:"pull_request_id"`
+	PR    *PullRequest  `json:"pr,omitempty"`
+	Error *APIErrorBody `json:"error,omitempty"`
+}
+
 type APIErrorCode string
 
 const (
-	ErrTeamExists  APIErrorCode = "TEAM_EXISTS"
-	ErrPRExists    APIErrorCode = "PR_EXISTS"
-	ErrPRMerged    APIErrorCode = "PR_MERGED"
-	ErrNotAssigned APIErrorCode = "NOT_ASSIGNED"
-	ErrNoCandidate APIErrorCode = "NO_CANDIDATE"
-	ErrNotFound    APIErrorCode = "NOT_FOUND"
+	ErrTeamExists   APIErrorCode = "TEAM_EXISTS"
+	ErrPRExists     APIErrorCode = "PR_EXISTS"
+	ErrPRMerged     APIErrorCode = "PR_MERGED"
+	ErrPRClosed     APIErrorCode = "PR_CLOSED"
+	ErrNotAssigned  APIErrorCode = "NOT_ASSIGNED"
+	ErrNoCandidate  APIErrorCode = "NO_CANDIDATE"
+	ErrNotFound     APIErrorCode = "NOT_FOUND"
+	ErrTeamNotEmpty APIErrorCode = "TEAM_NOT_EMPTY"
+	// ErrTeamHasChildren rejects deleting a team that still has sub-teams.
+	ErrTeamHasChildren APIErrorCode = "TEAM_HAS_CHILDREN"
+	ErrTeamCycle       APIErrorCode = "TEAM_CYCLE"
+	ErrMergeBlocked    APIErrorCode = "MERGE_BLOCKED"
+	ErrUnauthorized    APIErrorCode = "UNAUTHORIZED"
+	ErrForbidden       APIErrorCode = "FORBIDDEN"
 )
 
+type APIErrorBody struct {
+	Code    APIErrorCode `json:"code"`
+	Message string       `json:"message"`
+}
+
 type APIError struct {
-	Error struct {
-		Code    APIErrorCode `json:"code"`
-		Message string       `json:"message"`
-	} `json:"error"`
+	Error APIErrorBody `json:"error"`
 }
 
 func NewAPIError(code APIErrorCode, msg string) APIError {
@@ -0,0 +1,139 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is a failed GitHub API call. StatusCode is zero when no response
+// was received.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return "github: " + e.Message
+	}
+	return fmt.Sprintf("github: %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the call may succeed when repeated: it got no
+// answer or a 5xx one.
+func (e *APIError) Temporary() bool { return e.StatusCode == 0 || e.StatusCode >= 500 }
+
+// RateLimitError is a call rejected by a rate limit that lifts at Reset.
+// It implements outbox.RetryAter, so the relay retries the event no sooner.
+type RateLimitError struct {
+	*APIError
+	Reset time.Time
+}
+
+func (e *RateLimitError) Unwrap() error { return e.APIError }
+
+func (e *RateLimitError) RetryAt() time.Time { return e.Reset }
+
+// Client calls the GitHub REST API. Every call is made once; retrying is up
+// to the caller, which for the reviewer sync is the outbox relay.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+func NewClient(cfg Config) *Client {
+	return &Client{cfg: cfg, http: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// RequestReviewers asks logins to review PR number of owner/repo.
+func (c *Client) RequestReviewers(ctx context.Context, owner, repo string, number int, logins []string) error {
+	return c.do(ctx, http.MethodPost, reviewersPath(owner, repo, number), map[string]any{"reviewers": logins})
+}
+
+// RemoveRequestedReviewers withdraws review requests from logins.
+func (c *Client) RemoveRequestedReviewers(ctx context.Context, owner, repo string, number int, logins []string) error {
+	return c.do(ctx, http.MethodDelete, reviewersPath(owner, repo, number), map[string]any{"reviewers": logins})
+}
+
+func reviewersPath(owner, repo string, number int) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/pulls/" + strconv.Itoa(number) + "/requested_reviewers"
+}
+
+// do makes one call. A failed one returns an *APIError, or a *RateLimitError
+// when GitHub asked to wait.
+func (c *Client) do(ctx context.Context, method, path string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.cfg.APIURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.cfg.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pr-reviewer-service")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	res, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &APIError{Message: err.Error()}
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	apiErr := &APIError{StatusCode: res.StatusCode, Message: errorMessage(msg)}
+	now := time.Now()
+	if wait, limited := rateLimitWait(res.Header, res.StatusCode, now); limited {
+		return &RateLimitError{APIError: apiErr, Reset: now.Add(wait)}
+	}
+	return apiErr
+}
+
+// rateLimitWait reports whether the answer is a rate limit rejection and how
+// long to wait: Retry-After for secondary limits, otherwise until
+// X-RateLimit-Reset once the primary limit is used up.
+func rateLimitWait(h http.Header, status int, now time.Time) (time.Duration, bool) {
+	if status != http.StatusForbidden && status != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now)+time.Second, 0), true
+		}
+		return time.Minute, true
+	}
+	if status == http.StatusTooManyRequests {
+		return time.Minute, true
+	}
+	return 0, false
+}
+
+// errorMessage extracts "message" from a GitHub error body.
+func errorMessage(body []byte) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,28 @@
+package github
+
+import "time"
+
+type Config struct {
+	// WebhookSecret verifies X-Hub-Signature-256; ingestion is off without it.
+	WebhookSecret string `yaml:"webhook_secret" env:"GITHUB_WEBHOOK_SECRET" secret:"true"`
+	// APIToken authenticates reviewer sync calls; the sync is off without it.
+	APIToken string `yaml:"api_token" env:"GITHUB_API_TOKEN" secret:"true"`
+	// APIURL is the REST API root, e.g. https://github.example.com/api/v3 for
+	// GitHub Enterprise.
+	APIURL string `yaml:"api_url" env:"GITHUB_API_URL"`
+	// Timeout bounds one API call. Failed calls are retried by the outbox
+	// relay with its own backoff.
+	Timeout time.Duration `yaml:"timeout" env:"GITHUB_API_TIMEOUT"`
+}
+
+func (c Config) Enabled() bool { return c.WebhookSecret != "" }
+
+// SyncEnabled reports whether assigned reviewers are pushed back to GitHub.
+func (c Config) SyncEnabled() bool { return c.APIToken != "" }
+
+func DefaultConfig() Config {
+	return Config{
+		APIURL:  "https://api.github.com",
+		Timeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,162 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/outbox"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("github")
+
+// Sync operations recorded in the failure log.
+const (
+	OpRequest = "request"
+	OpRemove  = "remove"
+)
+
+// ReviewerSync is an outbox sink that mirrors reviewer changes on PRs
+// ingested from GitHub as review requests there. Reviewers are translated to
+// logins through the linked accounts; reviewers without one are skipped.
+//
+// Each call is made once. Network errors, 5xx answers and rate limits fail
+// the sink, so the relay retries the event with its backoff or once the
+// limit resets. Other GitHub failures are final: the local assignment
+// stands and the failure is logged to review_sync_failures.
+type ReviewerSync struct {
+	client *Client
+	r      *repo.Repo
+}
+
+func NewReviewerSync(client *Client, r *repo.Repo) *ReviewerSync {
+	return &ReviewerSync{client: client, r: r}
+}
+
+func (s *ReviewerSync) Name() string { return "github_reviewers" }
+
+func (s *ReviewerSync) Publish(ctx context.Context, m outbox.Message) error {
+	var ev struct {
+		Data struct {
+			PR         domain.PullRequest `json:"pull_request"`
+			OldUserID  string             `json:"old_user_id"`
+			ReplacedBy string             `json:"replaced_by"`
+			IsDraft    bool               `json:"is_draft"`
+			// Changes lists the reviewers a team deactivation replaced or
+			// dropped, across PRs.
+			Changes []domain.ReviewerChange `json:"changes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(m.Payload, &ev); err != nil {
+		return err
+	}
+	if m.Type == domain.EventTeamDeactivated {
+		for _, c := range ev.Data.Changes {
+			if err := s.reassign(ctx, m.EventID, c.PRID, c.OldUserID, c.ReplacedBy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	pr := ev.Data.PR
+	owner, repoName, number, ok := ParsePRID(pr.ID)
+	if !ok {
+		return nil
+	}
+	switch m.Type {
+	case domain.EventPRCreated:
+		return s.sync(ctx, m.EventID, pr.ID, OpRequest, owner, repoName, number, pr.Reviewers)
+	case domain.EventPRDraftChanged:
+		if ev.Data.IsDraft {
+			return nil
+		}
+		return s.sync(ctx, m.EventID, pr.ID, OpRequest, owner, repoName, number, pr.Reviewers)
+	case domain.EventPRReassigned:
+		return s.reassign(ctx, m.EventID, pr.ID, ev.Data.OldUserID, ev.Data.ReplacedBy)
+	}
+	return nil
+}
+
+// reassign removes the review request of oldUser on a GitHub PR and, unless
+// the review was dropped, requests newUser instead. PRs from other providers
+// are skipped. A retry repeats the calls that already went through, which
+// GitHub accepts again.
+func (s *ReviewerSync) reassign(ctx context.Context, eventID, prID, oldUser, newUser string) error {
+	owner, repoName, number, ok := ParsePRID(prID)
+	if !ok {
+		return nil
+	}
+	if err := s.sync(ctx, eventID, prID, OpRemove, owner, repoName, number, []string{oldUser}); err != nil {
+		return err
+	}
+	if newUser == "" {
+		return nil
+	}
+	return s.sync(ctx, eventID, prID, OpRequest, owner, repoName, number, []string{newUser})
+}
+
+// sync applies op to the logins of userIDs. It returns a cancelled context,
+// a database error or a GitHub failure worth retrying.
+func (s *ReviewerSync) sync(ctx context.Context, eventID, prID, op, owner, repoName string, number int, userIDs []string) error {
+	logins := make([]string, 0, len(userIDs))
+	for _, uid := range userIDs {
+		login, err := s.r.VCSAccountLogin(ctx, domain.ProviderGitHub, uid)
+		if errors.Is(err, repo.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logins = append(logins, login)
+	}
+	if len(logins) == 0 {
+		return nil
+	}
+	var err error
+	if op == OpRemove {
+		err = s.client.RemoveRequestedReviewers(ctx, owner, repoName, number, logins)
+	} else {
+		err = s.client.RequestReviewers(ctx, owner, repoName, number, logins)
+	}
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var limited *RateLimitError
+	var apiErr *APIError
+	if errors.As(err, &limited) || errors.As(err, &apiErr) && apiErr.Temporary() {
+		logger.WarnContext(ctx, "reviewer sync will be retried", "op", op, "logins", logins, "pull_request_id", prID, logging.Err(err))
+		return err
+	}
+	logger.WarnContext(ctx, "reviewer sync failed", "op", op, "logins", logins, "pull_request_id", prID, logging.Err(err))
+	f := repo.SyncFailureRow{Provider: domain.ProviderGitHub, PRID: prID, EventID: eventID, Operation: op, Logins: logins, Error: err.Error()}
+	if apiErr != nil && apiErr.StatusCode != 0 {
+		f.StatusCode = &apiErr.StatusCode
+	}
+	return s.r.InsertSyncFailure(ctx, f)
+}
+
+// ParsePRID splits a pull_request_id of the form "owner/repo#number" as
+// created by webhook ingestion.
+func ParsePRID(id string) (owner, repo string, number int, ok bool) {
+	path, num, found := strings.Cut(id, "#")
+	if !found {
+		return "", "", 0, false
+	}
+	owner, repo, found = strings.Cut(path, "/")
+	if !found || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", 0, false
+	}
+	number, err := strconv.Atoi(num)
+	if err != nil || number <= 0 {
+		return "", "", 0, false
+	}
+	return owner, repo, number, true
+}
@@ -0,0 +1,97 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Headers GitHub sends with every webhook delivery.
+const (
+	HeaderEvent     = "X-GitHub-Event"
+	HeaderDelivery  = "X-GitHub-Delivery"
+	HeaderSignature = "X-Hub-Signature-256"
+)
+
+// Pull request actions the service reacts to.
+const (
+	ActionOpened           = "opened"
+	ActionClosed           = "closed"
+	ActionReopened         = "reopened"
+	ActionReadyForReview   = "ready_for_review"
+	ActionConvertedToDraft = "converted_to_draft"
+	// ActionSubmitted is the pull_request_review action for a new review.
+	ActionSubmitted = "submitted"
+)
+
+// VerifySignature checks header, "sha256=" followed by the hex HMAC-SHA256 of
+// body keyed with secret, in constant time.
+func VerifySignature(secret string, body []byte, header string) bool {
+	got, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	sig, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+type Account struct {
+	Login string `json:"login"`
+}
+
+type Repository struct {
+	FullName string `json:"full_name"`
+}
+
+type PullRequest struct {
+	Number int     `json:"number"`
+	Title  string  `json:"title"`
+	Draft  bool    `json:"draft"`
+	Merged bool    `json:"merged"`
+	User   Account `json:"user"`
+}
+
+// PullRequestEvent is the part of a "pull_request" webhook payload the
+// service uses.
+type PullRequestEvent struct {
+	Action      string      `json:"action"`
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+	Repository  Repository  `json:"repository"`
+	Sender      Account     `json:"sender"`
+}
+
+// PRID is the pull_request_id the PR is stored under: "owner/repo#number".
+func (e PullRequestEvent) PRID() string {
+	n := e.Number
+	if n == 0 {
+		n = e.PullRequest.Number
+	}
+	return e.Repository.FullName + "#" + strconv.Itoa(n)
+}
+
+type Review struct {
+	State string  `json:"state"`
+	User  Account `json:"user"`
+}
+
+// PullRequestReviewEvent is the part of a "pull_request_review" webhook
+// payload the service uses.
+type PullRequestReviewEvent struct {
+	Action      string      `json:"action"`
+	Review      Review      `json:"review"`
+	PullRequest PullRequest `json:"pull_request"`
+	Repository  Repository  `json:"repository"`
+}
+
+// PRID is the pull_request_id the reviewed PR is stored under.
+func (e PullRequestReviewEvent) PRID() string {
+	return e.Repository.FullName + "#" + strconv.Itoa(e.PullRequest.Number)
+}
@@ -0,0 +1,9 @@
+package gitlab
+
+type Config struct {
+	// WebhookToken is the secret token GitLab sends in X-Gitlab-Token;
+	// ingestion is off without it.
+	WebhookToken string `yaml:"webhook_token" env:"GITLAB_WEBHOOK_TOKEN" secret:"true"`
+}
+
+func (c Config) Enabled() bool { return c.WebhookToken != "" }
@@ -0,0 +1,91 @@
+package gitlab
+
+import (
+	"crypto/subtle"
+	"strconv"
+)
+
+// Headers GitLab sends with every webhook request.
+const (
+	HeaderEvent = "X-Gitlab-Event"
+	HeaderToken = "X-Gitlab-Token"
+	HeaderUUID  = "X-Gitlab-Event-UUID"
+)
+
+// EventMergeRequest is the X-Gitlab-Event value of merge request webhooks.
+const EventMergeRequest = "Merge Request Hook"
+
+// Merge request actions the service reacts to.
+const (
+	ActionOpen   = "open"
+	ActionClose  = "close"
+	ActionReopen = "reopen"
+	ActionMerge  = "merge"
+	ActionUpdate = "update"
+)
+
+// VerifyToken compares the X-Gitlab-Token header with the configured token in
+// constant time.
+func VerifyToken(token, header string) bool {
+	return header != "" && subtle.ConstantTimeCompare([]byte(token), []byte(header)) == 1
+}
+
+type User struct {
+	Username string `json:"username"`
+}
+
+type Project struct {
+	ID                int64  `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type MergeRequest struct {
+	IID    int    `json:"iid"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	Action string `json:"action"`
+	Draft  bool   `json:"draft"`
+	// WorkInProgress is what GitLab versions before 13.x send instead of Draft.
+	WorkInProgress bool `json:"work_in_progress"`
+}
+
+// IsDraft reports the draft flag of either GitLab generation.
+func (m MergeRequest) IsDraft() bool { return m.Draft || m.WorkInProgress }
+
+type BoolChange struct {
+	Previous bool `json:"previous"`
+	Current  bool `json:"current"`
+}
+
+// Changes lists the attributes an "update" action changed.
+type Changes struct {
+	Draft          *BoolChange `json:"draft"`
+	WorkInProgress *BoolChange `json:"work_in_progress"`
+}
+
+// DraftChange returns the new draft flag when the update toggled it.
+func (c Changes) DraftChange() (bool, bool) {
+	for _, ch := range []*BoolChange{c.Draft, c.WorkInProgress} {
+		if ch != nil && ch.Previous != ch.Current {
+			return ch.Current, true
+		}
+	}
+	return false, false
+}
+
+// MergeRequestEvent is the part of a "Merge Request Hook" payload the service
+// uses. User is whoever triggered the action, which for "open" is the author.
+type MergeRequestEvent struct {
+	ObjectKind       string       `json:"object_kind"`
+	User             User         `json:"user"`
+	Project          Project      `json:"project"`
+	ObjectAttributes MergeRequest `json:"object_attributes"`
+	Changes          Changes      `json:"changes"`
+}
+
+// PRID is the pull_request_id the merge request is stored under:
+// "project_id!iid". Numeric project IDs survive renames and transfers, unlike
+// the project path.
+func (e MergeRequestEvent) PRID() string {
+	return strconv.FormatInt(e.Project.ID, 10) + "!" + strconv.Itoa(e.ObjectAttributes.IID)
+}
@@ -0,0 +1,19 @@
+package health
+
+import "time"
+
+type Config struct {
+	// PingTimeout bounds each readiness check's database round trip.
+	PingTimeout time.Duration `yaml:"ping_timeout" env:"READY_PING_TIMEOUT"`
+	// Saturation is the share of the pool's connections in use, in (0, 1],
+	// at which readiness reports degraded: still serving, but close to
+	// queueing.
+	Saturation float64 `yaml:"pool_saturation" env:"READY_POOL_SATURATION"`
+	// DrainDelay is how long shutdown keeps serving with readiness failing,
+	// so load balancers notice before connections are drained.
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+}
+
+func DefaultConfig() Config {
+	return Config{PingTimeout: time.Second, Saturation: 0.9, DrainDelay: 5 * time.Second}
+}
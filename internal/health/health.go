@@ -0,0 +1,167 @@
+// Package health serves the liveness and readiness probes. Liveness only says
+// the process is serving; readiness checks that the database answers, that
+// its schema matches the migrations built in, and how busy the pool is.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Probe and check statuses. Degraded still counts as ready.
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusDraining    = "draining"
+)
+
+var logger = logging.Logger("health")
+
+var errNotChecked = errors.New("not checked: database unreachable")
+
+// Checker answers the probes for one pool. Drain makes it report not ready
+// for the rest of the process's life.
+type Checker struct {
+	cfg      Config
+	pool     *pgxpool.Pool
+	schema   *migrate.Runner
+	draining atomic.Bool
+}
+
+func New(pool *pgxpool.Pool, cfg Config) *Checker {
+	return &Checker{cfg: cfg, pool: pool, schema: migrate.Embedded(pool)}
+}
+
+// Drain flips readiness to failing ahead of shutdown.
+func (c *Checker) Drain() {
+	if !c.draining.Swap(true) {
+		logger.Info("draining: readiness now failing")
+	}
+}
+
+// DrainDelay is how long to keep serving after Drain before shutting down.
+func (c *Checker) DrainDelay() time.Duration { return c.cfg.DrainDelay }
+
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type DatabaseCheck struct {
+	Check
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type SchemaCheck struct {
+	Check
+	Version  int64 `json:"version"`
+	Expected int64 `json:"expected"`
+}
+
+type PoolCheck struct {
+	Check
+	Acquired   int32   `json:"acquired"`
+	Max        int32   `json:"max"`
+	Saturation float64 `json:"saturation"`
+}
+
+// Report is the /readyz body. Status is the worst of the checks, or
+// draining once Drain has been called.
+type Report struct {
+	Status string `json:"status"`
+	Checks struct {
+		Database DatabaseCheck `json:"database"`
+		Schema   SchemaCheck   `json:"schema"`
+		Pool     PoolCheck     `json:"pool"`
+	} `json:"checks"`
+}
+
+// Ready runs the readiness checks.
+func (c *Checker) Ready(ctx context.Context) Report {
+	var rep Report
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.PingTimeout)
+	defer cancel()
+
+	db := &rep.Checks.Database
+	start := time.Now()
+	err := c.pool.Ping(ctx)
+	db.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	db.Check = result(err)
+
+	schema := &rep.Checks.Schema
+	schema.Expected = c.schema.Latest()
+	if err != nil {
+		err = errNotChecked
+	} else {
+		var entries []migrate.Entry
+		if entries, err = c.schema.Status(ctx); err == nil {
+			schema.Version = migrate.Version(entries)
+			err = migrate.Mismatch(entries)
+		}
+	}
+	schema.Check = result(err)
+
+	stat := c.pool.Stat()
+	pool := &rep.Checks.Pool
+	pool.Acquired, pool.Max = stat.AcquiredConns(), stat.MaxConns()
+	pool.Saturation = float64(pool.Acquired) / float64(pool.Max)
+	pool.Status = StatusOK
+	if pool.Saturation >= c.cfg.Saturation {
+		pool.Status = StatusDegraded
+	}
+
+	switch {
+	case c.draining.Load():
+		rep.Status = StatusDraining
+	case db.Status != StatusOK || schema.Status != StatusOK:
+		rep.Status = StatusUnavailable
+	default:
+		rep.Status = pool.Status
+	}
+	return rep
+}
+
+func result(err error) Check {
+	if err != nil {
+		return Check{Status: StatusUnavailable, Error: err.Error()}
+	}
+	return Check{Status: StatusOK}
+}
+
+// Livez answers 200 for as long as the process serves HTTP. It checks no
+// dependency, so a database outage never gets the process restarted.
+func (c *Checker) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+// Readyz answers 200 when ready or degraded and 503 otherwise, with the
+// Report as body.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	rep := c.Ready(r.Context())
+	code := http.StatusOK
+	if rep.Status != StatusOK && rep.Status != StatusDegraded {
+		code = http.StatusServiceUnavailable
+		if rep.Status == StatusUnavailable {
+			logger.WarnContext(r.Context(), "not ready", "database", rep.Checks.Database.Error, "schema", rep.Checks.Schema.Error)
+		}
+	}
+	writeJSON(w, r, code, rep)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.WarnContext(r.Context(), "probe write failed", logging.Err(err))
+	}
+}
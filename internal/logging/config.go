@@ -0,0 +1,15 @@
+package logging
+
+import "log/slog"
+
+type Config struct {
+	// Level is the minimum level of components without an entry in Levels.
+	Level slog.Level `yaml:"level" env:"LOG_LEVEL"`
+	// Levels overrides Level per component, the name passed to Logger such
+	// as "repo" or "service".
+	Levels map[string]slog.Level `yaml:"levels" env:"LOG_LEVELS"`
+}
+
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo}
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// HeaderRequestID carries the request ID in both directions.
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestID bounds accepted request IDs; longer ones are replaced.
+const maxRequestID = 128
+
+var accessLog = Logger("http")
+
+// Middleware assigns each request an ID, taken from X-Request-ID when it is
+// a sane token and generated otherwise, echoes it in the response and puts
+// it in the context. Once the request is served it writes an access log line
+// with the route, status, duration and whatever AddAccessAttrs added.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		entry := &accessEntry{}
+		ctx := context.WithValue(WithRequestID(r.Context(), id), accessKey{}, entry)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := ""
+		if rc := chi.RouteContext(r.Context()); rc != nil {
+			route = rc.RoutePattern()
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", ww.BytesWritten()),
+		}
+		accessLog.LogAttrs(ctx, level, "request", append(attrs, entry.attrs()...)...)
+	})
+}
+
+type accessKey struct{}
+
+type accessEntry struct {
+	mu    sync.Mutex
+	extra []slog.Attr
+}
+
+func (e *accessEntry) attrs() []slog.Attr {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.extra
+}
+
+// AddAccessAttrs adds attributes to the access log line of the request ctx
+// belongs to, such as the actor once authentication has identified it.
+func AddAccessAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if e, ok := ctx.Value(accessKey{}).(*accessEntry); ok {
+		e.mu.Lock()
+		e.extra = append(e.extra, attrs...)
+		e.mu.Unlock()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
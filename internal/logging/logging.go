@@ -0,0 +1,92 @@
+// Package logging provides JSON structured logging on log/slog: loggers per
+// component with their own levels, and request IDs carried in the context
+// and added to every line logged with it.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+type state struct {
+	handler slog.Handler
+	cfg     Config
+}
+
+var current atomic.Pointer[state]
+
+func init() { Setup(os.Stderr, DefaultConfig()) }
+
+// Setup sends every Logger, and the slog and log package defaults, to JSON
+// lines on w filtered by cfg. Loggers created earlier follow the change.
+func Setup(w io.Writer, cfg Config) {
+	// The handler passes everything; levels are checked per component.
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug - 4})
+	current.Store(&state{handler: h, cfg: cfg})
+	slog.SetDefault(Logger("app"))
+}
+
+// Logger returns the logger of a component, which is recorded on every line
+// as "component" and picks the component's level from the Config.
+func Logger(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// handler resolves the output and level at each call, so package-level
+// loggers created before Setup still honour it.
+type handler struct {
+	component string
+	// with replays WithAttrs and WithGroup calls on the current output.
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	cfg := current.Load().cfg
+	min, ok := cfg.Levels[h.component]
+	if !ok {
+		min = cfg.Level
+	}
+	return level >= min
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	out := current.Load().handler.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, w := range h.with {
+		out = w(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.chain(func(o slog.Handler) slog.Handler { return o.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.chain(func(o slog.Handler) slog.Handler { return o.WithGroup(name) })
+}
+
+func (h *handler) chain(w func(slog.Handler) slog.Handler) slog.Handler {
+	with := append(h.with[:len(h.with):len(h.with)], w)
+	return &handler{component: h.component, with: with}
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Err is the attribute under which errors are logged.
+func Err(err error) slog.Attr { return slog.Any("err", err) }
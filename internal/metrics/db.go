@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/repo"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logger = logging.Logger("metrics")
+
+// scrapeTimeout bounds the queries run for one scrape.
+const scrapeTimeout = 5 * time.Second
+
+// dbCollector reports pgxpool statistics and PR gauges queried on each
+// scrape, so they are always current without a background poller.
+type dbCollector struct {
+	pool *pgxpool.Pool
+	r    *repo.Repo
+
+	acquired, idle, constructing, total, max  *prometheus.Desc
+	acquires, acquireSeconds, canceled, empty *prometheus.Desc
+	created, closedLifetime, closedIdle       *prometheus.Desc
+	openPRs, understaffedPRs                  *prometheus.Desc
+}
+
+func newDBCollector(pool *pgxpool.Pool, r *repo.Repo) *dbCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	return &dbCollector{
+		pool:            pool,
+		r:               r,
+		acquired:        desc("db_pool_acquired_conns", "Connections currently checked out of the pool."),
+		idle:            desc("db_pool_idle_conns", "Idle connections in the pool."),
+		constructing:    desc("db_pool_constructing_conns", "Connections being established."),
+		total:           desc("db_pool_total_conns", "Connections open in the pool."),
+		max:             desc("db_pool_max_conns", "Maximum size of the pool."),
+		acquires:        desc("db_pool_acquires_total", "Successful connection acquisitions."),
+		acquireSeconds:  desc("db_pool_acquire_duration_seconds_total", "Time spent acquiring connections."),
+		canceled:        desc("db_pool_canceled_acquires_total", "Acquisitions canceled by their context."),
+		empty:           desc("db_pool_empty_acquires_total", "Acquisitions that had to wait for a connection."),
+		created:         desc("db_pool_new_conns_total", "Connections opened."),
+		closedLifetime:  desc("db_pool_max_lifetime_destroys_total", "Connections closed for reaching their maximum lifetime."),
+		closedIdle:      desc("db_pool_max_idle_destroys_total", "Connections closed for idling too long."),
+		openPRs:         desc("open_prs", "Open pull requests."),
+		understaffedPRs: desc("understaffed_prs", "Open pull requests out of draft with fewer reviewers than they require."),
+	}
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.acquired, c.idle, c.constructing, c.total, c.max,
+		c.acquires, c.acquireSeconds, c.canceled, c.empty,
+		c.created, c.closedLifetime, c.closedIdle,
+		c.openPRs, c.understaffedPRs,
+	} {
+		ch <- d
+	}
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	for _, g := range []struct {
+		d *prometheus.Desc
+		v float64
+	}{
+		{c.acquired, float64(st.AcquiredConns())},
+		{c.idle, float64(st.IdleConns())},
+		{c.constructing, float64(st.ConstructingConns())},
+		{c.total, float64(st.TotalConns())},
+		{c.max, float64(st.MaxConns())},
+	} {
+		ch <- prometheus.MustNewConstMetric(g.d, prometheus.GaugeValue, g.v)
+	}
+	for _, m := range []struct {
+		d *prometheus.Desc
+		v float64
+	}{
+		{c.acquires, float64(st.AcquireCount())},
+		{c.acquireSeconds, st.AcquireDuration().Seconds()},
+		{c.canceled, float64(st.CanceledAcquireCount())},
+		{c.empty, float64(st.EmptyAcquireCount())},
+		{c.created, float64(st.NewConnsCount())},
+		{c.closedLifetime, float64(st.MaxLifetimeDestroyCount())},
+		{c.closedIdle, float64(st.MaxIdleDestroyCount())},
+	} {
+		ch <- prometheus.MustNewConstMetric(m.d, prometheus.CounterValue, m.v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	open, understaffed, err := c.r.OpenPRCounts(ctx)
+	if err != nil {
+		// Leave the gauges out rather than fail the scrape: pool stats
+		// matter most while the database is struggling.
+		logger.WarnContext(ctx, "count open PRs", logging.Err(err))
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.openPRs, prometheus.GaugeValue, float64(open))
+	ch <- prometheus.MustNewConstMetric(c.understaffedPRs, prometheus.GaugeValue, float64(understaffed))
+}
@@ -0,0 +1,146 @@
+// Package metrics exposes the service's Prometheus metrics: HTTP traffic by
+// route, business outcomes reported by the service, connection pool stats and
+// gauges read from the database at scrape time.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pr_service"
+
+// unmatchedRoute labels requests no route matched, so unknown paths do not
+// each get their own series.
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the collectors on a registry of their own. It implements
+// service.Observer.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	prsCreated     prometheus.Counter
+	prsMerged      prometheus.Counter
+	reassignments  prometheus.Counter
+	rejected       *prometheus.CounterVec
+	deactivations  prometheus.Counter
+	deactivatedPRs *prometheus.CounterVec
+}
+
+// New registers the HTTP and business metrics along with Go runtime, process
+// and database collectors for pool.
+func New(pool *pgxpool.Pool) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "http_requests_total",
+			Help: "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "http_request_duration_seconds",
+			Help:    "HTTP request latency by method, route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		prsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "prs_created_total",
+			Help: "Pull requests created.",
+		}),
+		prsMerged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "prs_merged_total",
+			Help: "Pull requests merged.",
+		}),
+		reassignments: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "reassignments_total",
+			Help: "Reviewers replaced through reassign.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "rejected_calls_total",
+			Help: "Calls that failed with an API error, by audit action and error code; NO_CANDIDATE counts reviews nobody could take over.",
+		}, []string{"action", "code"}),
+		deactivations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "mass_deactivations_total",
+			Help: "Mass deactivations of a team's members.",
+		}),
+		deactivatedPRs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "mass_deactivation_reviews_total",
+			Help: "Open reviews handled by mass deactivations, by outcome: reassigned or removed.",
+		}, []string{"outcome"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.duration,
+		m.prsCreated, m.prsMerged, m.reassignments, m.rejected, m.deactivations, m.deactivatedPRs,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newDBCollector(pool, repo.New(pool)),
+	)
+	for _, o := range []string{"reassigned", "removed"} {
+		m.deactivatedPRs.WithLabelValues(o)
+	}
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware counts and times requests, labelled with the chi route pattern
+// that handled them rather than the raw path.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rc := chi.RouteContext(r.Context()); rc != nil && rc.RoutePattern() != "" {
+			route = rc.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{r.Method, route, strconv.Itoa(status)}
+		m.requests.WithLabelValues(labels...).Inc()
+		m.duration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Committed counts the business outcomes behind committed events.
+func (m *Metrics) Committed(events []domain.Event) {
+	for _, ev := range events {
+		switch ev.Type {
+		case domain.EventPRCreated:
+			m.prsCreated.Inc()
+		case domain.EventPRMerged:
+			m.prsMerged.Inc()
+		case domain.EventPRReassigned:
+			m.reassignments.Inc()
+		case domain.EventTeamDeactivated:
+			m.deactivations.Inc()
+			data, _ := ev.Data.(map[string]any)
+			for _, o := range []string{"reassigned", "removed"} {
+				if n, ok := data[o].(int); ok {
+					m.deactivatedPRs.WithLabelValues(o).Add(float64(n))
+				}
+			}
+		}
+	}
+}
+
+func (m *Metrics) Rejected(action string, code domain.APIErrorCode) {
+	m.rejected.WithLabelValues(action, string(code)).Inc()
+}
@@ -0,0 +1,20 @@
+package migrate
+
+// What a schema mismatch at startup does.
+const (
+	// CheckStrict refuses to start.
+	CheckStrict = "strict"
+	// CheckWarn logs the mismatch and starts anyway.
+	CheckWarn = "warn"
+)
+
+type Config struct {
+	// OnStart applies pending migrations before the service starts serving.
+	OnStart bool `yaml:"on_start" env:"MIGRATE_ON_START"`
+	// Check is CheckStrict or CheckWarn.
+	Check string `yaml:"schema_check" env:"SCHEMA_CHECK"`
+}
+
+func DefaultConfig() Config {
+	return Config{Check: CheckStrict}
+}
@@ -0,0 +1,428 @@
+// Package migrate applies the schema migrations embedded in the binary.
+// Applied versions are recorded with checksums in schema_migrations, and
+// runners in concurrent processes are serialised by an advisory lock.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockKey is the pg_advisory_lock key every runner takes before changing the
+// schema.
+const lockKey int64 = 0x70725f6d6967 // "pr_mig"
+
+var logger = logging.Logger("migrate")
+
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Runner migrates one database to the migrations it was built with.
+type Runner struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Runner, error) {
+	migs, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{pool: pool, migrations: migs}, nil
+}
+
+// Embedded returns a Runner for the migrations compiled into the binary. Those
+// cannot change after the build, so a malformed set is a programming error
+// and panics.
+func Embedded(pool *pgxpool.Pool) *Runner {
+	r, err := New(pool, migrations.FS)
+	if err != nil {
+		panic("embedded migrations: " + err.Error())
+	}
+	return r
+}
+
+// Latest is the version of the newest migration, the one the code expects the
+// schema to be at.
+func (r *Runner) Latest() int64 {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+type State string
+
+const (
+	StateApplied State = "applied"
+	StatePending State = "pending"
+	// StateModified marks an applied migration whose file has been edited
+	// since.
+	StateModified State = "modified"
+	// StateUnknown marks a version applied to the database that the binary
+	// has no migration for, typically one from a newer release.
+	StateUnknown State = "unknown"
+)
+
+type Entry struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	State     State      `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// MismatchError lists how the database differs from the binary's migrations.
+type MismatchError struct {
+	Pending, Modified, Unknown []int64
+}
+
+func (e *MismatchError) Error() string {
+	var parts []string
+	for _, p := range []struct {
+		what     string
+		versions []int64
+	}{{"pending", e.Pending}, {"modified since applied", e.Modified}, {"unknown to this build", e.Unknown}} {
+		if len(p.versions) == 0 {
+			continue
+		}
+		vs := make([]string, len(p.versions))
+		for i, v := range p.versions {
+			vs[i] = strconv.FormatInt(v, 10)
+		}
+		parts = append(parts, p.what+" "+strings.Join(vs, ", "))
+	}
+	return "schema mismatch: " + strings.Join(parts, "; ")
+}
+
+// Mismatch returns a *MismatchError unless every entry is applied.
+func Mismatch(entries []Entry) error {
+	if e := mismatch(entries); e != nil {
+		return e
+	}
+	return nil
+}
+
+func mismatch(entries []Entry) *MismatchError {
+	var e MismatchError
+	for _, en := range entries {
+		switch en.State {
+		case StatePending:
+			e.Pending = append(e.Pending, en.Version)
+		case StateModified:
+			e.Modified = append(e.Modified, en.Version)
+		case StateUnknown:
+			e.Unknown = append(e.Unknown, en.Version)
+		}
+	}
+	if e.Pending == nil && e.Modified == nil && e.Unknown == nil {
+		return nil
+	}
+	return &e
+}
+
+// Version is the highest version applied to the database, 0 if none.
+func Version(entries []Entry) int64 {
+	var v int64
+	for _, en := range entries {
+		if en.State != StatePending && en.Version > v {
+			v = en.Version
+		}
+	}
+	return v
+}
+
+// Status lists every migration known to the binary or recorded in the
+// database, by version.
+func (r *Runner) Status(ctx context.Context) ([]Entry, error) {
+	applied, err := r.readApplied(ctx, r.pool)
+	if err != nil {
+		return nil, err
+	}
+	return r.entries(applied), nil
+}
+
+// Check fails with a *MismatchError if the database is not exactly at the
+// binary's migrations.
+func (r *Runner) Check(ctx context.Context) error {
+	entries, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return Mismatch(entries)
+}
+
+// Up applies every pending migration.
+func (r *Runner) Up(ctx context.Context) error {
+	return r.Goto(ctx, r.Latest())
+}
+
+// Down reverts the n most recently applied migrations.
+func (r *Runner) Down(ctx context.Context, n int) error {
+	return r.locked(ctx, func(conn *pgxpool.Conn, entries []Entry) error {
+		var target int64
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].State != StateApplied {
+				continue
+			}
+			if n == 0 {
+				target = entries[i].Version
+				break
+			}
+			n--
+		}
+		return r.revert(ctx, conn, entries, target)
+	})
+}
+
+// Goto applies or reverts migrations until exactly those up to version are
+// applied. Version 0 reverts them all.
+func (r *Runner) Goto(ctx context.Context, version int64) error {
+	if version != 0 && r.find(version) == nil {
+		return fmt.Errorf("no migration %d", version)
+	}
+	return r.locked(ctx, func(conn *pgxpool.Conn, entries []Entry) error {
+		if err := r.revert(ctx, conn, entries, version); err != nil {
+			return err
+		}
+		return r.apply(ctx, conn, entries, version)
+	})
+}
+
+// locked runs fn holding the migration lock, once schema_migrations is
+// ready and consistent with the binary.
+func (r *Runner) locked(ctx context.Context, fn func(*pgxpool.Conn, []Entry) error) error {
+	conn, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("take migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			// Closing the session is the other way to free the lock.
+			logger.WarnContext(ctx, "release migration lock", logging.Err(err))
+			_ = conn.Conn().Close(context.WithoutCancel(ctx))
+		}
+	}()
+	if err := r.prepare(ctx, conn); err != nil {
+		return err
+	}
+	applied, err := r.readApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	entries := r.entries(applied)
+	// Pending migrations are what runs are for; anything else needs a human.
+	if m := mismatch(entries); m != nil && (m.Modified != nil || m.Unknown != nil) {
+		return m
+	}
+	return fn(conn, entries)
+}
+
+// revert runs the down migrations of the applied versions above target,
+// newest first.
+func (r *Runner) revert(ctx context.Context, conn *pgxpool.Conn, entries []Entry, target int64) error {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if en := entries[i]; en.State == StateApplied && en.Version > target {
+			mig := r.find(en.Version)
+			if err := run(ctx, conn, "down", *mig, mig.Down, `DELETE FROM schema_migrations WHERE version=$1`, mig.Version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apply runs the pending migrations up to target, oldest first.
+func (r *Runner) apply(ctx context.Context, conn *pgxpool.Conn, entries []Entry, target int64) error {
+	for _, en := range entries {
+		if en.State == StatePending && en.Version <= target {
+			mig := r.find(en.Version)
+			if err := run(ctx, conn, "up", *mig, mig.Up, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run executes one direction of a migration and its bookkeeping in one
+// transaction, so a failed migration leaves nothing behind.
+func run(ctx context.Context, conn *pgxpool.Conn, dir string, mig Migration, body, record string, args ...any) error {
+	start := time.Now()
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, body); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, dir, err)
+	}
+	logger.InfoContext(ctx, "migrated", "version", mig.Version, "name", mig.Name, "direction", dir, "duration", time.Since(start))
+	return nil
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    checksum   TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// tableState reports whether schema_migrations exists and whether it is the
+// single-row version/dirty table golang-migrate keeps.
+func tableState(ctx context.Context, q querier) (exists, legacy bool, err error) {
+	err = q.QueryRow(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL,
+            NOT EXISTS (SELECT 1 FROM information_schema.columns
+                WHERE table_schema=current_schema() AND table_name='schema_migrations' AND column_name='checksum')`).Scan(&exists, &legacy)
+	return exists, exists && legacy, err
+}
+
+// prepare creates schema_migrations, converting golang-migrate's table if it
+// finds one: the versions it covers are taken as applied from the current
+// files.
+func (r *Runner) prepare(ctx context.Context, conn *pgxpool.Conn) error {
+	exists, legacy, err := tableState(ctx, conn)
+	if err != nil || (exists && !legacy) {
+		return err
+	}
+	if !exists {
+		_, err := conn.Exec(ctx, createTable)
+		return err
+	}
+	version, err := legacyVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if version != 0 && r.find(version) == nil {
+		return fmt.Errorf("golang-migrate recorded version %d, which this build does not have", version)
+	}
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DROP TABLE schema_migrations`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, createTable); err != nil {
+			return err
+		}
+		for _, mig := range r.migrations {
+			if mig.Version > version {
+				break
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		logger.InfoContext(ctx, "adopted golang-migrate state", "version", version)
+	}
+	return err
+}
+
+// legacyVersion reads golang-migrate's version, refusing a dirty one: a
+// migration failed halfway and needs fixing by hand.
+func legacyVersion(ctx context.Context, q querier) (int64, error) {
+	var version int64
+	var dirty bool
+	err := q.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err == nil && dirty {
+		err = fmt.Errorf("golang-migrate left version %d dirty; repair the schema and force a clean version first", version)
+	}
+	return version, err
+}
+
+type appliedRow struct {
+	name      string
+	checksum  string
+	appliedAt *time.Time
+}
+
+// readApplied returns the recorded versions. Versions adopted from a
+// golang-migrate table not yet converted have no checksum.
+func (r *Runner) readApplied(ctx context.Context, q querier) (map[int64]appliedRow, error) {
+	exists, legacy, err := tableState(ctx, q)
+	if err != nil || !exists {
+		return nil, err
+	}
+	out := map[int64]appliedRow{}
+	if legacy {
+		version, err := legacyVersion(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, mig := range r.migrations {
+			if mig.Version <= version {
+				out[mig.Version] = appliedRow{name: mig.Name}
+			}
+		}
+		if _, ok := out[version]; !ok && version != 0 {
+			out[version] = appliedRow{}
+		}
+		return out, nil
+	}
+	rows, err := q.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int64
+		var a appliedRow
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		out[v] = a
+	}
+	return out, rows.Err()
+}
+
+func (r *Runner) entries(applied map[int64]appliedRow) []Entry {
+	out := make([]Entry, 0, len(r.migrations))
+	for _, mig := range r.migrations {
+		en := Entry{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if a, ok := applied[mig.Version]; ok {
+			en.State, en.AppliedAt = StateApplied, a.appliedAt
+			if a.checksum != "" && a.checksum != mig.Checksum {
+				en.State = StateModified
+			}
+			delete(applied, mig.Version)
+		}
+		out = append(out, en)
+	}
+	for v, a := range applied {
+		out = append(out, Entry{Version: v, Name: a.name, State: StateUnknown, AppliedAt: a.appliedAt})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+func (r *Runner) find(version int64) *Migration {
+	i := sort.Search(len(r.migrations), func(i int) bool { return r.migrations[i].Version >= version })
+	if i < len(r.migrations) && r.migrations[i].Version == version {
+		return &r.migrations[i]
+	}
+	return nil
+}
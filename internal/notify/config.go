@@ -0,0 +1,22 @@
+package notify
+
+import "time"
+
+type Config struct {
+	// WebhookURL is the default Slack or Mattermost incoming webhook. Direct
+	// messages and teams without a webhook of their own post through it; the
+	// notifier is off without it.
+	WebhookURL string `yaml:"webhook_url" env:"NOTIFY_WEBHOOK_URL" secret:"true"`
+	// TemplatesDir optionally holds <kind>.tmpl files replacing the built-in
+	// message templates, see Templates.
+	TemplatesDir string `yaml:"templates_dir" env:"NOTIFY_TEMPLATES_DIR"`
+	// Timeout bounds one post. Failed posts are retried by the outbox relay
+	// with its own backoff.
+	Timeout time.Duration `yaml:"timeout" env:"NOTIFY_TIMEOUT"`
+}
+
+func (c Config) Enabled() bool { return c.WebhookURL != "" }
+
+func DefaultConfig() Config {
+	return Config{Timeout: 10 * time.Second}
+}
@@ -0,0 +1,374 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/outbox"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("notify")
+
+// Notifier is an outbox sink posting chat messages through Slack or
+// Mattermost incoming webhooks. A PR's team channel, inherited from the
+// nearest ancestor team that sets one, hears about assignments,
+// reassignments and merges; reviewers opted in to direct messages hear about
+// their own. A team deactivation is batched into one channel message and one
+// direct message per affected user.
+//
+// Each message is posted once per publish. Network errors, 5xx and 429
+// answers fail the sink, so the relay retries the event with its backoff, or
+// no sooner than a 429's Retry-After. Recipients that got their message are
+// recorded in notify_deliveries, so a retry only posts to the rest. Other
+// failures are logged and the message is dropped: chat is best effort.
+type Notifier struct {
+	cfg  Config
+	r    *repo.Repo
+	tmpl *Templates
+	http *http.Client
+}
+
+func NewNotifier(cfg Config, r *repo.Repo) (*Notifier, error) {
+	tmpl, err := LoadTemplates(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{cfg: cfg, r: r, tmpl: tmpl, http: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (n *Notifier) Name() string { return "notify" }
+
+func (n *Notifier) Publish(ctx context.Context, m outbox.Message) error {
+	var ev struct {
+		Data struct {
+			PR         domain.PullRequest      `json:"pull_request"`
+			OldUserID  string                  `json:"old_user_id"`
+			ReplacedBy string                  `json:"replaced_by"`
+			IsDraft    bool                    `json:"is_draft"`
+			TeamName   string                  `json:"team_name"`
+			Reassigned int                     `json:"reassigned"`
+			Removed    int                     `json:"removed"`
+			Changes    []domain.ReviewerChange `json:"changes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(m.Payload, &ev); err != nil {
+		return err
+	}
+	d := ev.Data
+	dl := &delivery{eventID: m.EventID}
+	switch m.Type {
+	case domain.EventPRCreated:
+		return n.assigned(ctx, dl, d.PR)
+	case domain.EventPRDraftChanged:
+		if d.IsDraft {
+			return nil
+		}
+		return n.assigned(ctx, dl, d.PR)
+	case domain.EventPRReassigned:
+		return n.reassigned(ctx, dl, d.PR, d.OldUserID, d.ReplacedBy)
+	case domain.EventPRMerged:
+		return n.merged(ctx, dl, d.PR)
+	case domain.EventTeamDeactivated:
+		return n.deactivated(ctx, dl, d.TeamName, d.Reassigned, d.Removed, d.Changes)
+	}
+	return nil
+}
+
+// delivery is the event being posted and the recipients that got their
+// message for it, loaded before the first post.
+type delivery struct {
+	eventID string
+	loaded  bool
+	done    []string
+}
+
+func (n *Notifier) assigned(ctx context.Context, dl *delivery, pr domain.PullRequest) error {
+	if len(pr.Reviewers) == 0 {
+		return nil
+	}
+	users, err := n.r.NotifyRecipients(ctx, append([]string{pr.AuthorID}, pr.Reviewers...))
+	if err != nil {
+		return err
+	}
+	data := AssignedData{PR: prData(pr.ID, pr.Name, pr.AuthorID, users), Reviewers: mentions(pr.Reviewers, users)}
+	if err := n.toTeam(ctx, dl, pr, KindAssigned, data); err != nil {
+		return err
+	}
+	for _, uid := range pr.Reviewers {
+		data.Reviewers = mentions([]string{uid}, users)
+		if err := n.toUser(ctx, dl, users[uid], KindAssigned, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) reassigned(ctx context.Context, dl *delivery, pr domain.PullRequest, oldUser, newUser string) error {
+	users, err := n.r.NotifyRecipients(ctx, []string{pr.AuthorID, oldUser, newUser})
+	if err != nil {
+		return err
+	}
+	data := ReassignedData{PR: prData(pr.ID, pr.Name, pr.AuthorID, users), Old: mention(oldUser, users)}
+	if newUser != "" {
+		data.New = mention(newUser, users)
+	}
+	if err := n.toTeam(ctx, dl, pr, KindReassigned, data); err != nil {
+		return err
+	}
+	for _, uid := range []string{oldUser, newUser} {
+		if err := n.toUser(ctx, dl, users[uid], KindReassigned, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) merged(ctx context.Context, dl *delivery, pr domain.PullRequest) error {
+	users, err := n.r.NotifyRecipients(ctx, append([]string{pr.AuthorID}, pr.Reviewers...))
+	if err != nil {
+		return err
+	}
+	data := MergedData{PR: prData(pr.ID, pr.Name, pr.AuthorID, users), Reviewers: mentions(pr.Reviewers, users)}
+	if err := n.toTeam(ctx, dl, pr, KindMerged, data); err != nil {
+		return err
+	}
+	for _, uid := range pr.Reviewers {
+		if err := n.toUser(ctx, dl, users[uid], KindMerged, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deactivated posts the whole deactivation as one team message plus one
+// digest per user who gained or lost reviews.
+func (n *Notifier) deactivated(ctx context.Context, dl *delivery, team string, reassigned, removed int, changes []domain.ReviewerChange) error {
+	prs := map[string]PRData{}
+	var ids []string
+	for _, c := range changes {
+		ids = append(ids, c.OldUserID)
+		if c.ReplacedBy != "" {
+			ids = append(ids, c.ReplacedBy)
+		}
+		if _, ok := prs[c.PRID]; ok {
+			continue
+		}
+		row, err := n.r.GetPR(ctx, c.PRID)
+		if errors.Is(err, repo.ErrNotFound) {
+			prs[c.PRID] = PRData{ID: c.PRID}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		prs[c.PRID] = PRData{ID: row.ID, Name: row.Name, Author: row.Author}
+		ids = append(ids, row.Author)
+	}
+	users, err := n.r.NotifyRecipients(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for id, p := range prs {
+		if p.Author != "" {
+			p.Author = mention(p.Author, users)
+			prs[id] = p
+		}
+	}
+
+	data := DeactivatedData{Team: team, Reassigned: reassigned, Removed: removed}
+	digests := map[string]*DigestData{}
+	var order []string
+	digest := func(uid string) *DigestData {
+		if _, ok := digests[uid]; !ok {
+			digests[uid] = &DigestData{Team: team}
+			order = append(order, uid)
+		}
+		return digests[uid]
+	}
+	for _, c := range changes {
+		change := ChangeData{PR: prs[c.PRID], Old: mention(c.OldUserID, users)}
+		d := digest(c.OldUserID)
+		d.Unassigned = append(d.Unassigned, prs[c.PRID])
+		if c.ReplacedBy != "" {
+			change.New = mention(c.ReplacedBy, users)
+			d := digest(c.ReplacedBy)
+			d.Assigned = append(d.Assigned, prs[c.PRID])
+		}
+		data.Changes = append(data.Changes, change)
+	}
+
+	ch, err := n.r.EffectiveNotifyChannel(ctx, team)
+	if err == nil {
+		if err := n.post(ctx, dl, ch.WebhookURL, ch.Channel, KindDeactivated, data); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, repo.ErrNotFound) {
+		return err
+	}
+	for _, uid := range order {
+		if err := n.toUser(ctx, dl, users[uid], KindDigest, *digests[uid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toTeam posts to the channel of the PR's team, or of its author's primary
+// team when the PR targets none; teams without a channel are skipped.
+func (n *Notifier) toTeam(ctx context.Context, dl *delivery, pr domain.PullRequest, kind string, data any) error {
+	team := pr.TeamName
+	if team == "" {
+		var err error
+		if team, err = n.r.UserTeam(ctx, pr.AuthorID); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+	}
+	ch, err := n.r.EffectiveNotifyChannel(ctx, team)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return n.post(ctx, dl, ch.WebhookURL, ch.Channel, kind, data)
+}
+
+// toUser sends a direct message when u opted in to them.
+func (n *Notifier) toUser(ctx context.Context, dl *delivery, u repo.NotifyUserRow, kind string, data any) error {
+	if !u.DM || u.Handle == "" {
+		return nil
+	}
+	return n.post(ctx, dl, "", "@"+u.Handle, kind, data)
+}
+
+// post renders and sends one message unless its channel already got it. It
+// returns a cancelled context, a database error or a failed send worth
+// retrying; other failures are logged.
+func (n *Notifier) post(ctx context.Context, dl *delivery, webhookURL, channel, kind string, data any) error {
+	if !dl.loaded {
+		done, err := n.r.NotifyDelivered(ctx, dl.eventID)
+		if err != nil {
+			return err
+		}
+		dl.loaded, dl.done = true, done
+	}
+	if slices.Contains(dl.done, channel) {
+		return nil
+	}
+	if webhookURL == "" {
+		webhookURL = n.cfg.WebhookURL
+	}
+	text, err := n.tmpl.Render(kind, data)
+	if err != nil {
+		logger.ErrorContext(ctx, "render failed", "kind", kind, logging.Err(err))
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"channel": channel, "text": text})
+	if err != nil {
+		return err
+	}
+	err = n.send(ctx, webhookURL, body)
+	var retry *retryError
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.As(err, &retry):
+		logger.WarnContext(ctx, "message will be retried", "kind", kind, "channel", channel, logging.Err(err))
+		return err
+	default:
+		logger.WarnContext(ctx, "message not delivered", "kind", kind, "channel", channel, logging.Err(err))
+		return nil
+	}
+	if err := n.r.MarkNotifyDelivered(ctx, dl.eventID, channel); err != nil {
+		return err
+	}
+	dl.done = append(dl.done, channel)
+	return nil
+}
+
+// retryError is a failed send that may succeed when repeated. It implements
+// outbox.RetryAter; at is zero unless the webhook answered with Retry-After.
+type retryError struct {
+	err error
+	at  time.Time
+}
+
+func (e *retryError) Error() string { return e.err.Error() }
+
+func (e *retryError) Unwrap() error { return e.err }
+
+func (e *retryError) RetryAt() time.Time { return e.at }
+
+// send makes one request. Network errors, 5xx and 429 answers come back as
+// a *retryError.
+func (n *Notifier) send(ctx context.Context, webhookURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.http.Do(req)
+	if err != nil {
+		return &retryError{err: err}
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+		return &retryError{err: err, at: retryAfter(res.Header.Get("Retry-After"), time.Now())}
+	}
+	return err
+}
+
+// retryAfter is the time a Retry-After header given in seconds points to,
+// or zero when there is none.
+func retryAfter(header string, now time.Time) time.Time {
+	secs, err := strconv.Atoi(header)
+	if err != nil || secs <= 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(secs) * time.Second)
+}
+
+func prData(id, name, author string, users map[string]repo.NotifyUserRow) PRData {
+	return PRData{ID: id, Name: name, Author: mention(author, users)}
+}
+
+// mention is the user's @handle, or the username when there is none.
+func mention(userID string, users map[string]repo.NotifyUserRow) string {
+	u, ok := users[userID]
+	switch {
+	case !ok:
+		return userID
+	case u.Handle != "":
+		return "@" + u.Handle
+	default:
+		return u.Username
+	}
+}
+
+func mentions(userIDs []string, users map[string]repo.NotifyUserRow) []string {
+	out := make([]string, 0, len(userIDs))
+	for _, uid := range userIDs {
+		out = append(out, mention(uid, users))
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Message kinds. Each has a template executed with the data type noted.
+const (
+	// KindAssigned announces new reviewers (AssignedData).
+	KindAssigned = "assigned"
+	// KindReassigned announces one reviewer handing over to another, or
+	// being dropped when nobody could take over (ReassignedData).
+	KindReassigned = "reassigned"
+	// KindMerged announces a merged PR to its reviewers (MergedData).
+	KindMerged = "merged"
+	// KindDeactivated summarises a team deactivation in the team channel (DeactivatedData).
+	KindDeactivated = "deactivated"
+	// KindDigest is the one direct message a user gets about a team
+	// deactivation, however many of their reviews it moved (DigestData).
+	KindDigest = "digest"
+)
+
+// PRData describes a PR in templates. Author, like every user in template
+// data, is an @handle when the user has one and the username otherwise.
+type PRData struct {
+	ID     string
+	Name   string
+	Author string
+}
+
+type AssignedData struct {
+	PR        PRData
+	Reviewers []string
+}
+
+// ReassignedData describes a reassignment; New is empty when the review was
+// dropped.
+type ReassignedData struct {
+	PR       PRData
+	Old, New string
+}
+
+type MergedData struct {
+	PR        PRData
+	Reviewers []string
+}
+
+// ChangeData is one review moved by a deactivation; New is empty when the
+// review was dropped.
+type ChangeData struct {
+	PR       PRData
+	Old, New string
+}
+
+type DeactivatedData struct {
+	Team                string
+	Reassigned, Removed int
+	Changes             []ChangeData
+}
+
+type DigestData struct {
+	Team       string
+	Assigned   []PRData
+	Unassigned []PRData
+}
+
+var defaultTemplates = map[string]string{
+	KindAssigned:   `{{join .Reviewers ", "}} assigned to review "{{.PR.Name}}" ({{.PR.ID}}) by {{.PR.Author}}`,
+	KindReassigned: `{{if .New}}{{.New}} replaces {{.Old}} as reviewer of{{else}}{{.Old}} no longer reviews{{end}} "{{.PR.Name}}" ({{.PR.ID}}) by {{.PR.Author}}`,
+	KindMerged:     `"{{.PR.Name}}" ({{.PR.ID}}) by {{.PR.Author}} was merged{{if .Reviewers}}; reviewers: {{join .Reviewers ", "}}{{end}}`,
+	KindDeactivated: `Team {{.Team}} was deactivated: {{.Reassigned}} reviews reassigned, {{.Removed}} dropped` +
+		`{{range .Changes}}
+• "{{.PR.Name}}" ({{.PR.ID}}): {{.Old}} → {{if .New}}{{.New}}{{else}}nobody{{end}}{{end}}`,
+	KindDigest: `Team {{.Team}} was deactivated.` +
+		`{{if .Assigned}}
+You now review:{{range .Assigned}}
+• "{{.Name}}" ({{.ID}}) by {{.Author}}{{end}}{{end}}` +
+		`{{if .Unassigned}}
+You no longer review:{{range .Unassigned}}
+• "{{.Name}}" ({{.ID}}) by {{.Author}}{{end}}{{end}}`,
+}
+
+// Templates renders messages with text/template; besides the builtins,
+// templates can call join (strings.Join).
+type Templates struct {
+	t map[string]*template.Template
+}
+
+// LoadTemplates parses the built-in templates, replacing each with
+// dir/<kind>.tmpl where that file exists. An empty dir keeps the built-ins.
+func LoadTemplates(dir string) (*Templates, error) {
+	ts := &Templates{t: make(map[string]*template.Template, len(defaultTemplates))}
+	for kind, text := range defaultTemplates {
+		if dir != "" {
+			b, err := os.ReadFile(filepath.Join(dir, kind+".tmpl"))
+			if err == nil {
+				text = strings.TrimRight(string(b), "\n")
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		t, err := template.New(kind).Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("notify: template %s: %w", kind, err)
+		}
+		ts.t[kind] = t
+	}
+	return ts, nil
+}
+
+func (ts *Templates) Render(kind string, data any) (string, error) {
+	t, ok := ts.t[kind]
+	if !ok {
+		return "", fmt.Errorf("notify: unknown template %q", kind)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+type Config struct {
+	// Sinks lists where events are published: "subscriptions" (queue them for
+	// the webhook subscriptions), "webhook", "stdout" and "file".
+	Sinks []string `yaml:"sinks" env:"OUTBOX_SINKS"`
+	// File is the path the file sink appends to.
+	File string `yaml:"file" env:"OUTBOX_FILE"`
+	// WebhookURL and WebhookSecret configure the webhook sink.
+	WebhookURL    string `yaml:"webhook_url" env:"OUTBOX_WEBHOOK_URL"`
+	WebhookSecret string `yaml:"webhook_secret" env:"OUTBOX_WEBHOOK_SECRET" secret:"true"`
+	// The wait before retry n is BaseBackoff*2^(n-1), capped at MaxBackoff.
+	BaseBackoff time.Duration `yaml:"backoff_base" env:"OUTBOX_BACKOFF_BASE"`
+	MaxBackoff  time.Duration `yaml:"backoff_max" env:"OUTBOX_BACKOFF_MAX"`
+	// Timeout bounds a webhook sink request.
+	Timeout      time.Duration `yaml:"webhook_timeout" env:"OUTBOX_WEBHOOK_TIMEOUT"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL"`
+	// BatchSize is how many events one pass publishes before the published
+	// hook runs; each is claimed on its own, just before it is published.
+	BatchSize int `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE"`
+	// Lease is how long a claimed event is hidden from other relays. It
+	// should outlast publishing one event to every sink; once it runs out
+	// another relay may publish the event again.
+	Lease time.Duration `yaml:"lease" env:"OUTBOX_LEASE"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Sinks:        []string{"subscriptions"},
+		BaseBackoff:  time.Second,
+		MaxBackoff:   5 * time.Minute,
+		Timeout:      10 * time.Second,
+		PollInterval: time.Second,
+		BatchSize:    100,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// Validate checks that every sink is known and has what it needs.
+func (c Config) Validate() error {
+	for _, name := range c.Sinks {
+		switch name {
+		case "subscriptions", "stdout":
+		case "file":
+			if c.File == "" {
+				return fmt.Errorf("outbox: file sink needs OUTBOX_FILE")
+			}
+		case "webhook":
+			if c.WebhookURL == "" {
+				return fmt.Errorf("outbox: webhook sink needs OUTBOX_WEBHOOK_URL")
+			}
+		default:
+			return fmt.Errorf("outbox: unknown sink %q", name)
+		}
+	}
+	return nil
+}
+
+// BuildSinks creates the sinks named in cfg. r backs the subscriptions sink.
+func BuildSinks(cfg Config, r *repo.Repo) ([]Sink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	sinks := make([]Sink, 0, len(cfg.Sinks))
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "subscriptions":
+			sinks = append(sinks, NewSubscriptionSink(r))
+		case "stdout":
+			sinks = append(sinks, NewWriterSink(os.Stdout))
+		case "file":
+			sinks = append(sinks, NewFileSink(cfg.File))
+		case "webhook":
+			sinks = append(sinks, NewWebhookSink(cfg.WebhookURL, cfg.WebhookSecret, cfg.Timeout))
+		}
+	}
+	return sinks, nil
+}
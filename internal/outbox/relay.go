@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("outbox")
+
+// Relay publishes events written to the outbox table. Each event is claimed
+// by leasing its row for Config.Lease right before it is published, so any
+// number of replicas can run a relay without publishing a row concurrently,
+// and is then published outside any transaction. Each sink that publishes an event is recorded at once; a
+// retry of the event only goes to the sinks that failed, after the backoff or
+// at the time a RetryAter error asks for, whichever is later.
+type Relay struct {
+	r     *repo.Repo
+	sinks []Sink
+	cfg   Config
+	wake  chan struct{}
+	// published runs after a batch with sent rows has committed.
+	published func()
+}
+
+type RelayOption func(*Relay)
+
+// WithPublishedHook calls fn after every batch that published events, e.g.
+// webhook.Dispatcher.Notify so queued deliveries go out immediately.
+func WithPublishedHook(fn func()) RelayOption {
+	return func(rl *Relay) { rl.published = fn }
+}
+
+func NewRelay(r *repo.Repo, sinks []Sink, cfg Config, opts ...RelayOption) *Relay {
+	rl := &Relay{r: r, sinks: sinks, cfg: cfg, wake: make(chan struct{}, 1)}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	return rl
+}
+
+// Notify makes Run look for pending events now instead of at the next poll.
+func (rl *Relay) Notify() {
+	select {
+	case rl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes until ctx is cancelled.
+func (rl *Relay) Run(ctx context.Context) {
+	t := time.NewTicker(rl.cfg.PollInterval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := rl.relayBatch(ctx)
+			if err != nil && ctx.Err() == nil {
+				logger.ErrorContext(ctx, "relay failed", logging.Err(err))
+			}
+			if err != nil || n < rl.cfg.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-rl.wake:
+		}
+	}
+}
+
+// relayBatch publishes up to BatchSize due events and reports how many it
+// claimed. Events are claimed one at a time: the lease only has to outlast
+// publishing a single event to every sink, not the whole batch.
+func (rl *Relay) relayBatch(ctx context.Context) (int, error) {
+	n, sent := 0, 0
+	defer func() {
+		if sent > 0 && rl.published != nil {
+			rl.published()
+		}
+	}()
+	for n < rl.cfg.BatchSize {
+		rows, err := rl.r.ClaimOutbox(ctx, 1, rl.cfg.Lease)
+		if err != nil || len(rows) == 0 {
+			return n, err
+		}
+		n++
+		row := rows[0]
+		failed, err := rl.publish(ctx, row)
+		if err != nil {
+			return n, err
+		}
+		if failed != nil {
+			next := time.Now().Add(rl.Backoff(row.Attempts + 1))
+			var ra RetryAter
+			if errors.As(failed, &ra) && ra.RetryAt().After(next) {
+				next = ra.RetryAt()
+			}
+			if err := rl.r.MarkOutboxFailed(ctx, row.ID, failed.Error(), next); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := rl.r.MarkOutboxSent(ctx, row.ID); err != nil {
+			return n, err
+		}
+		sent++
+	}
+	return n, nil
+}
+
+// publish hands the event to every sink that has not published it yet; all
+// of them are tried even when one fails. failed joins the sinks' errors; err
+// is a database error or the cancelled context, which leave the row to be
+// picked up again once its lease runs out.
+func (rl *Relay) publish(ctx context.Context, row repo.OutboxRow) (failed, err error) {
+	m := Message{EventID: row.EventID, Type: row.EventType, Payload: []byte(row.Payload)}
+	var errs []error
+	for _, s := range rl.sinks {
+		if slices.Contains(row.SentSinks, s.Name()) {
+			continue
+		}
+		if err := s.Publish(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+			continue
+		}
+		if err := rl.r.MarkOutboxSinkSent(ctx, row.EventID, s.Name()); err != nil {
+			return nil, err
+		}
+	}
+	return errors.Join(errs...), nil
+}
+
+// Backoff is the wait after the given failed attempt.
+func (rl *Relay) Backoff(attempt int) time.Duration {
+	wait := rl.cfg.BaseBackoff
+	for i := 1; i < attempt && wait < rl.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, rl.cfg.MaxBackoff)
+}
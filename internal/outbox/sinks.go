@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/repo"
+	"github.com/example/avito-pr-service/internal/webhook"
+)
+
+// Message is an event read from the outbox. Payload is the JSON encoded
+// domain.Event.
+type Message struct {
+	EventID string
+	Type    string
+	Payload []byte
+}
+
+// Sink publishes messages. A message is published again only to the sinks
+// that failed on it, but delivery is still at least once: a crash between
+// publishing and recording it, or a lease that runs out mid-batch, repeats a
+// message, so sinks should tolerate duplicates, for instance by keying on
+// EventID. Name identifies the sink in the outbox_sink_state table and must
+// not change between releases.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, m Message) error
+}
+
+// RetryAter is implemented by sink errors that know when publishing can
+// succeed again, such as a rate limit with a reset time. The relay does not
+// retry the event before RetryAt.
+type RetryAter interface {
+	RetryAt() time.Time
+}
+
+// SubscriptionSink queues the event for every matching webhook subscription.
+// Queuing the same event twice is a no-op.
+type SubscriptionSink struct {
+	r *repo.Repo
+}
+
+func NewSubscriptionSink(r *repo.Repo) SubscriptionSink { return SubscriptionSink{r: r} }
+
+func (s SubscriptionSink) Name() string { return "subscriptions" }
+
+func (s SubscriptionSink) Publish(ctx context.Context, m Message) error {
+	_, err := s.r.EnqueueDeliveries(ctx, m.EventID, m.Type, string(m.Payload))
+	return err
+}
+
+// WriterSink writes every message as one JSON line.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink { return &WriterSink{w: w} }
+
+func (s *WriterSink) Name() string { return "stdout" }
+
+func (s *WriterSink) Publish(_ context.Context, m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(append(bytes.TrimSpace(m.Payload), '\n'))
+	return err
+}
+
+// FileSink appends JSON lines to a file, opening it for every message so
+// the file can be rotated underneath.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSink(path string) *FileSink { return &FileSink{path: path} }
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Publish(_ context.Context, m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(bytes.TrimSpace(m.Payload), '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WebhookSink posts every message to a fixed URL, signed like subscription
+// deliveries when a secret is set.
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookSink(url, secret string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{url: url, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Publish(ctx context.Context, m Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(m.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pr-reviewer-service")
+	req.Header.Set(webhook.HeaderEvent, m.Type)
+	req.Header.Set(webhook.HeaderDelivery, m.EventID)
+	if s.secret != "" {
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(s.secret, m.Payload))
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", res.StatusCode)
+	}
+	return nil
+}
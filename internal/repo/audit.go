@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AuditRow struct {
+	ID            int64
+	OccurredAt    pgtype.Timestamptz
+	Actor         string
+	Action        string
+	Target        string
+	PayloadDigest string
+	Result        string
+}
+
+func (r *Repo) InsertAudit(ctx context.Context, a AuditRow) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO audit_log(actor, action, target, payload_digest, result) VALUES ($1,$2,$3,$4,$5)`,
+		a.Actor, a.Action, a.Target, a.PayloadDigest, a.Result)
+	return err
+}
+
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Result string
+	From   time.Time
+	To     time.Time
+	// Limit of zero returns every matching entry.
+	Limit  int
+	Offset int
+}
+
+// EachAudit streams matching entries, oldest first, without loading them all.
+func (r *Repo) EachAudit(ctx context.Context, f AuditFilter, fn func(AuditRow) error) error {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	for _, c := range []struct{ col, v string }{{"actor", f.Actor}, {"action", f.Action}, {"target", f.Target}, {"result", f.Result}} {
+		if c.v != "" {
+			where = append(where, c.col+`=`+arg(c.v))
+		}
+	}
+	if !f.From.IsZero() {
+		where = append(where, `occurred_at>=`+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, `occurred_at<`+arg(f.To))
+	}
+	sql := `SELECT audit_id, occurred_at, actor, action, target, payload_digest, result FROM audit_log`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += ` ORDER BY audit_id`
+	if f.Limit > 0 {
+		sql += ` LIMIT ` + arg(f.Limit)
+	}
+	sql += ` OFFSET ` + arg(f.Offset)
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a AuditRow
+		if err := rows.Scan(&a.ID, &a.OccurredAt, &a.Actor, &a.Action, &a.Target, &a.PayloadDigest, &a.Result); err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
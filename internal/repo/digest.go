@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type DigestPrefRow struct {
+	UserID     string
+	Username   string
+	Email      string
+	Schedule   string
+	Hour       int
+	Weekday    int
+	Timezone   string
+	LastSentAt *time.Time
+	UpdatedAt  time.Time
+}
+
+const digestPrefColumns = `d.user_id, u.username, d.email, d.schedule, d.hour, d.weekday, d.timezone, d.last_sent_at, d.updated_at`
+
+func scanDigestPref(row pgx.Row) (DigestPrefRow, error) {
+	var d DigestPrefRow
+	err := row.Scan(&d.UserID, &d.Username, &d.Email, &d.Schedule, &d.Hour, &d.Weekday, &d.Timezone, &d.LastSentAt, &d.UpdatedAt)
+	return d, err
+}
+
+// SetDigestPref replaces the user's digest preferences. The send history is
+// kept, so changing the schedule does not resend a digest already sent.
+func (r *Repo) SetDigestPref(ctx context.Context, d DigestPrefRow) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO digest_preferences(user_id, email, schedule, hour, weekday, timezone) VALUES ($1,$2,$3,$4,$5,$6)
+        ON CONFLICT (user_id) DO UPDATE SET email=EXCLUDED.email, schedule=EXCLUDED.schedule, hour=EXCLUDED.hour,
+            weekday=EXCLUDED.weekday, timezone=EXCLUDED.timezone, updated_at=now()`,
+		d.UserID, d.Email, d.Schedule, d.Hour, d.Weekday, d.Timezone)
+	return err
+}
+
+func (r *Repo) DeleteDigestPref(ctx context.Context, userID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM digest_preferences WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) DigestPref(ctx context.Context, userID string) (DigestPrefRow, error) {
+	d, err := scanDigestPref(r.db.QueryRow(ctx, `SELECT `+digestPrefColumns+`
+        FROM digest_preferences d JOIN users u ON u.user_id=d.user_id WHERE d.user_id=$1`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DigestPrefRow{}, ErrNotFound
+	}
+	return d, err
+}
+
+// ScheduledDigests lists the preferences of active users with a digest
+// schedule other than off.
+func (r *Repo) ScheduledDigests(ctx context.Context) ([]DigestPrefRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+digestPrefColumns+`
+        FROM digest_preferences d JOIN users u ON u.user_id=d.user_id
+        WHERE d.schedule <> 'off' AND u.is_active ORDER BY d.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DigestPrefRow
+	for rows.Next() {
+		d, err := scanDigestPref(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ClaimDigest records the digest for slot as sent unless it, or a later one,
+// already is. Only the caller that gets true sends it, so several replicas
+// can run the digest job.
+func (r *Repo) ClaimDigest(ctx context.Context, userID string, slot time.Time) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE digest_preferences SET last_sent_at=$2
+        WHERE user_id=$1 AND (last_sent_at IS NULL OR last_sent_at < $2)`, userID, slot)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseDigest undoes ClaimDigest after a failed send so the digest for slot
+// is tried again.
+func (r *Repo) ReleaseDigest(ctx context.Context, userID string, slot time.Time, prev *time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE digest_preferences SET last_sent_at=$3 WHERE user_id=$1 AND last_sent_at=$2`, userID, slot, prev)
+	return err
+}
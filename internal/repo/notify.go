@@ -0,0 +1,151 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type NotifyChannelRow struct {
+	Team       string
+	Channel    string
+	WebhookURL string
+	UpdatedBy  string
+	UpdatedAt  pgtype.Timestamptz
+}
+
+func (r *Repo) SetNotifyChannel(ctx context.Context, c NotifyChannelRow) (NotifyChannelRow, error) {
+	err := r.db.QueryRow(ctx, `INSERT INTO notify_channels(team_name, channel, webhook_url, updated_by) VALUES ($1,$2,$3,$4)
+        ON CONFLICT (team_name) DO UPDATE SET channel=EXCLUDED.channel, webhook_url=EXCLUDED.webhook_url,
+            updated_by=EXCLUDED.updated_by, updated_at=now()
+        RETURNING team_name, channel, webhook_url, updated_by, updated_at`, c.Team, c.Channel, c.WebhookURL, c.UpdatedBy).
+		Scan(&c.Team, &c.Channel, &c.WebhookURL, &c.UpdatedBy, &c.UpdatedAt)
+	return c, err
+}
+
+func (r *Repo) DeleteNotifyChannel(ctx context.Context, team string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM notify_channels WHERE team_name=$1`, team)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// NotifyChannel returns the channel configured for team itself.
+func (r *Repo) NotifyChannel(ctx context.Context, team string) (NotifyChannelRow, error) {
+	var c NotifyChannelRow
+	err := r.db.QueryRow(ctx, `SELECT team_name, channel, webhook_url, updated_by, updated_at FROM notify_channels WHERE team_name=$1`, team).
+		Scan(&c.Team, &c.Channel, &c.WebhookURL, &c.UpdatedBy, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotifyChannelRow{}, ErrNotFound
+	}
+	return c, err
+}
+
+// EffectiveNotifyChannel returns the channel of team or, failing that, of its
+// nearest ancestor that has one.
+func (r *Repo) EffectiveNotifyChannel(ctx context.Context, team string) (NotifyChannelRow, error) {
+	var c NotifyChannelRow
+	err := r.db.QueryRow(ctx, `WITH RECURSIVE chain AS (
+            SELECT team_name, parent_team_name, 0 AS depth FROM teams WHERE team_name=$1
+            UNION ALL
+            SELECT t.team_name, t.parent_team_name, c.depth+1
+            FROM teams t JOIN chain c ON t.team_name=c.parent_team_name
+            WHERE c.depth < 64
+        )
+        SELECT n.team_name, n.channel, n.webhook_url, n.updated_by, n.updated_at
+        FROM chain c JOIN notify_channels n ON n.team_name=c.team_name ORDER BY c.depth LIMIT 1`, team).
+		Scan(&c.Team, &c.Channel, &c.WebhookURL, &c.UpdatedBy, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotifyChannelRow{}, ErrNotFound
+	}
+	return c, err
+}
+
+type NotifyUserRow struct {
+	UserID    string
+	Username  string
+	Handle    string
+	DM        bool
+	UpdatedAt pgtype.Timestamptz
+}
+
+func (r *Repo) SetNotifyUser(ctx context.Context, u NotifyUserRow) (NotifyUserRow, error) {
+	err := r.db.QueryRow(ctx, `INSERT INTO notify_users(user_id, handle, dm) VALUES ($1,$2,$3)
+        ON CONFLICT (user_id) DO UPDATE SET handle=EXCLUDED.handle, dm=EXCLUDED.dm, updated_at=now()
+        RETURNING user_id, handle, dm, updated_at`, u.UserID, u.Handle, u.DM).
+		Scan(&u.UserID, &u.Handle, &u.DM, &u.UpdatedAt)
+	return u, err
+}
+
+func (r *Repo) DeleteNotifyUser(ctx context.Context, userID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM notify_users WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) NotifyUser(ctx context.Context, userID string) (NotifyUserRow, error) {
+	var u NotifyUserRow
+	err := r.db.QueryRow(ctx, `SELECT user_id, handle, dm, updated_at FROM notify_users WHERE user_id=$1`, userID).
+		Scan(&u.UserID, &u.Handle, &u.DM, &u.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NotifyUserRow{}, ErrNotFound
+	}
+	return u, err
+}
+
+// NotifyRecipients returns every existing user among userIDs with their
+// username and, when mapped, chat handle, keyed by user ID.
+func (r *Repo) NotifyRecipients(ctx context.Context, userIDs []string) (map[string]NotifyUserRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT u.user_id, u.username, COALESCE(n.handle,''), COALESCE(n.dm,false)
+        FROM users u LEFT JOIN notify_users n ON n.user_id=u.user_id WHERE u.user_id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]NotifyUserRow, len(userIDs))
+	for rows.Next() {
+		var u NotifyUserRow
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Handle, &u.DM); err != nil {
+			return nil, err
+		}
+		out[u.UserID] = u
+	}
+	return out, rows.Err()
+}
+
+// NotifyDelivered returns the recipients that already got their message for
+// the event.
+func (r *Repo) NotifyDelivered(ctx context.Context, eventID string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `SELECT recipient FROM notify_deliveries WHERE event_id=$1`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var recipient string
+		if err := rows.Scan(&recipient); err != nil {
+			return nil, err
+		}
+		out = append(out, recipient)
+	}
+	return out, rows.Err()
+}
+
+// MarkNotifyDelivered records that recipient got its message for the event,
+// so retries of the event skip it.
+func (r *Repo) MarkNotifyDelivered(ctx context.Context, eventID, recipient string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO notify_deliveries(event_id, recipient) VALUES ($1,$2) ON CONFLICT DO NOTHING`, eventID, recipient)
+	return err
+}
@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+func (r *Repo) InsertOutbox(ctx context.Context, eventID, eventType, payload string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO outbox(event_id, event_type, payload) VALUES ($1,$2,$3)`, eventID, eventType, payload)
+	return err
+}
+
+type OutboxRow struct {
+	ID        int64
+	EventID   string
+	EventType string
+	Payload   string
+	Attempts  int
+	// SentSinks are the sinks that already published the event.
+	SentSinks []string
+}
+
+// ClaimOutbox picks up to limit due events, oldest first, and pushes their
+// next attempt lease into the future, so concurrent relays (other replicas
+// included) skip them while they are being published.
+func (r *Repo) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxRow, error) {
+	rows, err := r.db.Query(ctx, `UPDATE outbox o SET next_attempt_at = now() + make_interval(secs => $2)
+        WHERE o.outbox_id IN (
+            SELECT outbox_id FROM outbox
+            WHERE sent_at IS NULL AND next_attempt_at <= now()
+            ORDER BY outbox_id LIMIT $1
+            FOR UPDATE SKIP LOCKED)
+        RETURNING o.outbox_id, o.event_id, o.event_type, o.payload, o.attempts,
+            ARRAY(SELECT sink FROM outbox_sink_state s WHERE s.event_id = o.event_id)`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []OutboxRow{}
+	for rows.Next() {
+		var o OutboxRow
+		if err := rows.Scan(&o.ID, &o.EventID, &o.EventType, &o.Payload, &o.Attempts, &o.SentSinks); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the subquery's order.
+	slices.SortFunc(out, func(a, b OutboxRow) int { return int(a.ID - b.ID) })
+	return out, nil
+}
+
+// MarkOutboxSinkSent records that sink published the event, so retries of
+// the event skip it.
+func (r *Repo) MarkOutboxSinkSent(ctx context.Context, eventID, sink string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO outbox_sink_state(event_id, sink) VALUES ($1,$2) ON CONFLICT DO NOTHING`, eventID, sink)
+	return err
+}
+
+func (r *Repo) MarkOutboxSent(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE outbox SET sent_at=now(), attempts=attempts+1, last_error=NULL WHERE outbox_id=$1`, id)
+	return err
+}
+
+func (r *Repo) MarkOutboxFailed(ctx context.Context, id int64, errText string, next time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE outbox SET attempts=attempts+1, last_error=$2, next_attempt_at=$3 WHERE outbox_id=$1`, id, errText, next)
+	return err
+}
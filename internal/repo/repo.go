@@ -3,21 +3,52 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/example/avito-pr-service/internal/logging"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var ErrNotFound = errors.New("not found")
 
+var logger = logging.Logger("repo")
+
+// DBTX is the query surface shared by *pgxpool.Pool and pgx.Tx.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Repo struct {
-	db *pgxpool.Pool
+	pool *pgxpool.Pool
+	db   DBTX
 }
 
-func New(db *pgxpool.Pool) *Repo { return &Repo{db: db} }
+func New(db *pgxpool.Pool) *Repo { return &Repo{pool: db, db: db} }
+
+func (r *Repo) Db() *pgxpool.Pool { return r.pool }
 
-func (r *Repo) Db() *pgxpool.Pool { return r.db }
+// InTx runs fn with a Repo bound to a new transaction. Called on a Repo that is
+// already transactional, it opens a savepoint instead.
+func (r *Repo) InTx(ctx context.Context, fn func(*Repo) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(&Repo{pool: r.pool, db: tx}); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
 func (r *Repo) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	tx, err := r.db.Begin(ctx)
@@ -26,16 +57,25 @@ func (r *Repo) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	}
 	defer func() {
 		if err != nil {
-			_ = tx.Rollback(ctx)
+			rollback(ctx, tx, err)
 		}
 	}()
 	if err = fn(tx); err != nil {
-		_ = tx.Rollback(ctx)
+		rollback(ctx, tx, err)
 		return err
 	}
 	return tx.Commit(ctx)
 }
 
+// rollback aborts tx after cause. Its own failure only leaves the connection
+// to be reset by the pool, so it is logged rather than returned.
+func rollback(ctx context.Context, tx pgx.Tx, cause error) {
+	logger.DebugContext(ctx, "transaction rolled back", "cause", cause)
+	if err := tx.Rollback(ctx); err != nil {
+		logger.WarnContext(ctx, "rollback failed", logging.Err(err))
+	}
+}
+
 func (r *Repo) TeamExists(ctx context.Context, name string) (bool, error) {
 	var exists bool
 	if err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM teams WHERE team_name=$1)`, name).Scan(&exists); err != nil {
@@ -49,21 +89,279 @@ func (r *Repo) CreateTeam(ctx context.Context, name string) error {
 	return err
 }
 
-func (r *Repo) UpsertUser(ctx context.Context, userID, username, team string, active bool) error {
+func (r *Repo) RenameTeam(ctx context.Context, name, newName string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE teams SET team_name=$2 WHERE team_name=$1`, name, newName)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) DeleteTeam(ctx context.Context, name string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM teams WHERE team_name=$1`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetUserTeam moves the user to team; an empty team leaves the user without one.
+func (r *Repo) SetUserTeam(ctx context.Context, userID, team string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE users SET team_name=NULLIF($2,'') WHERE user_id=$1`, userID, team)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type TeamRow struct {
+	Name   string
+	Parent string
+}
+
+func (r *Repo) ListTeams(ctx context.Context) ([]TeamRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT team_name, COALESCE(parent_team_name,'') FROM teams ORDER BY team_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TeamRow{}
+	for rows.Next() {
+		var t TeamRow
+		if err := rows.Scan(&t.Name, &t.Parent); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// hierarchyLockKey is the pg_advisory_xact_lock key serializing changes to
+// team parents.
+const hierarchyLockKey int64 = 0x70725f74726565 // "pr_tree"
+
+// LockTeamHierarchy blocks until no other transaction is changing team
+// parents, and holds the lock until the transaction ends. Call it inside
+// InTx before checking the hierarchy, so two moves cannot both pass the cycle
+// check and commit a cycle together.
+func (r *Repo) LockTeamHierarchy(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, hierarchyLockKey)
+	return err
+}
+
+// SetTeamParent attaches team under parent; an empty parent makes it a root.
+func (r *Repo) SetTeamParent(ctx context.Context, team, parent string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE teams SET parent_team_name=NULLIF($2,'') WHERE team_name=$1`, team, parent)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TeamSettingsRow holds a team's own settings; nil fields are inherited.
+type TeamSettingsRow struct {
+	Team          string
+	Parent        string
+	ReviewerCount *int
+	Strategy      *string
+	MergePolicy   *string
+}
+
+// TeamAncestry returns the settings of team followed by those of its
+// ancestors, nearest first.
+func (r *Repo) TeamAncestry(ctx context.Context, team string) ([]TeamSettingsRow, error) {
+	rows, err := r.db.Query(ctx, `WITH RECURSIVE chain AS (
+            SELECT team_name, parent_team_name, reviewer_count, assignment_strategy, merge_policy, 0 AS depth
+            FROM teams WHERE team_name=$1
+            UNION ALL
+            SELECT t.team_name, t.parent_team_name, t.reviewer_count, t.assignment_strategy, t.merge_policy, c.depth+1
+            FROM teams t JOIN chain c ON t.team_name=c.parent_team_name
+            WHERE c.depth < 64
+        )
+        SELECT team_name, COALESCE(parent_team_name,''), reviewer_count, assignment_strategy, merge_policy FROM chain ORDER BY depth`, team)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TeamSettingsRow{}
+	for rows.Next() {
+		var t TeamSettingsRow
+		if err := rows.Scan(&t.Team, &t.Parent, &t.ReviewerCount, &t.Strategy, &t.MergePolicy); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+func (r *Repo) SetTeamSettings(ctx context.Context, t TeamSettingsRow) error {
+	tag, err := r.db.Exec(ctx, `UPDATE teams SET reviewer_count=$2, assignment_strategy=$3, merge_policy=$4 WHERE team_name=$1`,
+		t.Team, t.ReviewerCount, t.Strategy, t.MergePolicy)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type TeamStatsRow struct {
+	Team        string
+	OpenPRs     int
+	MergedPRs   int
+	Assignments int
+}
+
+// TeamStats counts PRs and current reviewer assignments per target team.
+func (r *Repo) TeamStats(ctx context.Context) ([]TeamStatsRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT t.team_name,
+            COUNT(DISTINCT p.pull_request_id) FILTER (WHERE p.status='OPEN'),
+            COUNT(DISTINCT p.pull_request_id) FILTER (WHERE p.status='MERGED'),
+            COUNT(rv.user_id)
+        FROM teams t
+        LEFT JOIN pull_requests p ON p.team_name=t.team_name
+        LEFT JOIN pr_reviewers rv ON rv.pull_request_id=p.pull_request_id
+        GROUP BY t.team_name ORDER BY t.team_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TeamStatsRow{}
+	for rows.Next() {
+		var t TeamStatsRow
+		if err := rows.Scan(&t.Team, &t.OpenPRs, &t.MergedPRs, &t.Assignments); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// UpsertUser creates or updates the user and makes it a member of team with
+// role. The team becomes the user's primary one only if the user has none yet.
+func (r *Repo) UpsertUser(ctx context.Context, userID, username, team string, active bool, role string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO users(user_id, username, team_name, is_active)
+        VALUES ($1,$2,$3,$4)
+        ON CONFLICT (user_id) DO UPDATE SET username=EXCLUDED.username, team_name=COALESCE(users.team_name, EXCLUDED.team_name), is_active=EXCLUDED.is_active`, userID, username, team, active)
+	if err != nil {
+		return err
+	}
+	return r.AddMembership(ctx, team, userID, active, role)
+}
+
+// AddMember makes the user a member of team, creating the user when it does
+// not exist yet. An existing user's name and activity are left alone; only a
+// missing primary team is filled in.
+func (r *Repo) AddMember(ctx context.Context, userID, username, team string, active bool, role string) error {
 	_, err := r.db.Exec(ctx, `INSERT INTO users(user_id, username, team_name, is_active)
         VALUES ($1,$2,$3,$4)
-        ON CONFLICT (user_id) DO UPDATE SET username=EXCLUDED.username, team_name=EXCLUDED.team_name, is_active=EXCLUDED.is_active`, userID, username, team, active)
+        ON CONFLICT (user_id) DO UPDATE SET team_name=COALESCE(users.team_name, EXCLUDED.team_name)`, userID, username, team, active)
+	if err != nil {
+		return err
+	}
+	return r.AddMembership(ctx, team, userID, active, role)
+}
+
+// AddMembership adds the user to team, or sets the activity and role of an
+// existing membership. An empty role keeps the current one, or makes a new
+// member a plain member.
+func (r *Repo) AddMembership(ctx context.Context, team, userID string, active bool, role string) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO team_members(team_name, user_id, is_active, role) VALUES ($1,$2,$3,COALESCE(NULLIF($4,''),'member'))
+        ON CONFLICT (team_name, user_id) DO UPDATE SET is_active=EXCLUDED.is_active, role=COALESCE(NULLIF($4,''), team_members.role)`, team, userID, active, role)
 	return err
 }
 
+func (r *Repo) RemoveMembership(ctx context.Context, team, userID string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM team_members WHERE team_name=$1 AND user_id=$2`, team, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) IsMember(ctx context.Context, team, userID string) (bool, error) {
+	var exists bool
+	if err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM team_members WHERE team_name=$1 AND user_id=$2)`, team, userID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *Repo) SetMembershipActive(ctx context.Context, team, userID string, active bool) error {
+	tag, err := r.db.Exec(ctx, `UPDATE team_members SET is_active=$3 WHERE team_name=$1 AND user_id=$2`, team, userID, active)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RefreshPrimaryTeam re-points users.team_name at one of the user's remaining
+// memberships when the current primary team is no longer one of them.
+func (r *Repo) RefreshPrimaryTeam(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `UPDATE users u SET team_name=(SELECT m.team_name FROM team_members m WHERE m.user_id=u.user_id ORDER BY m.team_name LIMIT 1)
+        WHERE u.user_id=$1 AND NOT EXISTS(SELECT 1 FROM team_members m WHERE m.user_id=u.user_id AND m.team_name=u.team_name)`, userID)
+	return err
+}
+
+type MembershipRow struct {
+	Team     string
+	IsActive bool
+	Role     string
+}
+
+func (r *Repo) UserMemberships(ctx context.Context, userID string) ([]MembershipRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT team_name, is_active, role FROM team_members WHERE user_id=$1 ORDER BY team_name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []MembershipRow{}
+	for rows.Next() {
+		var m MembershipRow
+		if err := rows.Scan(&m.Team, &m.IsActive, &m.Role); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
 type TeamMemberRow struct {
 	UserID   string
 	Username string
 	IsActive bool
+	Role     string
 }
 
 func (r *Repo) GetTeam(ctx context.Context, name string) ([]TeamMemberRow, error) {
-	rows, err := r.db.Query(ctx, `SELECT user_id, username, is_active FROM users WHERE team_name=$1 ORDER BY user_id`, name)
+	rows, err := r.db.Query(ctx, `SELECT u.user_id, u.username, u.is_active AND m.is_active, m.role
+        FROM team_members m JOIN users u ON u.user_id=m.user_id
+        WHERE m.team_name=$1 ORDER BY u.user_id`, name)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +369,7 @@ func (r *Repo) GetTeam(ctx context.Context, name string) ([]TeamMemberRow, error
 	members := []TeamMemberRow{}
 	for rows.Next() {
 		var m TeamMemberRow
-		if err := rows.Scan(&m.UserID, &m.Username, &m.IsActive); err != nil {
+		if err := rows.Scan(&m.UserID, &m.Username, &m.IsActive, &m.Role); err != nil {
 			return nil, err
 		}
 		members = append(members, m)
@@ -91,16 +389,24 @@ func (r *Repo) GetTeam(ctx context.Context, name string) ([]TeamMemberRow, error
 func (r *Repo) SetUserActive(ctx context.Context, userID string, active bool) (string, string, bool, error) {
 	var username, team string
 	var isActive bool
-	err := r.db.QueryRow(ctx, `UPDATE users SET is_active=$2 WHERE user_id=$1 RETURNING username, team_name, is_active`, userID, active).Scan(&username, &team, &isActive)
+	err := r.db.QueryRow(ctx, `UPDATE users SET is_active=$2 WHERE user_id=$1 RETURNING username, COALESCE(team_name,''), is_active`, userID, active).Scan(&username, &team, &isActive)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return "", "", false, ErrNotFound
 	}
 	return username, team, isActive, err
 }
 
+func (r *Repo) GetUser(ctx context.Context, userID string) (username, team string, active bool, err error) {
+	err = r.db.QueryRow(ctx, `SELECT username, COALESCE(team_name,''), is_active FROM users WHERE user_id=$1`, userID).Scan(&username, &team, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", false, ErrNotFound
+	}
+	return username, team, active, err
+}
+
 func (r *Repo) UserTeam(ctx context.Context, userID string) (string, error) {
 	var team string
-	err := r.db.QueryRow(ctx, `SELECT team_name FROM users WHERE user_id=$1`, userID).Scan(&team)
+	err := r.db.QueryRow(ctx, `SELECT team_name FROM users WHERE user_id=$1 AND team_name IS NOT NULL`, userID).Scan(&team)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return "", ErrNotFound
 	}
@@ -115,11 +421,22 @@ func (r *Repo) UserExists(ctx context.Context, userID string) (bool, error) {
 	return exists, nil
 }
 
-func (r *Repo) CreatePR(ctx context.Context, id, name, author string) error {
-	_, err := r.db.Exec(ctx, `INSERT INTO pull_requests(pull_request_id, pull_request_name, author_id) VALUES ($1,$2,$3)`, id, name, author)
+func (r *Repo) CreatePR(ctx context.Context, id, name, author, team string, requiredReviewers int, draft bool) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO pull_requests(pull_request_id, pull_request_name, author_id, team_name, required_reviewers, is_draft) VALUES ($1,$2,$3,$4,$5,$6)`, id, name, author, team, requiredReviewers, draft)
 	return err
 }
 
+func (r *Repo) SetPRDraft(ctx context.Context, id string, draft bool) error {
+	tag, err := r.db.Exec(ctx, `UPDATE pull_requests SET is_draft=$2 WHERE pull_request_id=$1`, id, draft)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (r *Repo) PRExists(ctx context.Context, id string) (bool, error) {
 	var exists bool
 	if err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pull_requests WHERE pull_request_id=$1)`, id).Scan(&exists); err != nil {
@@ -146,8 +463,24 @@ func (r *Repo) PRStatus(ctx context.Context, id string) (string, error) {
 	return status, err
 }
 
-func (r *Repo) AssignReviewersRandom(ctx context.Context, prID, team, exclude1, exclude2 string, limit int) ([]string, error) {
-	rows, err := r.db.Query(ctx, `SELECT user_id FROM users WHERE team_name=$1 AND is_active=true AND user_id<>$2 AND (CASE WHEN $3='' THEN true ELSE user_id<>$3 END) ORDER BY random() LIMIT $4`, team, exclude1, exclude2, limit)
+// Assignment strategies understood by AssignReviewers and ReplacementCandidate.
+const (
+	StrategyRandom      = "random"
+	StrategyLeastLoaded = "least_loaded"
+)
+
+// candidateOrder is the ORDER BY clause picking reviewers for a strategy.
+func candidateOrder(strategy string) string {
+	if strategy == StrategyLeastLoaded {
+		return ` ORDER BY (SELECT COUNT(*) FROM pr_reviewers x JOIN pull_requests xp ON xp.pull_request_id=x.pull_request_id
+            WHERE x.user_id=u.user_id AND xp.status='OPEN'), random()`
+	}
+	return ` ORDER BY random()`
+}
+
+func (r *Repo) AssignReviewers(ctx context.Context, prID, team, author string, limit int, strategy string) ([]string, error) {
+	rows, err := r.db.Query(ctx, `SELECT u.user_id FROM team_members m JOIN users u ON u.user_id=m.user_id
+        WHERE m.team_name=$1 AND m.is_active AND u.is_active AND u.user_id<>$2`+candidateOrder(strategy)+` LIMIT $3`, team, author, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -160,61 +493,216 @@ func (r *Repo) AssignReviewersRandom(ctx context.Context, prID, team, exclude1,
 		}
 		ids = append(ids, id)
 	}
-	batch := pgx.Batch{}
-	for _, uid := range ids {
-		batch.Queue(`INSERT INTO pr_reviewers(pull_request_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`, prID, uid)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	res := r.db.SendBatch(ctx, &batch)
-	if err := res.Close(); err != nil {
+	if err := r.AddReviewers(ctx, prID, ids); err != nil {
 		return nil, err
 	}
 	return ids, nil
 }
 
-func (r *Repo) GetPR(ctx context.Context, id string) (name, author, status string, createdAt pgtype.Timestamptz, mergedAt pgtype.Timestamptz, reviewers []string, err error) {
-	err = r.db.QueryRow(ctx, `SELECT pull_request_name, author_id, status, created_at, merged_at FROM pull_requests WHERE pull_request_id=$1`, id).Scan(&name, &author, &status, &createdAt, &mergedAt)
+// OpenReviewCounts returns how many open PRs each of userIDs reviews, the
+// load the least_loaded strategy balances. Users without any are omitted.
+func (r *Repo) OpenReviewCounts(ctx context.Context, userIDs []string) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, `SELECT x.user_id, COUNT(*) FROM pr_reviewers x JOIN pull_requests p ON p.pull_request_id=x.pull_request_id
+        WHERE x.user_id = ANY($1) AND p.status='OPEN' GROUP BY x.user_id`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+func (r *Repo) AddReviewers(ctx context.Context, prID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	batch := pgx.Batch{}
+	for _, uid := range userIDs {
+		batch.Queue(`WITH ins AS (
+                INSERT INTO pr_reviewers(pull_request_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING RETURNING pull_request_id, user_id
+            ) INSERT INTO review_assignments(pull_request_id, user_id) SELECT pull_request_id, user_id FROM ins`, prID, uid)
+	}
+	return r.db.SendBatch(ctx, &batch).Close()
+}
+
+func (r *Repo) GetPR(ctx context.Context, id string) (PRRow, error) {
+	o := PRRow{ID: id}
+	err := r.db.QueryRow(ctx, `SELECT pull_request_name, author_id, COALESCE(team_name,''), status, required_reviewers, created_at, merged_at, COALESCE(merged_by,''), is_draft, closed_at
+        FROM pull_requests WHERE pull_request_id=$1`, id).
+		Scan(&o.Name, &o.Author, &o.Team, &o.Status, &o.RequiredReviewers, &o.CreatedAt, &o.MergedAt, &o.MergedBy, &o.IsDraft, &o.ClosedAt)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return "", "", "", pgtype.Timestamptz{}, pgtype.Timestamptz{}, nil, ErrNotFound
+		return PRRow{}, ErrNotFound
 	}
 	if err != nil {
-		return
+		return PRRow{}, err
 	}
-	rows, err2 := r.db.Query(ctx, `SELECT user_id FROM pr_reviewers WHERE pull_request_id=$1 ORDER BY user_id`, id)
-	if err2 != nil {
-		err = err2
-		return
+	rows, err := r.db.Query(ctx, `SELECT user_id FROM pr_reviewers WHERE pull_request_id=$1 ORDER BY user_id`, id)
+	if err != nil {
+		return PRRow{}, err
 	}
 	defer rows.Close()
-	var rids []string
+	o.Reviewers = []string{}
 	for rows.Next() {
 		var u string
-		if err2 := rows.Scan(&u); err2 != nil {
-			err = err2
-			return
+		if err := rows.Scan(&u); err != nil {
+			return PRRow{}, err
 		}
-		rids = append(rids, u)
+		o.Reviewers = append(o.Reviewers, u)
 	}
-	reviewers = rids
-	return
+	return o, rows.Err()
+}
+
+type PRFilter struct {
+	Team          string
+	Author        string
+	Reviewer      string
+	Status        string
+	Name          string
+	CreatedBefore time.Time
+	// Understaffed keeps only PRs with fewer reviewers than they require.
+	Understaffed bool
+	Limit        int
+	Offset       int
+}
+
+type PRRow struct {
+	ID        string
+	Name      string
+	Author    string
+	Team      string
+	Status    string
+	CreatedAt pgtype.Timestamptz
+	MergedAt  pgtype.Timestamptz
+	Reviewers []string
+	// RequiredReviewers is the team's reviewer count when the PR was created.
+	RequiredReviewers int
+	MergedBy          string
+	IsDraft           bool
+	ClosedAt          pgtype.Timestamptz
 }
 
-func (r *Repo) MergePR(ctx context.Context, id string) error {
-	_, err := r.db.Exec(ctx, `UPDATE pull_requests SET status='MERGED', merged_at=COALESCE(merged_at, now()) WHERE pull_request_id=$1`, id)
+func (r *Repo) ListPRs(ctx context.Context, f PRFilter) ([]PRRow, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Team != "" {
+		where = append(where, `p.team_name=`+arg(f.Team))
+	}
+	if f.Author != "" {
+		where = append(where, `p.author_id=`+arg(f.Author))
+	}
+	if f.Reviewer != "" {
+		where = append(where, `EXISTS(SELECT 1 FROM pr_reviewers x WHERE x.pull_request_id=p.pull_request_id AND x.user_id=`+arg(f.Reviewer)+`)`)
+	}
+	if f.Status != "" {
+		where = append(where, `p.status=`+arg(f.Status)+`::pr_status`)
+	}
+	if f.Name != "" {
+		where = append(where, `p.pull_request_name ILIKE '%' || `+arg(likeEscape(f.Name))+` || '%'`)
+	}
+	if !f.CreatedBefore.IsZero() {
+		where = append(where, `p.created_at<=`+arg(f.CreatedBefore))
+	}
+	sql := `SELECT p.pull_request_id, p.pull_request_name, p.author_id, COALESCE(p.team_name,''), p.status, p.required_reviewers, p.created_at, p.merged_at, COALESCE(p.merged_by,''), p.is_draft, p.closed_at,
+            COALESCE(array_agg(r.user_id ORDER BY r.user_id) FILTER (WHERE r.user_id IS NOT NULL), '{}')
+        FROM pull_requests p
+        LEFT JOIN pr_reviewers r ON r.pull_request_id=p.pull_request_id`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += ` GROUP BY p.pull_request_id`
+	if f.Understaffed {
+		sql += ` HAVING COUNT(r.user_id)<p.required_reviewers`
+	}
+	sql += ` ORDER BY p.created_at DESC, p.pull_request_id LIMIT ` + arg(f.Limit) + ` OFFSET ` + arg(f.Offset)
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []PRRow{}
+	for rows.Next() {
+		var o PRRow
+		if err := rows.Scan(&o.ID, &o.Name, &o.Author, &o.Team, &o.Status, &o.RequiredReviewers, &o.CreatedAt, &o.MergedAt, &o.MergedBy, &o.IsDraft, &o.ClosedAt, &o.Reviewers); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// MergePR marks the PR merged; repeated calls keep the first merge time and actor.
+func (r *Repo) MergePR(ctx context.Context, id, mergedBy string) error {
+	_, err := r.db.Exec(ctx, `UPDATE pull_requests SET status='MERGED', merged_at=COALESCE(merged_at, now()), merged_by=COALESCE(merged_by, NULLIF($2,''))
+        WHERE pull_request_id=$1`, id, mergedBy)
+	return err
+}
+
+// ClosePR closes an open PR without merging it.
+func (r *Repo) ClosePR(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `UPDATE pull_requests SET status='CLOSED', closed_at=now() WHERE pull_request_id=$1 AND status='OPEN'`, id)
+	return err
+}
+
+// ReopenPR opens a closed PR again.
+func (r *Repo) ReopenPR(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `UPDATE pull_requests SET status='OPEN', closed_at=NULL WHERE pull_request_id=$1 AND status='CLOSED'`, id)
 	return err
 }
 
+// ReplaceReviewer hands oldUser's review to newUser, ending oldUser's
+// assignment as reassigned.
 func (r *Repo) ReplaceReviewer(ctx context.Context, prID, oldUser, newUser string) error {
 	_, err := r.db.Exec(ctx, `WITH del AS (
             DELETE FROM pr_reviewers WHERE pull_request_id=$1 AND user_id=$2 RETURNING 1
-        ) INSERT INTO pr_reviewers(pull_request_id, user_id) VALUES ($1,$3) ON CONFLICT DO NOTHING`, prID, oldUser, newUser)
+        ), ended AS (
+            UPDATE review_assignments SET unassigned_at=now(), reason='reassigned'
+            WHERE pull_request_id=$1 AND user_id=$2 AND unassigned_at IS NULL AND EXISTS (SELECT 1 FROM del)
+        ), ins AS (
+            INSERT INTO pr_reviewers(pull_request_id, user_id) VALUES ($1,$3) ON CONFLICT DO NOTHING RETURNING pull_request_id, user_id
+        ) INSERT INTO review_assignments(pull_request_id, user_id) SELECT pull_request_id, user_id FROM ins`, prID, oldUser, newUser)
 	return err
 }
 
+// DeleteReviewer drops userID from the PR, ending the assignment as removed.
 func (r *Repo) DeleteReviewer(ctx context.Context, prID, userID string) error {
-	_, err := r.db.Exec(ctx, `DELETE FROM pr_reviewers WHERE pull_request_id=$1 AND user_id=$2`, prID, userID)
+	_, err := r.db.Exec(ctx, `WITH del AS (
+            DELETE FROM pr_reviewers WHERE pull_request_id=$1 AND user_id=$2 RETURNING 1
+        ) UPDATE review_assignments SET unassigned_at=now(), reason='removed'
+        WHERE pull_request_id=$1 AND user_id=$2 AND unassigned_at IS NULL AND EXISTS (SELECT 1 FROM del)`, prID, userID)
 	return err
 }
 
+// MarkReviewed stamps the reviewer's current assignment with its first review;
+// it reports false when the assignment was already reviewed.
+func (r *Repo) MarkReviewed(ctx context.Context, prID, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE review_assignments SET reviewed_at=now()
+        WHERE pull_request_id=$1 AND user_id=$2 AND unassigned_at IS NULL AND reviewed_at IS NULL`, prID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (r *Repo) IsReviewerAssigned(ctx context.Context, prID, userID string) (bool, error) {
 	var exists bool
 	if err := r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pr_reviewers WHERE pull_request_id=$1 AND user_id=$2)`, prID, userID).Scan(&exists); err != nil {
@@ -223,14 +711,15 @@ func (r *Repo) IsReviewerAssigned(ctx context.Context, prID, userID string) (boo
 	return exists, nil
 }
 
-func (r *Repo) RandomReplacementCandidate(ctx context.Context, team, author string, excludeAssigned []string) (string, error) {
-	sql := `SELECT user_id FROM users WHERE team_name=$1 AND is_active=true AND user_id<>$2`
+func (r *Repo) ReplacementCandidate(ctx context.Context, team, author string, excludeAssigned []string, strategy string) (string, error) {
+	sql := `SELECT u.user_id FROM team_members m JOIN users u ON u.user_id=m.user_id
+        WHERE m.team_name=$1 AND m.is_active AND u.is_active AND u.user_id<>$2`
 	args := []any{team, author}
 	if len(excludeAssigned) > 0 {
-		sql += ` AND user_id <> ALL($3)`
+		sql += ` AND u.user_id <> ALL($3)`
 		args = append(args, excludeAssigned)
 	}
-	sql += ` ORDER BY random() LIMIT 1`
+	sql += candidateOrder(strategy) + ` LIMIT 1`
 	var uid string
 	err := r.db.QueryRow(ctx, sql, args...).Scan(&uid)
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -239,60 +728,44 @@ func (r *Repo) RandomReplacementCandidate(ctx context.Context, team, author stri
 	return uid, err
 }
 
-func (r *Repo) PRsForReviewer(ctx context.Context, userID string) ([]struct{ ID, Name, Author, Status string }, error) {
-	rows, err := r.db.Query(ctx, `SELECT p.pull_request_id, p.pull_request_name, p.author_id, p.status FROM pull_requests p JOIN pr_reviewers r ON p.pull_request_id=r.pull_request_id WHERE r.user_id=$1 ORDER BY p.pull_request_id`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []struct{ ID, Name, Author, Status string }
-	for rows.Next() {
-		var o struct{ ID, Name, Author, Status string }
-		if err := rows.Scan(&o.ID, &o.Name, &o.Author, &o.Status); err != nil {
-			return nil, err
-		}
-		out = append(out, o)
-	}
-	return out, nil
+type ReviewRow struct {
+	ID, Name, Author, Status string
+	CreatedAt                time.Time
 }
 
-func (r *Repo) AssignmentStats(ctx context.Context) ([]struct {
-	UserID string
-	Cnt    int
-}, error) {
-	rows, err := r.db.Query(ctx, `SELECT user_id, cnt FROM stats_assignments ORDER BY user_id`)
+// PRsForReviewer lists the PRs userID reviews, whatever their status.
+func (r *Repo) PRsForReviewer(ctx context.Context, userID string) ([]ReviewRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT p.pull_request_id, p.pull_request_name, p.author_id, p.status, p.created_at FROM pull_requests p JOIN pr_reviewers r ON p.pull_request_id=r.pull_request_id WHERE r.user_id=$1 ORDER BY p.pull_request_id`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var out []struct {
-		UserID string
-		Cnt    int
-	}
+	var out []ReviewRow
 	for rows.Next() {
-		var u string
-		var c int
-		if err := rows.Scan(&u, &c); err != nil {
+		var o ReviewRow
+		if err := rows.Scan(&o.ID, &o.Name, &o.Author, &o.Status, &o.CreatedAt); err != nil {
 			return nil, err
 		}
-		out = append(out, struct {
-			UserID string
-			Cnt    int
-		}{u, c})
+		out = append(out, o)
 	}
 	return out, nil
 }
 
-func (r *Repo) DeactivateTeamUsers(ctx context.Context, team string) error {
-	_, err := r.db.Exec(ctx, `UPDATE users SET is_active=false WHERE team_name=$1`, team)
+// DeactivateTeamMemberships turns off the team's memberships only; the users
+// stay active in their other teams.
+func (r *Repo) DeactivateTeamMemberships(ctx context.Context, team string) error {
+	_, err := r.db.Exec(ctx, `UPDATE team_members SET is_active=false WHERE team_name=$1`, team)
 	return err
 }
 
-func (r *Repo) OpenPRsAffectedByUsers(ctx context.Context, userIDs []string) ([]struct {
+// OpenPRsAffectedByUsers lists open reviews held by userIDs, limited to PRs
+// targeting team unless team is empty.
+func (r *Repo) OpenPRsAffectedByUsers(ctx context.Context, userIDs []string, team string) ([]struct {
 	PRID, Reviewer string
 	Author         string
 }, error) {
-	rows, err := r.db.Query(ctx, `SELECT r.pull_request_id, r.user_id, p.author_id FROM pr_reviewers r JOIN pull_requests p ON p.pull_request_id=r.pull_request_id WHERE r.user_id = ANY($1) AND p.status='OPEN'`, userIDs)
+	rows, err := r.db.Query(ctx, `SELECT r.pull_request_id, r.user_id, p.author_id FROM pr_reviewers r JOIN pull_requests p ON p.pull_request_id=r.pull_request_id
+        WHERE r.user_id = ANY($1) AND p.status='OPEN' AND ($2='' OR p.team_name=$2)`, userIDs, team)
 	if err != nil {
 		return nil, err
 	}
@@ -315,9 +788,9 @@ func (r *Repo) OpenPRsAffectedByUsers(ctx context.Context, userIDs []string) ([]
 }
 
 func (r *Repo) TeamMembers(ctx context.Context, team string, onlyActive bool) ([]string, error) {
-	sql := `SELECT user_id FROM users WHERE team_name=$1`
+	sql := `SELECT u.user_id FROM team_members m JOIN users u ON u.user_id=m.user_id WHERE m.team_name=$1`
 	if onlyActive {
-		sql += ` AND is_active=true`
+		sql += ` AND m.is_active AND u.is_active`
 	}
 	rows, err := r.db.Query(ctx, sql, team)
 	if err != nil {
@@ -334,3 +807,74 @@ func (r *Repo) TeamMembers(ctx context.Context, team string, onlyActive bool) ([
 	}
 	return ids, nil
 }
+
+type TokenRow struct {
+	ID             int64
+	Name           string
+	UserID         string
+	ServiceAccount string
+	Role           string
+	CreatedBy      string
+	CreatedAt      pgtype.Timestamptz
+	ExpiresAt      pgtype.Timestamptz
+	RevokedAt      pgtype.Timestamptz
+	LastUsedAt     pgtype.Timestamptz
+}
+
+const tokenColumns = `token_id, name, COALESCE(user_id,''), COALESCE(service_account,''), role, created_by, created_at, expires_at, revoked_at, last_used_at`
+
+func scanToken(row pgx.Row) (TokenRow, error) {
+	var t TokenRow
+	err := row.Scan(&t.ID, &t.Name, &t.UserID, &t.ServiceAccount, &t.Role, &t.CreatedBy, &t.CreatedAt, &t.ExpiresAt, &t.RevokedAt, &t.LastUsedAt)
+	return t, err
+}
+
+func (r *Repo) CreateToken(ctx context.Context, hash []byte, t TokenRow) (TokenRow, error) {
+	var expires any
+	if t.ExpiresAt.Valid {
+		expires = t.ExpiresAt.Time
+	}
+	return scanToken(r.db.QueryRow(ctx, `INSERT INTO api_tokens(token_hash, name, user_id, service_account, role, created_by, expires_at)
+        VALUES ($1,$2,NULLIF($3,''),NULLIF($4,''),$5,$6,$7) RETURNING `+tokenColumns,
+		hash, t.Name, t.UserID, t.ServiceAccount, t.Role, t.CreatedBy, expires))
+}
+
+func (r *Repo) TokenByHash(ctx context.Context, hash []byte) (TokenRow, error) {
+	t, err := scanToken(r.db.QueryRow(ctx, `SELECT `+tokenColumns+` FROM api_tokens WHERE token_hash=$1`, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TokenRow{}, ErrNotFound
+	}
+	return t, err
+}
+
+// TouchToken records token use, at most once a minute to keep reads cheap.
+func (r *Repo) TouchToken(ctx context.Context, id int64) error {
+	_, err := r.db.Exec(ctx, `UPDATE api_tokens SET last_used_at=now()
+        WHERE token_id=$1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`, id)
+	return err
+}
+
+func (r *Repo) ListTokens(ctx context.Context) ([]TokenRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+tokenColumns+` FROM api_tokens ORDER BY token_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TokenRow{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) RevokeToken(ctx context.Context, id int64) (TokenRow, error) {
+	t, err := scanToken(r.db.QueryRow(ctx, `UPDATE api_tokens SET revoked_at=COALESCE(revoked_at, now()) WHERE token_id=$1 RETURNING `+tokenColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TokenRow{}, ErrNotFound
+	}
+	return t, err
+}
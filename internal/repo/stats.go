@@ -0,0 +1,329 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Assignment stats dimensions.
+const (
+	GroupUser = "user"
+	GroupTeam = "team"
+	GroupWeek = "week"
+)
+
+// assignmentGroups maps a dimension to its SQL expression. An assignment
+// belongs to the team its PR targets, or else to the reviewer's primary team;
+// weeks start on Monday, in UTC.
+var assignmentGroups = map[string]string{
+	GroupUser: `a.user_id`,
+	GroupTeam: `COALESCE(p.team_name, u.team_name, '')`,
+	GroupWeek: `to_char(date_trunc('week', a.assigned_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD')`,
+}
+
+type AssignmentStatsFilter struct {
+	// From and To bound assigned_at to [From, To); zero values leave that
+	// side open.
+	From   time.Time
+	To     time.Time
+	UserID string
+	Team   string
+	// GroupBy lists dimensions from GroupUser, GroupTeam and GroupWeek.
+	GroupBy []string
+}
+
+// AssignmentStatRow counts assignments by what became of them: still held on
+// an open, merged or closed PR, or ended by reassignment or removal.
+type AssignmentStatRow struct {
+	UserID     string
+	Team       string
+	Week       string
+	Count      int
+	Open       int
+	Completed  int
+	Closed     int
+	Reassigned int
+	Removed    int
+}
+
+func (r *Repo) AssignmentStats(ctx context.Context, f AssignmentStatsFilter) ([]AssignmentStatRow, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !f.From.IsZero() {
+		where = append(where, `a.assigned_at>=`+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, `a.assigned_at<`+arg(f.To))
+	}
+	if f.UserID != "" {
+		where = append(where, `a.user_id=`+arg(f.UserID))
+	}
+	if f.Team != "" {
+		where = append(where, assignmentGroups[GroupTeam]+`=`+arg(f.Team))
+	}
+	cols := make([]string, 0, len(f.GroupBy))
+	for _, g := range f.GroupBy {
+		expr, ok := assignmentGroups[g]
+		if !ok {
+			return nil, fmt.Errorf("repo: unknown assignment group %q", g)
+		}
+		cols = append(cols, expr)
+	}
+	sql := `SELECT ` + strings.Join(append(cols, `COUNT(*)`), `, `) + `,
+            COUNT(*) FILTER (WHERE a.unassigned_at IS NULL AND p.status='OPEN'),
+            COUNT(*) FILTER (WHERE a.unassigned_at IS NULL AND p.status='MERGED'),
+            COUNT(*) FILTER (WHERE a.unassigned_at IS NULL AND p.status='CLOSED'),
+            COUNT(*) FILTER (WHERE a.reason='reassigned'),
+            COUNT(*) FILTER (WHERE a.reason='removed')
+        FROM review_assignments a
+        JOIN pull_requests p ON p.pull_request_id=a.pull_request_id
+        JOIN users u ON u.user_id=a.user_id`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	if len(cols) > 0 {
+		order := strings.Join(cols, `, `)
+		sql += ` GROUP BY ` + order + ` ORDER BY ` + order
+	}
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AssignmentStatRow{}
+	for rows.Next() {
+		var o AssignmentStatRow
+		dst := make([]any, 0, len(f.GroupBy)+6)
+		for _, g := range f.GroupBy {
+			switch g {
+			case GroupUser:
+				dst = append(dst, &o.UserID)
+			case GroupTeam:
+				dst = append(dst, &o.Team)
+			case GroupWeek:
+				dst = append(dst, &o.Week)
+			}
+		}
+		dst = append(dst, &o.Count, &o.Open, &o.Completed, &o.Closed, &o.Reassigned, &o.Removed)
+		if err := rows.Scan(dst...); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Percentiles holds p50, p90 and p99 of Count durations, in seconds.
+type Percentiles struct {
+	Count         int
+	P50, P90, P99 float64
+}
+
+// scan fills p from a count and a percentile_cont array, which is NULL when
+// there were no samples.
+func (p *Percentiles) scan(count int, values []float64) {
+	p.Count = count
+	if len(values) == 3 {
+		p.P50, p.P90, p.P99 = values[0], values[1], values[2]
+	}
+}
+
+// percentiles aggregates a duration expression into p50, p90 and p99
+// seconds; NULL durations are skipped.
+func percentiles(expr string) string {
+	return `percentile_cont(ARRAY[0.5, 0.9, 0.99]) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM ` + expr + `))`
+}
+
+type CycleTimeFilter struct {
+	// From and To bound created_at of PRs, and assigned_at of assignments,
+	// to [From, To); zero values leave that side open.
+	From time.Time
+	To   time.Time
+	Team string
+}
+
+// CycleTimeRow describes PRs grouped by team or by the week they were opened.
+type CycleTimeRow struct {
+	Team        string
+	Week        string
+	PRs         int
+	FirstReview Percentiles
+	Merge       Percentiles
+}
+
+type ResponseTimeRow struct {
+	UserID   string
+	Response Percentiles
+}
+
+// cycleTimeGroups maps the dimensions of CycleTimes to SQL over its prs CTE.
+// A PR belongs to the team it targets, or else to its author's primary team.
+var cycleTimeGroups = map[string]string{
+	GroupTeam: `team`,
+	GroupWeek: `to_char(date_trunc('week', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD')`,
+}
+
+// CycleTimes reports, per group (GroupTeam or GroupWeek), how long PRs opened
+// in the window took to get their first review and to merge.
+func (r *Repo) CycleTimes(ctx context.Context, f CycleTimeFilter, group string) ([]CycleTimeRow, error) {
+	col, ok := cycleTimeGroups[group]
+	if !ok {
+		return nil, fmt.Errorf("repo: unknown cycle time group %q", group)
+	}
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !f.From.IsZero() {
+		where = append(where, `p.created_at>=`+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, `p.created_at<`+arg(f.To))
+	}
+	if f.Team != "" {
+		where = append(where, `COALESCE(p.team_name, u.team_name, '')=`+arg(f.Team))
+	}
+	sql := `WITH prs AS (
+            SELECT COALESCE(p.team_name, u.team_name, '') AS team, p.created_at, p.merged_at,
+                (SELECT min(a.reviewed_at) FROM review_assignments a WHERE a.pull_request_id=p.pull_request_id) AS first_review
+            FROM pull_requests p JOIN users u ON u.user_id=p.author_id`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += `)
+        SELECT ` + col + `, COUNT(*),
+            COUNT(first_review), ` + percentiles(`first_review-created_at`) + `,
+            COUNT(merged_at), ` + percentiles(`merged_at-created_at`) + `
+        FROM prs GROUP BY 1 ORDER BY 1`
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CycleTimeRow{}
+	for rows.Next() {
+		var (
+			o                    CycleTimeRow
+			key                  string
+			reviewed, merged     int
+			firstReview, toMerge []float64
+		)
+		if err := rows.Scan(&key, &o.PRs, &reviewed, &firstReview, &merged, &toMerge); err != nil {
+			return nil, err
+		}
+		if group == GroupTeam {
+			o.Team = key
+		} else {
+			o.Week = key
+		}
+		o.FirstReview.scan(reviewed, firstReview)
+		o.Merge.scan(merged, toMerge)
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// ResponseTimes reports per reviewer how long assignments started in the
+// window took to be reviewed; unreviewed assignments are left out.
+func (r *Repo) ResponseTimes(ctx context.Context, f CycleTimeFilter) ([]ResponseTimeRow, error) {
+	where := []string{`a.reviewed_at IS NOT NULL`}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !f.From.IsZero() {
+		where = append(where, `a.assigned_at>=`+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, `a.assigned_at<`+arg(f.To))
+	}
+	if f.Team != "" {
+		where = append(where, assignmentGroups[GroupTeam]+`=`+arg(f.Team))
+	}
+	rows, err := r.db.Query(ctx, `SELECT a.user_id, COUNT(*), `+percentiles(`a.reviewed_at-a.assigned_at`)+`
+        FROM review_assignments a
+        JOIN pull_requests p ON p.pull_request_id=a.pull_request_id
+        JOIN users u ON u.user_id=a.user_id
+        WHERE `+strings.Join(where, ` AND `)+`
+        GROUP BY a.user_id ORDER BY a.user_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ResponseTimeRow{}
+	for rows.Next() {
+		var (
+			o      ResponseTimeRow
+			n      int
+			values []float64
+		)
+		if err := rows.Scan(&o.UserID, &n, &values); err != nil {
+			return nil, err
+		}
+		o.Response.scan(n, values)
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// FairnessRow is one member's load in a team over a window: days spent
+// active in the team and reviews assigned for it.
+type FairnessRow struct {
+	UserID      string
+	ActiveDays  float64
+	Assignments int
+}
+
+// TeamFairness lists everyone who was active in the team or took one of its
+// assignments during [from, to). Open activity periods run until now.
+func (r *Repo) TeamFairness(ctx context.Context, team string, from, to time.Time) ([]FairnessRow, error) {
+	rows, err := r.db.Query(ctx, `WITH days AS (
+            SELECT user_id, SUM(EXTRACT(EPOCH FROM LEAST(COALESCE(active_to, now()), $3) - GREATEST(active_from, $2))) / 86400 AS d
+            FROM member_activity
+            WHERE team_name=$1 AND active_from<$3 AND COALESCE(active_to, now())>$2
+            GROUP BY user_id
+        ), load AS (
+            SELECT a.user_id, COUNT(*) AS n
+            FROM review_assignments a
+            JOIN pull_requests p ON p.pull_request_id=a.pull_request_id
+            JOIN users u ON u.user_id=a.user_id
+            WHERE `+assignmentGroups[GroupTeam]+`=$1 AND a.assigned_at>=$2 AND a.assigned_at<$3
+            GROUP BY a.user_id
+        )
+        SELECT COALESCE(d.user_id, l.user_id), GREATEST(COALESCE(d.d, 0), 0)::float8, COALESCE(l.n, 0)
+        FROM days d FULL JOIN load l ON l.user_id=d.user_id
+        ORDER BY 1`, team, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []FairnessRow{}
+	for rows.Next() {
+		var o FairnessRow
+		if err := rows.Scan(&o.UserID, &o.ActiveDays, &o.Assignments); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// OpenPRCounts counts open PRs and, of those out of draft, the ones holding
+// fewer reviewers than they require.
+func (r *Repo) OpenPRCounts(ctx context.Context) (open, understaffed int, err error) {
+	err = r.db.QueryRow(ctx, `SELECT COUNT(*), COUNT(*) FILTER (WHERE NOT p.is_draft AND
+            (SELECT COUNT(*) FROM pr_reviewers r WHERE r.pull_request_id=p.pull_request_id) < p.required_reviewers)
+        FROM pull_requests p WHERE p.status='OPEN'`).Scan(&open, &understaffed)
+	return open, understaffed, err
+}
@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type VCSAccountRow struct {
+	Provider  string
+	Login     string
+	UserID    string
+	CreatedBy string
+	CreatedAt pgtype.Timestamptz
+}
+
+// LinkVCSAccount maps login to userID, replacing an earlier mapping. Logins
+// are stored lower-cased since code hosts treat them case-insensitively.
+func (r *Repo) LinkVCSAccount(ctx context.Context, a VCSAccountRow) (VCSAccountRow, error) {
+	err := r.db.QueryRow(ctx, `INSERT INTO vcs_accounts(provider, login, user_id, created_by) VALUES ($1, lower($2), $3, $4)
+        ON CONFLICT (provider, login) DO UPDATE SET user_id=EXCLUDED.user_id, created_by=EXCLUDED.created_by, created_at=now()
+        RETURNING provider, login, user_id, created_by, created_at`, a.Provider, a.Login, a.UserID, a.CreatedBy).
+		Scan(&a.Provider, &a.Login, &a.UserID, &a.CreatedBy, &a.CreatedAt)
+	return a, err
+}
+
+func (r *Repo) UnlinkVCSAccount(ctx context.Context, provider, login string) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM vcs_accounts WHERE provider=$1 AND login=lower($2)`, provider, login)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *Repo) ListVCSAccounts(ctx context.Context, provider string) ([]VCSAccountRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT provider, login, user_id, created_by, created_at FROM vcs_accounts
+        WHERE provider=$1 ORDER BY login`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []VCSAccountRow{}
+	for rows.Next() {
+		var a VCSAccountRow
+		if err := rows.Scan(&a.Provider, &a.Login, &a.UserID, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) VCSAccountUser(ctx context.Context, provider, login string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(ctx, `SELECT user_id FROM vcs_accounts WHERE provider=$1 AND login=lower($2)`, provider, login).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return userID, err
+}
+
+// VCSAccountLogin returns a login linked to userID on provider; a user with
+// several linked logins gets the first in alphabetical order.
+func (r *Repo) VCSAccountLogin(ctx context.Context, provider, userID string) (string, error) {
+	var login string
+	err := r.db.QueryRow(ctx, `SELECT login FROM vcs_accounts WHERE provider=$1 AND user_id=$2 ORDER BY login LIMIT 1`, provider, userID).Scan(&login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return login, err
+}
+
+type SyncFailureRow struct {
+	ID         int64
+	Provider   string
+	PRID       string
+	EventID    string
+	Operation  string
+	Logins     []string
+	StatusCode *int
+	Error      string
+	OccurredAt pgtype.Timestamptz
+}
+
+func (r *Repo) InsertSyncFailure(ctx context.Context, f SyncFailureRow) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO review_sync_failures(provider, pull_request_id, event_id, operation, logins, status_code, error)
+        VALUES ($1,$2,$3,$4,$5,$6,$7)`, f.Provider, f.PRID, f.EventID, f.Operation, f.Logins, f.StatusCode, f.Error)
+	return err
+}
+
+type SyncFailureFilter struct {
+	Provider string
+	PRID     string
+	Limit    int
+	Offset   int
+}
+
+func (r *Repo) ListSyncFailures(ctx context.Context, f SyncFailureFilter) ([]SyncFailureRow, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Provider != "" {
+		where = append(where, `provider=`+arg(f.Provider))
+	}
+	if f.PRID != "" {
+		where = append(where, `pull_request_id=`+arg(f.PRID))
+	}
+	sql := `SELECT failure_id, provider, pull_request_id, event_id, operation, logins, status_code, error, occurred_at
+        FROM review_sync_failures`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += ` ORDER BY failure_id DESC LIMIT ` + arg(f.Limit) + ` OFFSET ` + arg(f.Offset)
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []SyncFailureRow{}
+	for rows.Next() {
+		var o SyncFailureRow
+		if err := rows.Scan(&o.ID, &o.Provider, &o.PRID, &o.EventID, &o.Operation, &o.Logins, &o.StatusCode, &o.Error, &o.OccurredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,203 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type SubscriptionRow struct {
+	ID         int64
+	URL        string
+	Secret     string
+	EventTypes []string
+	CreatedBy  string
+	CreatedAt  pgtype.Timestamptz
+}
+
+const subscriptionColumns = `subscription_id, url, secret, event_types, created_by, created_at`
+
+func scanSubscription(row pgx.Row) (SubscriptionRow, error) {
+	var s SubscriptionRow
+	err := row.Scan(&s.ID, &s.URL, &s.Secret, &s.EventTypes, &s.CreatedBy, &s.CreatedAt)
+	return s, err
+}
+
+func (r *Repo) CreateSubscription(ctx context.Context, s SubscriptionRow) (SubscriptionRow, error) {
+	return scanSubscription(r.db.QueryRow(ctx, `INSERT INTO webhook_subscriptions(url, secret, event_types, created_by)
+        VALUES ($1,$2,$3,$4) RETURNING `+subscriptionColumns, s.URL, s.Secret, s.EventTypes, s.CreatedBy))
+}
+
+func (r *Repo) ListSubscriptions(ctx context.Context) ([]SubscriptionRow, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY subscription_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []SubscriptionRow{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// DeleteSubscription also drops the subscription's delivery log.
+func (r *Repo) DeleteSubscription(ctx context.Context, id int64) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE subscription_id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnqueueDeliveries queues the event for every subscription that wants its
+// type and returns how many deliveries were queued.
+func (r *Repo) EnqueueDeliveries(ctx context.Context, eventID, eventType, payload string) (int64, error) {
+	tag, err := r.db.Exec(ctx, `INSERT INTO webhook_deliveries(subscription_id, event_id, event_type, payload)
+        SELECT subscription_id, $1, $2, $3 FROM webhook_subscriptions
+        WHERE $2 = ANY(event_types) OR '*' = ANY(event_types)
+        ON CONFLICT (subscription_id, event_id) DO NOTHING`, eventID, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+type DeliveryRow struct {
+	ID             int64
+	SubscriptionID int64
+	EventID        string
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  pgtype.Timestamptz
+	LastStatusCode *int
+	LastError      string
+	CreatedAt      pgtype.Timestamptz
+	DeliveredAt    pgtype.Timestamptz
+	// URL and Secret are only filled in by ClaimDeliveries.
+	URL    string
+	Secret string
+}
+
+// ClaimDeliveries picks up to limit due deliveries and pushes their next
+// attempt lease into the future, so concurrent dispatchers (other replicas
+// included) skip them while they are being sent.
+func (r *Repo) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DeliveryRow, error) {
+	rows, err := r.db.Query(ctx, `UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2)
+        FROM webhook_subscriptions s
+        WHERE s.subscription_id = d.subscription_id AND d.delivery_id IN (
+            SELECT delivery_id FROM webhook_deliveries
+            WHERE status='pending' AND next_attempt_at <= now()
+            ORDER BY next_attempt_at LIMIT $1
+            FOR UPDATE SKIP LOCKED)
+        RETURNING d.delivery_id, d.subscription_id, d.event_id, d.event_type, d.payload, d.attempts, s.url, s.secret`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []DeliveryRow{}
+	for rows.Next() {
+		var d DeliveryRow
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) MarkDelivered(ctx context.Context, id int64, statusCode int) error {
+	_, err := r.db.Exec(ctx, `UPDATE webhook_deliveries
+        SET status='delivered', attempts=attempts+1, last_status_code=$2, last_error=NULL, delivered_at=now()
+        WHERE delivery_id=$1`, id, statusCode)
+	return err
+}
+
+// MarkAttemptFailed records a failed attempt and either schedules the next one
+// at next or, when dead is set, gives up on the delivery.
+func (r *Repo) MarkAttemptFailed(ctx context.Context, id int64, statusCode *int, errText string, next time.Time, dead bool) error {
+	status := "pending"
+	if dead {
+		status = "dead"
+	}
+	_, err := r.db.Exec(ctx, `UPDATE webhook_deliveries
+        SET status=$2, attempts=attempts+1, last_status_code=$3, last_error=$4, next_attempt_at=$5
+        WHERE delivery_id=$1`, id, status, statusCode, errText, next)
+	return err
+}
+
+// RetryDelivery requeues a delivery with a fresh attempt budget.
+func (r *Repo) RetryDelivery(ctx context.Context, id int64) error {
+	tag, err := r.db.Exec(ctx, `UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=now()
+        WHERE delivery_id=$1 AND status<>'delivered'`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type DeliveryFilter struct {
+	SubscriptionID int64
+	EventID        string
+	Status         string
+	Limit          int
+	Offset         int
+}
+
+func (r *Repo) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]DeliveryRow, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.SubscriptionID != 0 {
+		where = append(where, `subscription_id=`+arg(f.SubscriptionID))
+	}
+	if f.EventID != "" {
+		where = append(where, `event_id=`+arg(f.EventID))
+	}
+	if f.Status != "" {
+		where = append(where, `status=`+arg(f.Status))
+	}
+	sql := `SELECT delivery_id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+            last_status_code, COALESCE(last_error,''), created_at, delivered_at
+        FROM webhook_deliveries`
+	if len(where) > 0 {
+		sql += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sql += ` ORDER BY delivery_id DESC LIMIT ` + arg(f.Limit) + ` OFFSET ` + arg(f.Offset)
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []DeliveryRow{}
+	for rows.Next() {
+		var d DeliveryRow
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/service"
+)
+
+// parseAuditFilter reads the filters shared by /audit and /audit/export.
+func parseAuditFilter(w http.ResponseWriter, r *http.Request) (service.AuditFilter, bool) {
+	q := r.URL.Query()
+	f := service.AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target"), Result: q.Get("result")}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return f, false
+			}
+			*p.dst = t
+		}
+	}
+	return f, true
+}
+
+func (s *Server) handleAuditList(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f.Limit = defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be non-negative", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+	entries, err := s.svc.ListAudit(r.Context(), f)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+// handleAuditExport streams every matching entry as JSON lines.
+func (s *Server) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	wrote := false
+	err := s.svc.EachAudit(r.Context(), f, func(e domain.AuditEntry) error {
+		wrote = true
+		return enc.Encode(e)
+	})
+	// Once a line is out the status is sent; a truncated body is all the
+	// client can see.
+	if err == nil || wrote {
+		return
+	}
+	if respondForbidden(w, err) {
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/service"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) mountAuth(r chi.Router) {
+	r.Get("/auth/whoami", s.handleWhoAmI)
+	r.Post("/auth/tokens", s.handleTokenIssue)
+	r.Get("/auth/tokens", s.handleTokenList)
+	r.Post("/auth/tokens/revoke", s.handleTokenRevoke)
+}
+
+func respondAuthError(w http.ResponseWriter, _ *http.Request, err error) {
+	if err != nil && !strings.Contains(err.Error(), string(domain.ErrUnauthorized)) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="pr-service"`)
+	respondError(w, http.StatusUnauthorized, domain.ErrUnauthorized, "missing, unknown, revoked or expired bearer token")
+}
+
+func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	a, _ := auth.ActorFrom(r.Context())
+	respondJSON(w, http.StatusOK, map[string]any{
+		"actor":           a.Name(),
+		"user_id":         a.UserID,
+		"service_account": a.ServiceAccount,
+		"token_id":        a.TokenID,
+		"role":            a.Role,
+	})
+}
+
+func (s *Server) handleTokenIssue(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name           string `json:"name"`
+		UserID         string `json:"user_id"`
+		ServiceAccount string `json:"service_account"`
+		Role           string `json:"role"`
+		TTL            string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if payload.Name == "" || (payload.UserID == "") == (payload.ServiceAccount == "") {
+		http.Error(w, "name and exactly one of user_id or service_account required", http.StatusBadRequest)
+		return
+	}
+	role := domain.Role(payload.Role)
+	switch role {
+	case "", domain.RoleAdmin, domain.RoleBot:
+	case domain.RoleTeamLead, domain.RoleMember:
+		if payload.UserID == "" {
+			http.Error(w, "team_lead and member tokens require user_id", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "role must be admin, team_lead, member or bot", http.StatusBadRequest)
+		return
+	}
+	req := service.TokenRequest{Name: payload.Name, UserID: payload.UserID, ServiceAccount: payload.ServiceAccount, Role: role}
+	if payload.TTL != "" {
+		ttl, err := time.ParseDuration(payload.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "ttl must be a positive duration, e.g. 720h", http.StatusBadRequest)
+			return
+		}
+		req.TTL = ttl
+	}
+	plain, token, err := s.svc.IssueToken(r.Context(), req)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"token": plain, "token_info": token})
+}
+
+func (s *Server) handleTokenList(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.svc.ListTokens(r.Context())
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"tokens": tokens})
+}
+
+func (s *Server) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID int64 `json:"token_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	token, err := s.svc.RevokeToken(r.Context(), payload.ID)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "token not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"token_info": token})
+}
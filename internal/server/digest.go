@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) mountDigest(r chi.Router) {
+	r.Get("/users/digest", s.handleDigestGet)
+	r.Post("/users/digest", s.handleDigestSet)
+	r.Post("/users/digest/delete", s.handleDigestDelete)
+}
+
+func (s *Server) handleDigestGet(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("user_id")
+	if uid == "" {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	prefs, err := s.svc.DigestPreferences(r.Context(), uid)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user has no digest")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"digest": prefs})
+}
+
+func (s *Server) handleDigestSet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID   string                `json:"user_id"`
+		Email    string                `json:"email"`
+		Schedule domain.DigestSchedule `json:"schedule"`
+		Hour     *int                  `json:"hour"`
+		Weekday  *int                  `json:"weekday"`
+		Timezone string                `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	prefs := domain.DigestPreferences{UserID: payload.UserID, Schedule: payload.Schedule, Hour: 9, Weekday: int(time.Monday), Timezone: payload.Timezone}
+	if payload.UserID == "" {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	addr, err := mail.ParseAddress(payload.Email)
+	if err != nil {
+		http.Error(w, "email must be an email address", http.StatusBadRequest)
+		return
+	}
+	prefs.Email = addr.Address
+	if prefs.Schedule != domain.DigestOff && prefs.Schedule != domain.DigestDaily && prefs.Schedule != domain.DigestWeekly {
+		http.Error(w, "schedule must be off, daily or weekly", http.StatusBadRequest)
+		return
+	}
+	if h := payload.Hour; h != nil {
+		if *h < 0 || *h > 23 {
+			http.Error(w, "hour must be between 0 and 23", http.StatusBadRequest)
+			return
+		}
+		prefs.Hour = *h
+	}
+	if d := payload.Weekday; d != nil {
+		if *d < 0 || *d > 6 {
+			http.Error(w, "weekday must be between 0 (Sunday) and 6", http.StatusBadRequest)
+			return
+		}
+		prefs.Weekday = *d
+	}
+	if prefs.Timezone == "" {
+		prefs.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+		http.Error(w, "timezone must be an IANA time zone", http.StatusBadRequest)
+		return
+	}
+	prefs, err = s.svc.SetDigestPreferences(r.Context(), prefs)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"digest": prefs})
+}
+
+func (s *Server) handleDigestDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.DeleteDigestPreferences(r.Context(), payload.UserID); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user has no digest")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"user_id": payload.UserID, "deleted": true})
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/github"
+	"github.com/example/avito-pr-service/internal/service"
+)
+
+// WithGitHub mounts POST /github/webhook, which turns GitHub pull_request and
+// pull_request_review webhooks into PR lifecycle calls. It sits outside bearer
+// authentication: requests are verified with the webhook secret instead.
+func WithGitHub(cfg github.Config) Option {
+	return func(o *options) { o.github = cfg }
+}
+
+func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIngestBody))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !github.VerifySignature(s.github.WebhookSecret, body, r.Header.Get(github.HeaderSignature)) {
+		respondError(w, http.StatusUnauthorized, domain.ErrUnauthorized, "invalid webhook signature")
+		return
+	}
+	switch r.Header.Get(github.HeaderEvent) {
+	case "ping":
+		respondJSON(w, http.StatusOK, ingestResult{Status: "pong"})
+		return
+	case "pull_request":
+	case "pull_request_review":
+		s.ingestGitHubReview(w, r, body)
+		return
+	default:
+		ingestIgnored(w, "", "event type not handled")
+		return
+	}
+	var ev github.PullRequestEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Repository.FullName == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	ctx := ingestActor(r.Context(), domain.ProviderGitHub)
+	id := ev.PRID()
+	switch ev.Action {
+	case github.ActionOpened:
+		s.ingestOpen(ctx, w, domain.ProviderGitHub, r.Header.Get(github.HeaderDelivery), ev.PullRequest.User.Login, id, ev.PullRequest.Title, ev.PullRequest.Draft)
+	case github.ActionClosed:
+		if ev.PullRequest.Merged {
+			pr, err := s.svc.RecordUpstreamMerge(ctx, id)
+			respondIngest(w, "merged", id, pr, err)
+			return
+		}
+		pr, err := s.svc.ClosePR(ctx, id)
+		respondIngest(w, "closed", id, pr, err)
+	case github.ActionReopened:
+		pr, err := s.svc.ReopenPR(ctx, id)
+		respondIngest(w, "reopened", id, pr, err)
+	case github.ActionReadyForReview, github.ActionConvertedToDraft:
+		pr, err := s.svc.SetPRDraft(ctx, id, ev.Action == github.ActionConvertedToDraft)
+		respondIngest(w, ev.Action, id, pr, err)
+	default:
+		ingestIgnored(w, id, "action not handled")
+	}
+}
+
+// ingestGitHubReview records a submitted review by the user linked to the
+// reviewer's login. Reviews by unlinked logins or by users not assigned to the
+// PR are acknowledged without changes.
+func (s *Server) ingestGitHubReview(w http.ResponseWriter, r *http.Request, body []byte) {
+	var ev github.PullRequestReviewEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Repository.FullName == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	id := ev.PRID()
+	if ev.Action != github.ActionSubmitted {
+		ingestIgnored(w, id, "action not handled")
+		return
+	}
+	ctx := ingestActor(r.Context(), domain.ProviderGitHub)
+	userID, err := s.svc.VCSAccountUser(ctx, domain.ProviderGitHub, ev.Review.User.Login)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			ingestIgnored(w, id, "reviewer login is not linked to a user")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pr, err := s.svc.ReviewPR(ctx, id, userID)
+	if err != nil && strings.Contains(err.Error(), string(domain.ErrNotAssigned)) {
+		ingestIgnored(w, id, "reviewer is not assigned to this PR")
+		return
+	}
+	respondIngest(w, "reviewed", id, pr, err)
+}
+
+func (s *Server) handleGitHubSyncFailures(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.SyncFailureFilter{PRID: q.Get("pull_request_id"), Limit: defaultListLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be non-negative", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+	failures, err := s.svc.ListSyncFailures(r.Context(), domain.ProviderGitHub, f)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"failures": failures})
+}
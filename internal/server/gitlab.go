@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/gitlab"
+)
+
+// WithGitLab mounts POST /gitlab/webhook, which turns GitLab merge request
+// webhooks into PR lifecycle calls. Like the GitHub endpoint it is verified
+// with a shared secret rather than a bearer token.
+func WithGitLab(cfg gitlab.Config) Option {
+	return func(o *options) { o.gitlab = cfg }
+}
+
+func (s *Server) handleGitLabWebhook(w http.ResponseWriter, r *http.Request) {
+	if !gitlab.VerifyToken(s.gitlab.WebhookToken, r.Header.Get(gitlab.HeaderToken)) {
+		respondError(w, http.StatusUnauthorized, domain.ErrUnauthorized, "invalid webhook token")
+		return
+	}
+	if r.Header.Get(gitlab.HeaderEvent) != gitlab.EventMergeRequest {
+		ingestIgnored(w, "", "event type not handled")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIngestBody))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var ev gitlab.MergeRequestEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Project.ID == 0 || ev.ObjectAttributes.IID == 0 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	ctx := ingestActor(r.Context(), domain.ProviderGitLab)
+	id := ev.PRID()
+	mr := ev.ObjectAttributes
+	switch mr.Action {
+	case gitlab.ActionOpen:
+		s.ingestOpen(ctx, w, domain.ProviderGitLab, r.Header.Get(gitlab.HeaderUUID), ev.User.Username, id, mr.Title, mr.IsDraft())
+	case gitlab.ActionMerge:
+		pr, err := s.svc.RecordUpstreamMerge(ctx, id)
+		respondIngest(w, "merged", id, pr, err)
+	case gitlab.ActionClose:
+		pr, err := s.svc.ClosePR(ctx, id)
+		respondIngest(w, "closed", id, pr, err)
+	case gitlab.ActionReopen:
+		pr, err := s.svc.ReopenPR(ctx, id)
+		respondIngest(w, "reopened", id, pr, err)
+	case gitlab.ActionUpdate:
+		draft, changed := ev.Changes.DraftChange()
+		if !changed {
+			ingestIgnored(w, id, "update does not change the draft flag")
+			return
+		}
+		status := "ready_for_review"
+		if draft {
+			status = "converted_to_draft"
+		}
+		pr, err := s.svc.SetPRDraft(ctx, id, draft)
+		respondIngest(w, status, id, pr, err)
+	default:
+		ingestIgnored(w, id, "action not handled")
+	}
+}
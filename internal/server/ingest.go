@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxIngestBody bounds webhook payloads read from code hosts.
+const maxIngestBody = 5 << 20
+
+// ingestResult is the answer to an ingested webhook. Status says what the
+// service did: created, merged, closed, reopened, ready_for_review,
+// converted_to_draft, reviewed, duplicate or ignored.
+type ingestResult struct {
+	Status string              `json:"status"`
+	ID     string              `json:"pull_request_id,omitempty"`
+	Reason string              `json:"reason,omitempty"`
+	PR     *domain.PullRequest `json:"pr,omitempty"`
+}
+
+// ingestActor attributes changes made on behalf of a code host; it acts for
+// any author, so it needs the admin role when access control is on.
+func ingestActor(ctx context.Context, provider string) context.Context {
+	a := auth.Actor{ServiceAccount: provider, Role: domain.RoleAdmin}
+	logging.AddAccessAttrs(ctx, slog.String("actor", a.Name()))
+	return auth.WithActor(ctx, a)
+}
+
+// ingestOpen creates the PR for an "opened" webhook, authored by the user
+// linked to login. Unlinked logins and redeliveries are acknowledged without
+// changes.
+func (s *Server) ingestOpen(ctx context.Context, w http.ResponseWriter, provider, delivery, login, id, title string, draft bool) {
+	author, err := s.svc.VCSAccountUser(ctx, provider, login)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			logger.InfoContext(ctx, "webhook author not linked", "provider", provider, "delivery", delivery, "login", login)
+			ingestIgnored(w, id, "author login is not linked to a user")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pr, err := s.svc.CreatePR(ctx, id, title, author, "", draft)
+	if err != nil && strings.Contains(err.Error(), string(domain.ErrPRExists)) {
+		respondJSON(w, http.StatusOK, ingestResult{Status: "duplicate", ID: id})
+		return
+	}
+	respondIngest(w, "created", id, pr, err)
+}
+
+// ingestIgnored acknowledges a webhook the service does not act on.
+func ingestIgnored(w http.ResponseWriter, id, reason string) {
+	respondJSON(w, http.StatusAccepted, ingestResult{Status: "ignored", ID: id, Reason: reason})
+}
+
+// respondIngest answers an ingested webhook with the outcome of the service
+// call it triggered.
+func respondIngest(w http.ResponseWriter, status, id string, pr domain.PullRequest, err error) {
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR, author or team not found")
+		case strings.Contains(err.Error(), string(domain.ErrMergeBlocked)):
+			respondError(w, http.StatusConflict, domain.ErrMergeBlocked, "team merge policy requires more reviewers")
+		case strings.Contains(err.Error(), string(domain.ErrPRMerged)):
+			respondError(w, http.StatusConflict, domain.ErrPRMerged, "PR is already merged")
+		case strings.Contains(err.Error(), string(domain.ErrPRClosed)):
+			respondError(w, http.StatusConflict, domain.ErrPRClosed, "PR is closed")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, ingestResult{Status: status, ID: id, PR: &pr})
+}
+
+// mountVCSAccounts registers the admin endpoints linking provider logins to
+// users under /{provider}/accounts.
+func (s *Server) mountVCSAccounts(r chi.Router, provider string) {
+	r.Post("/"+provider+"/accounts", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Login  string `json:"login"`
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if payload.Login == "" || payload.UserID == "" {
+			http.Error(w, "login and user_id required", http.StatusBadRequest)
+			return
+		}
+		account, err := s.svc.LinkVCSAccount(r.Context(), provider, payload.Login, payload.UserID)
+		if err != nil {
+			if respondForbidden(w, err) {
+				return
+			}
+			if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+				respondError(w, http.StatusNotFound, domain.ErrNotFound, "user not found")
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, http.StatusCreated, map[string]any{"account": account})
+	})
+	r.Get("/"+provider+"/accounts", func(w http.ResponseWriter, r *http.Request) {
+		accounts, err := s.svc.ListVCSAccounts(r.Context(), provider)
+		if err != nil {
+			if respondForbidden(w, err) {
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"accounts": accounts})
+	})
+	r.Post("/"+provider+"/accounts/delete", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Login string `json:"login"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := s.svc.UnlinkVCSAccount(r.Context(), provider, payload.Login); err != nil {
+			if respondForbidden(w, err) {
+				return
+			}
+			if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+				respondError(w, http.StatusNotFound, domain.ErrNotFound, "login not linked")
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"login": payload.Login, "deleted": true})
+	})
+}
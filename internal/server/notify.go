@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) mountNotify(r chi.Router) {
+	r.Get("/team/notifications", s.handleNotifyChannelGet)
+	r.Post("/team/notifications", s.handleNotifyChannelSet)
+	r.Post("/team/notifications/delete", s.handleNotifyChannelDelete)
+	r.Get("/users/notifications", s.handleNotifyUserGet)
+	r.Post("/users/notifications", s.handleNotifyUserSet)
+	r.Post("/users/notifications/delete", s.handleNotifyUserDelete)
+}
+
+func (s *Server) handleNotifyChannelGet(w http.ResponseWriter, r *http.Request) {
+	team := r.URL.Query().Get("team_name")
+	if team == "" {
+		http.Error(w, "team_name required", http.StatusBadRequest)
+		return
+	}
+	s.respondNotifyChannel(w, r, team)
+}
+
+func (s *Server) handleNotifyChannelSet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName   string `json:"team_name"`
+		Channel    string `json:"channel"`
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if payload.TeamName == "" || payload.Channel == "" {
+		http.Error(w, "team_name and channel required", http.StatusBadRequest)
+		return
+	}
+	if payload.WebhookURL != "" {
+		if u, err := url.Parse(payload.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "webhook_url must be an http(s) URL", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := s.svc.SetNotifyChannel(r.Context(), payload.TeamName, payload.Channel, payload.WebhookURL); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondNotifyChannel(w, r, payload.TeamName)
+}
+
+func (s *Server) handleNotifyChannelDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName string `json:"team_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.DeleteNotifyChannel(r.Context(), payload.TeamName); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team has no channel")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondNotifyChannel(w, r, payload.TeamName)
+}
+
+func (s *Server) respondNotifyChannel(w http.ResponseWriter, r *http.Request, team string) {
+	own, effective, err := s.svc.NotifyChannel(r.Context(), team)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team_name": team, "channel": own, "effective": effective})
+}
+
+func (s *Server) handleNotifyUserGet(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("user_id")
+	if uid == "" {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	u, err := s.svc.NotifyUser(r.Context(), uid)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user has no chat handle")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"notifications": u})
+}
+
+func (s *Server) handleNotifyUserSet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID string `json:"user_id"`
+		Handle string `json:"handle"`
+		DM     bool   `json:"dm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	payload.Handle = strings.TrimPrefix(strings.TrimSpace(payload.Handle), "@")
+	if payload.UserID == "" || payload.Handle == "" {
+		http.Error(w, "user_id and handle required", http.StatusBadRequest)
+		return
+	}
+	u, err := s.svc.SetNotifyUser(r.Context(), payload.UserID, payload.Handle, payload.DM)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"notifications": u})
+}
+
+func (s *Server) handleNotifyUserDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.DeleteNotifyUser(r.Context(), payload.UserID); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user has no chat handle")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"user_id": payload.UserID, "deleted": true})
+}
@@ -1,45 +1,192 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/example/avito-pr-service/internal/auth"
 	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/github"
+	"github.com/example/avito-pr-service/internal/gitlab"
+	"github.com/example/avito-pr-service/internal/health"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/metrics"
+	"github.com/example/avito-pr-service/internal/outbox"
 	"github.com/example/avito-pr-service/internal/repo"
 	"github.com/example/avito-pr-service/internal/service"
+	"github.com/example/avito-pr-service/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var logger = logging.Logger("server")
+
 type Server struct {
-	svc *service.Service
+	svc    *service.Service
+	github github.Config
+	gitlab gitlab.Config
+}
+
+type options struct {
+	auth    auth.Config
+	relay   *outbox.Relay
+	github  github.Config
+	gitlab  gitlab.Config
+	metrics *metrics.Metrics
+	health  *health.Checker
+	teams   *domain.EffectiveTeamSettings
+}
+
+type Option func(*options)
+
+// WithAuth requires a bearer token on every endpoint except the probes
+// (/healthz, /livez, /readyz), /metrics and the code host webhooks, and
+// mounts the token administration endpoints under /auth.
+func WithAuth(cfg auth.Config) Option {
+	return func(o *options) { o.auth = cfg }
+}
+
+// WithOutbox wakes relay whenever a request commits events to the outbox.
+// Without it events are still written and picked up on the relay's next poll.
+func WithOutbox(relay *outbox.Relay) Option {
+	return func(o *options) { o.relay = relay }
+}
+
+// WithMetrics instruments every request and business outcome in m and serves
+// it on GET /metrics, which, like the probes, needs no bearer token.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) { o.metrics = m }
+}
+
+// WithHealth serves /livez and /readyz from c, so the caller can Drain it on
+// shutdown. Without it the router answers them from a checker of its own.
+func WithHealth(c *health.Checker) Option {
+	return func(o *options) { o.health = c }
 }
 
-func NewRouter(pool *pgxpool.Pool) http.Handler {
+// WithTeamDefaults overrides service.DefaultTeamSettings, the settings of
+// teams that neither set nor inherit them.
+func WithTeamDefaults(d domain.EffectiveTeamSettings) Option {
+	return func(o *options) { o.teams = &d }
+}
+
+func NewRouter(pool *pgxpool.Pool, opts ...Option) http.Handler {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var svcOpts []service.Option
+	if o.auth.Enabled {
+		svcOpts = append(svcOpts, service.WithAccessControl())
+	}
+	if o.relay != nil {
+		svcOpts = append(svcOpts, service.WithEventNotifier(o.relay.Notify))
+	}
+	if o.metrics != nil {
+		svcOpts = append(svcOpts, service.WithObserver(o.metrics))
+	}
+	if o.teams != nil {
+		svcOpts = append(svcOpts, service.WithTeamDefaults(*o.teams))
+	}
 	r := chi.NewRouter()
-	s := &Server{svc: service.New(repo.New(pool))}
+	s := &Server{svc: service.New(repo.New(pool), svcOpts...), github: o.github, gitlab: o.gitlab}
+	r.Use(logging.Middleware)
+	r.Use(tracing.Middleware)
+	if o.metrics != nil {
+		r.Use(o.metrics.Middleware)
+		r.Method(http.MethodGet, "/metrics", o.metrics.Handler())
+	}
 
+	if o.health == nil {
+		o.health = health.New(pool, health.DefaultConfig())
+	}
+	r.Get("/livez", o.health.Livez)
+	r.Get("/readyz", o.health.Readyz)
+	// /healthz predates the split probes and, like /livez, checks nothing.
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte("ok")); err != nil {
-			log.Printf("healthz write error: %v", err)
+			logger.WarnContext(r.Context(), "healthz write failed", logging.Err(err))
 		}
 	})
 
+	if o.github.Enabled() {
+		r.Post("/github/webhook", s.handleGitHubWebhook)
+	}
+	if o.gitlab.Enabled() {
+		r.Post("/gitlab/webhook", s.handleGitLabWebhook)
+	}
+
+	r.Group(func(r chi.Router) {
+		if o.auth.Enabled {
+			authenticate := auth.Authenticator(s.svc.Authenticate)
+			if o.auth.JWT.Enabled() {
+				authenticate = auth.NewJWTValidator(o.auth.JWT).Authenticator(authenticate)
+			}
+			r.Use(auth.Middleware(authenticate, o.auth.BootstrapToken, respondAuthError))
+			r.Use(logActor)
+			s.mountAuth(r)
+		}
+		s.mountAPI(r)
+		s.mountWebhooks(r)
+		s.mountNotify(r)
+		s.mountDigest(r)
+		s.mountVCSAccounts(r, domain.ProviderGitHub)
+		r.Get("/github/sync/failures", s.handleGitHubSyncFailures)
+		s.mountVCSAccounts(r, domain.ProviderGitLab)
+	})
+	return r
+}
+
+// logActor names the authenticated caller in the request's access log.
+func logActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a, ok := auth.ActorFrom(r.Context()); ok {
+			logging.AddAccessAttrs(r.Context(), slog.String("actor", a.Name()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) mountAPI(r chi.Router) {
 	r.Post("/team/add", s.handleTeamAdd)
 	r.Get("/team/get", s.handleTeamGet)
+	r.Post("/team/addMembers", s.handleTeamAddMembers)
+	r.Post("/team/removeMembers", s.handleTeamRemoveMembers)
+	r.Post("/team/moveMember", s.handleTeamMoveMember)
+	r.Post("/team/rename", s.handleTeamRename)
+	r.Post("/team/delete", s.handleTeamDelete)
+	r.Get("/team/tree", s.handleTeamTree)
+	r.Post("/team/setParent", s.handleTeamSetParent)
+	r.Get("/team/settings", s.handleTeamSettingsGet)
+	r.Post("/team/settings", s.handleTeamSettingsSet)
 	r.Post("/users/setIsActive", s.handleSetIsActive)
+	r.Get("/users/get", s.handleUserGet)
 
 	r.Post("/pullRequest/create", s.handlePRCreate)
+	r.Post("/pullRequest/createBatch", s.handlePRCreateBatch)
 	r.Post("/pullRequest/merge", s.handlePRMerge)
+	r.Post("/pullRequest/setDraft", s.handlePRSetDraft)
+	r.Post("/pullRequest/close", s.handlePRClose)
+	r.Post("/pullRequest/reopen", s.handlePRReopen)
 	r.Post("/pullRequest/reassign", s.handlePRReassign)
+	r.Post("/pullRequest/review", s.handlePRReview)
+	r.Get("/pullRequest/list", s.handlePRList)
 	r.Get("/users/getReview", s.handleUserGetReview)
 
 	r.Get("/stats/assignments", s.handleStatsAssignments)
+	r.Get("/stats/teams", s.handleStatsTeams)
+	r.Get("/stats/cycle-time", s.handleStatsCycleTime)
+	r.Get("/stats/fairness", s.handleStatsFairness)
 	r.Post("/team/deactivateUsers", s.handleTeamDeactivate)
-	return r
+
+	r.Get("/audit", s.handleAuditList)
+	r.Get("/audit/export", s.handleAuditExport)
 }
 
 func respondJSON(w http.ResponseWriter, status int, v any) {
@@ -52,6 +199,16 @@ func respondError(w http.ResponseWriter, httpCode int, code domain.APIErrorCode,
 	respondJSON(w, httpCode, domain.NewAPIError(code, message))
 }
 
+// respondForbidden answers 403 when err is an access-control rejection and
+// reports whether it did.
+func respondForbidden(w http.ResponseWriter, err error) bool {
+	if !strings.Contains(err.Error(), string(domain.ErrForbidden)) {
+		return false
+	}
+	respondError(w, http.StatusForbidden, domain.ErrForbidden, "not allowed for the caller's role")
+	return true
+}
+
 func (s *Server) handleTeamAdd(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		TeamName string              `json:"team_name"`
@@ -61,8 +218,15 @@ func (s *Server) handleTeamAdd(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	if !validMemberRoles(payload.Members) {
+		http.Error(w, "role must be member or lead", http.StatusBadRequest)
+		return
+	}
 	team, err := s.svc.CreateTeam(r.Context(), domain.Team{TeamName: payload.TeamName, Members: payload.Members})
 	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
 		if strings.Contains(err.Error(), string(domain.ErrTeamExists)) {
 			respondError(w, http.StatusBadRequest, domain.ErrTeamExists, "team_name already exists")
 			return
@@ -91,16 +255,307 @@ func (s *Server) handleTeamGet(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, team)
 }
 
+// validMemberRoles reports whether every member's role is empty, member or
+// lead.
+func validMemberRoles(members []domain.TeamMember) bool {
+	for _, m := range members {
+		switch m.Role {
+		case "", domain.MemberRoleMember, domain.MemberRoleLead:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func parseReviewPolicy(v string, def domain.ReviewPolicy, allowed ...domain.ReviewPolicy) (domain.ReviewPolicy, bool) {
+	if v == "" {
+		return def, true
+	}
+	for _, p := range allowed {
+		if domain.ReviewPolicy(v) == p {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) handleTeamAddMembers(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName string              `json:"team_name"`
+		Members  []domain.TeamMember `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !validMemberRoles(payload.Members) {
+		http.Error(w, "role must be member or lead", http.StatusBadRequest)
+		return
+	}
+	team, err := s.svc.AddMembers(r.Context(), payload.TeamName, payload.Members)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team": team})
+}
+
+func (s *Server) handleTeamRemoveMembers(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName     string   `json:"team_name"`
+		UserIDs      []string `json:"user_ids"`
+		ReviewPolicy string   `json:"review_policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	policy, ok := parseReviewPolicy(payload.ReviewPolicy, domain.PolicyReassignOld, domain.PolicyKeep, domain.PolicyReassignOld)
+	if !ok {
+		http.Error(w, "review_policy must be keep or reassign_old", http.StatusBadRequest)
+		return
+	}
+	reassigned, removed, err := s.svc.RemoveMembers(r.Context(), payload.TeamName, payload.UserIDs, policy)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found or user is not its member")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team_name": payload.TeamName, "reassigned": reassigned, "removed": removed})
+}
+
+func (s *Server) handleTeamMoveMember(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserID       string `json:"user_id"`
+		FromTeamName string `json:"from_team_name"`
+		TeamName     string `json:"team_name"`
+		ReviewPolicy string `json:"review_policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	policy, ok := parseReviewPolicy(payload.ReviewPolicy, domain.PolicyKeep, domain.PolicyKeep, domain.PolicyReassignOld, domain.PolicyReassignNew)
+	if !ok {
+		http.Error(w, "review_policy must be keep, reassign_old or reassign_new", http.StatusBadRequest)
+		return
+	}
+	user, reassigned, removed, err := s.svc.MoveMember(r.Context(), payload.UserID, payload.FromTeamName, payload.TeamName, policy)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user or team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"user": user, "reassigned": reassigned, "removed": removed})
+}
+
+func (s *Server) handleTeamRename(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName    string `json:"team_name"`
+		NewTeamName string `json:"new_team_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if payload.NewTeamName == "" {
+		http.Error(w, "new_team_name required", http.StatusBadRequest)
+		return
+	}
+	team, err := s.svc.RenameTeam(r.Context(), payload.TeamName, payload.NewTeamName)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+		case strings.Contains(err.Error(), string(domain.ErrTeamExists)):
+			respondError(w, http.StatusBadRequest, domain.ErrTeamExists, "new_team_name already exists")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team": team})
+}
+
+func (s *Server) handleTeamDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName string `json:"team_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.DeleteTeam(r.Context(), payload.TeamName); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrTeamNotEmpty)):
+			respondError(w, http.StatusConflict, domain.ErrTeamNotEmpty, "team still has members")
+		case strings.Contains(err.Error(), string(domain.ErrTeamHasChildren)):
+			respondError(w, http.StatusConflict, domain.ErrTeamHasChildren, "team still has sub-teams")
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team_name": payload.TeamName, "deleted": true})
+}
+
+func (s *Server) handleTeamTree(w http.ResponseWriter, r *http.Request) {
+	root := r.URL.Query().Get("team_name")
+	tree, err := s.svc.TeamTree(r.Context(), root)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"teams": tree})
+}
+
+func (s *Server) handleTeamSetParent(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName   string `json:"team_name"`
+		ParentName string `json:"parent_team_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.SetTeamParent(r.Context(), payload.TeamName, payload.ParentName); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team or parent not found")
+		case strings.Contains(err.Error(), string(domain.ErrTeamCycle)):
+			respondError(w, http.StatusConflict, domain.ErrTeamCycle, "parent is the team itself or one of its sub-teams")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	s.respondTeamSettings(w, r, payload.TeamName)
+}
+
+func (s *Server) handleTeamSettingsGet(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("team_name")
+	if name == "" {
+		http.Error(w, "team_name required", http.StatusBadRequest)
+		return
+	}
+	s.respondTeamSettings(w, r, name)
+}
+
+func (s *Server) handleTeamSettingsSet(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		TeamName string `json:"team_name"`
+		domain.TeamSettings
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if c := payload.ReviewerCount; c != nil && (*c < 0 || *c > 10) {
+		http.Error(w, "reviewer_count must be between 0 and 10", http.StatusBadRequest)
+		return
+	}
+	if st := payload.Strategy; st != nil && *st != domain.StrategyRandom && *st != domain.StrategyLeastLoaded {
+		http.Error(w, "assignment_strategy must be random or least_loaded", http.StatusBadRequest)
+		return
+	}
+	if mp := payload.MergePolicy; mp != nil && *mp != domain.MergeAny && *mp != domain.MergeRequireReviewers {
+		http.Error(w, "merge_policy must be any or require_reviewers", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.SetTeamSettings(r.Context(), payload.TeamName, payload.TeamSettings); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.respondTeamSettings(w, r, payload.TeamName)
+}
+
+func (s *Server) respondTeamSettings(w http.ResponseWriter, r *http.Request, team string) {
+	parent, own, effective, err := s.svc.TeamSettings(r.Context(), team)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"team_name": team, "parent_team_name": parent, "settings": own, "effective": effective})
+}
+
 func (s *Server) handleSetIsActive(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		UserID   string `json:"user_id"`
+		TeamName string `json:"team_name"`
 		IsActive bool   `json:"is_active"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	user, err := s.svc.SetUserActive(r.Context(), payload.UserID, payload.IsActive)
+	user, err := s.svc.SetUserActive(r.Context(), payload.UserID, payload.TeamName, payload.IsActive)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user or membership not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"user": user})
+}
+
+func (s *Server) handleUserGet(w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("user_id")
+	if uid == "" {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	user, err := s.svc.GetUser(r.Context(), uid)
 	if err != nil {
 		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
 			respondError(w, http.StatusNotFound, domain.ErrNotFound, "user not found")
@@ -117,13 +572,18 @@ func (s *Server) handlePRCreate(w http.ResponseWriter, r *http.Request) {
 		ID     string `json:"pull_request_id"`
 		Name   string `json:"pull_request_name"`
 		Author string `json:"author_id"`
+		Team   string `json:"team_name"`
+		Draft  bool   `json:"is_draft"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	pr, err := s.svc.CreatePR(r.Context(), payload.ID, payload.Name, payload.Author)
+	pr, err := s.svc.CreatePR(r.Context(), payload.ID, payload.Name, payload.Author, payload.Team, payload.Draft)
 	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
 		switch {
 		case strings.Contains(err.Error(), string(domain.ErrPRExists)):
 			respondError(w, http.StatusConflict, domain.ErrPRExists, "PR id already exists")
@@ -139,6 +599,44 @@ func (s *Server) handlePRCreate(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, map[string]any{"pr": pr})
 }
 
+func (s *Server) handlePRCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PullRequests []struct {
+			ID     string `json:"pull_request_id"`
+			Name   string `json:"pull_request_name"`
+			Author string `json:"author_id"`
+			Team   string `json:"team_name"`
+		} `json:"pull_requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if len(payload.PullRequests) == 0 || len(payload.PullRequests) > service.MaxBatchSize {
+		http.Error(w, "pull_requests must contain between 1 and 1000 items", http.StatusBadRequest)
+		return
+	}
+	items := make([]service.BatchPRInput, 0, len(payload.PullRequests))
+	for _, p := range payload.PullRequests {
+		items = append(items, service.BatchPRInput{ID: p.ID, Name: p.Name, AuthorID: p.Author, TeamName: p.Team})
+	}
+	results, err := s.svc.CreatePRBatch(r.Context(), items)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created := 0
+	for _, res := range results {
+		if res.Error == nil {
+			created++
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"results": results, "created": created, "failed": len(results) - created})
+}
+
 func (s *Server) handlePRMerge(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		ID string `json:"pull_request_id"`
@@ -149,11 +647,86 @@ func (s *Server) handlePRMerge(w http.ResponseWriter, r *http.Request) {
 	}
 	pr, err := s.svc.MergePR(r.Context(), payload.ID)
 	if err != nil {
-		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
 			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR not found")
+		case strings.Contains(err.Error(), string(domain.ErrMergeBlocked)):
+			respondError(w, http.StatusConflict, domain.ErrMergeBlocked, "team merge policy requires more reviewers")
+		case strings.Contains(err.Error(), string(domain.ErrPRClosed)):
+			respondError(w, http.StatusConflict, domain.ErrPRClosed, "PR is closed; reopen it first")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"pr": pr})
+}
+
+func (s *Server) handlePRClose(w http.ResponseWriter, r *http.Request) {
+	s.handlePRSetClosed(w, r, s.svc.ClosePR)
+}
+
+func (s *Server) handlePRReopen(w http.ResponseWriter, r *http.Request) {
+	s.handlePRSetClosed(w, r, s.svc.ReopenPR)
+}
+
+func (s *Server) handlePRSetClosed(w http.ResponseWriter, r *http.Request, apply func(context.Context, string) (domain.PullRequest, error)) {
+	var payload struct {
+		ID string `json:"pull_request_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	pr, err := apply(r.Context(), payload.ID)
+	if err != nil {
+		if respondForbidden(w, err) {
 			return
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR not found")
+		case strings.Contains(err.Error(), string(domain.ErrPRMerged)):
+			respondError(w, http.StatusConflict, domain.ErrPRMerged, "PR is already merged")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"pr": pr})
+}
+
+func (s *Server) handlePRSetDraft(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID    string `json:"pull_request_id"`
+		Draft *bool  `json:"is_draft"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if payload.Draft == nil {
+		http.Error(w, "is_draft required", http.StatusBadRequest)
+		return
+	}
+	pr, err := s.svc.SetPRDraft(r.Context(), payload.ID, *payload.Draft)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR not found")
+		case strings.Contains(err.Error(), string(domain.ErrPRMerged)):
+			respondError(w, http.StatusConflict, domain.ErrPRMerged, "PR is already merged")
+		case strings.Contains(err.Error(), string(domain.ErrPRClosed)):
+			respondError(w, http.StatusConflict, domain.ErrPRClosed, "PR is closed")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 	respondJSON(w, http.StatusOK, map[string]any{"pr": pr})
@@ -170,6 +743,9 @@ func (s *Server) handlePRReassign(w http.ResponseWriter, r *http.Request) {
 	}
 	pr, replacedBy, err := s.svc.ReassignReviewer(r.Context(), payload.ID, payload.Old)
 	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
 		switch {
 		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
 			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR or user not found")
@@ -177,6 +753,9 @@ func (s *Server) handlePRReassign(w http.ResponseWriter, r *http.Request) {
 		case strings.Contains(err.Error(), string(domain.ErrPRMerged)):
 			respondError(w, http.StatusConflict, domain.ErrPRMerged, "cannot reassign on merged PR")
 			return
+		case strings.Contains(err.Error(), string(domain.ErrPRClosed)):
+			respondError(w, http.StatusConflict, domain.ErrPRClosed, "cannot reassign on closed PR")
+			return
 		case strings.Contains(err.Error(), string(domain.ErrNotAssigned)):
 			respondError(w, http.StatusConflict, domain.ErrNotAssigned, "reviewer is not assigned to this PR")
 			return
@@ -191,6 +770,98 @@ func (s *Server) handlePRReassign(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]any{"pr": pr, "replaced_by": replacedBy})
 }
 
+func (s *Server) handlePRReview(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID     string `json:"pull_request_id"`
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	pr, err := s.svc.ReviewPR(r.Context(), payload.ID, payload.UserID)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		switch {
+		case strings.Contains(err.Error(), string(domain.ErrNotFound)):
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "PR not found")
+		case strings.Contains(err.Error(), string(domain.ErrPRMerged)):
+			respondError(w, http.StatusConflict, domain.ErrPRMerged, "PR is already merged")
+		case strings.Contains(err.Error(), string(domain.ErrPRClosed)):
+			respondError(w, http.StatusConflict, domain.ErrPRClosed, "PR is closed")
+		case strings.Contains(err.Error(), string(domain.ErrNotAssigned)):
+			respondError(w, http.StatusConflict, domain.ErrNotAssigned, "reviewer is not assigned to this PR")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"pr": pr})
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+func (s *Server) handlePRList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.PRFilter{
+		TeamName:   q.Get("team_name"),
+		AuthorID:   q.Get("author_id"),
+		ReviewerID: q.Get("reviewer_id"),
+		Name:       q.Get("name"),
+		Limit:      defaultListLimit,
+	}
+	if st := q.Get("status"); st != "" {
+		if st != string(domain.PROpen) && st != string(domain.PRMerged) && st != string(domain.PRClosed) {
+			http.Error(w, "status must be OPEN, MERGED or CLOSED", http.StatusBadRequest)
+			return
+		}
+		f.Status = domain.PRStatus(st)
+	}
+	if v := q.Get("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "min_age must be a non-negative duration, e.g. 48h", http.StatusBadRequest)
+			return
+		}
+		f.MinAge = d
+	}
+	if v := q.Get("understaffed"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "understaffed must be a boolean", http.StatusBadRequest)
+			return
+		}
+		f.Understaffed = b
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be non-negative", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+	prs, err := s.svc.ListPRs(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"pull_requests": prs})
+}
+
 func (s *Server) handleUserGetReview(w http.ResponseWriter, r *http.Request) {
 	uid := r.URL.Query().Get("user_id")
 	if uid == "" {
@@ -205,29 +876,33 @@ func (s *Server) handleUserGetReview(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]any{"user_id": uid, "pull_requests": prs})
 }
 
-func (s *Server) handleStatsAssignments(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.svc.AssignmentStats(r.Context())
+func (s *Server) handleStatsTeams(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.svc.TeamStats(r.Context(), r.URL.Query().Get("team_name"))
 	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	out := make([]map[string]any, 0, len(rows))
-	for _, it := range rows {
-		out = append(out, map[string]any{"user_id": it.UserID, "count": it.Count})
-	}
-	respondJSON(w, http.StatusOK, map[string]any{"assignments": out})
+	respondJSON(w, http.StatusOK, map[string]any{"teams": stats})
 }
 
 func (s *Server) handleTeamDeactivate(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Team string `json:"team_name"`
+		Team      string `json:"team_name"`
+		Recursive bool   `json:"recursive"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	reassigned, removed, err := s.svc.MassDeactivate(r.Context(), payload.Team)
+	reassigned, removed, err := s.svc.MassDeactivate(r.Context(), payload.Team, payload.Recursive)
 	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
 		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
 			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
 			return
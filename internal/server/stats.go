@@ -0,0 +1,117 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+	"github.com/example/avito-pr-service/internal/service"
+)
+
+// parseWindow reads the from and to query parameters, each an RFC 3339
+// timestamp or a YYYY-MM-DD date meaning its midnight in UTC.
+func parseWindow(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	q := r.URL.Query()
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 timestamp or a YYYY-MM-DD date", http.StatusBadRequest)
+				return time.Time{}, time.Time{}, false
+			}
+		}
+		*p.dst = t
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+func (s *Server) handleStatsAssignments(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parseWindow(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := service.AssignmentStatsFilter{From: from, To: to, UserID: q.Get("user_id"), Team: q.Get("team_name")}
+	if v := q.Get("group_by"); v != "" {
+		for _, g := range strings.Split(v, ",") {
+			g = strings.TrimSpace(g)
+			if g != repo.GroupUser && g != repo.GroupTeam && g != repo.GroupWeek {
+				http.Error(w, "group_by must list user, team and week", http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(f.GroupBy, g) {
+				f.GroupBy = append(f.GroupBy, g)
+			}
+		}
+	}
+	stats, err := s.svc.AssignmentStats(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"assignments": stats})
+}
+
+func (s *Server) handleStatsCycleTime(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parseWindow(w, r)
+	if !ok {
+		return
+	}
+	report, err := s.svc.CycleTimes(r.Context(), service.CycleTimeFilter{From: from, To: to, Team: r.URL.Query().Get("team_name")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) handleStatsFairness(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parseWindow(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := service.FairnessFilter{Team: q.Get("team_name"), From: from, To: to, ThresholdPercent: service.DefaultFairnessThreshold}
+	if f.Team == "" {
+		http.Error(w, "team_name required", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("threshold"); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || math.IsInf(p, 0) {
+			http.Error(w, "threshold must be a non-negative percentage", http.StatusBadRequest)
+			return
+		}
+		f.ThresholdPercent = p
+	}
+	if !f.From.IsZero() && f.To.IsZero() && !f.From.Before(time.Now()) {
+		http.Error(w, "from must be in the past", http.StatusBadRequest)
+		return
+	}
+	report, err := s.svc.Fairness(r.Context(), f)
+	if err != nil {
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "team not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
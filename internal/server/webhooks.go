@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/service"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) mountWebhooks(r chi.Router) {
+	r.Post("/webhooks/subscriptions", s.handleSubscriptionCreate)
+	r.Get("/webhooks/subscriptions", s.handleSubscriptionList)
+	r.Post("/webhooks/subscriptions/delete", s.handleSubscriptionDelete)
+	r.Get("/webhooks/deliveries", s.handleDeliveryList)
+	r.Post("/webhooks/deliveries/retry", s.handleDeliveryRetry)
+}
+
+func (s *Server) handleSubscriptionCreate(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"event_types"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(payload.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Secret) < 16 {
+		http.Error(w, "secret must be at least 16 characters", http.StatusBadRequest)
+		return
+	}
+	if len(payload.EventTypes) == 0 {
+		http.Error(w, "event_types required", http.StatusBadRequest)
+		return
+	}
+	for _, t := range payload.EventTypes {
+		if t != domain.EventAll && !slices.Contains(domain.EventTypes, t) {
+			http.Error(w, "unknown event type "+t+"; expected * or one of "+strings.Join(domain.EventTypes, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+	sub, err := s.svc.CreateSubscription(r.Context(), payload.URL, payload.Secret, payload.EventTypes)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusCreated, map[string]any{"subscription": sub})
+}
+
+func (s *Server) handleSubscriptionList(w http.ResponseWriter, r *http.Request) {
+	subs, err := s.svc.ListSubscriptions(r.Context())
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"subscriptions": subs})
+}
+
+func (s *Server) handleSubscriptionDelete(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID int64 `json:"subscription_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.DeleteSubscription(r.Context(), payload.ID); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "subscription not found")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"subscription_id": payload.ID, "deleted": true})
+}
+
+func (s *Server) handleDeliveryList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := service.DeliveryFilter{EventID: q.Get("event_id"), Limit: defaultListLimit}
+	if v := q.Get("subscription_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "subscription_id must be an integer", http.StatusBadRequest)
+			return
+		}
+		f.SubscriptionID = id
+	}
+	if st := domain.DeliveryStatus(q.Get("status")); st != "" {
+		if st != domain.DeliveryPending && st != domain.DeliveryDelivered && st != domain.DeliveryDead {
+			http.Error(w, "status must be pending, delivered or dead", http.StatusBadRequest)
+			return
+		}
+		f.Status = st
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be non-negative", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+	deliveries, err := s.svc.ListDeliveries(r.Context(), f)
+	if err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"deliveries": deliveries})
+}
+
+func (s *Server) handleDeliveryRetry(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ID int64 `json:"delivery_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.svc.RetryDelivery(r.Context(), payload.ID); err != nil {
+		if respondForbidden(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), string(domain.ErrNotFound)) {
+			respondError(w, http.StatusNotFound, domain.ErrNotFound, "delivery not found or already delivered")
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"delivery_id": payload.ID, "requeued": true})
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+type Option func(*Service)
+
+// WithAccessControl makes every mutating call check the role of the actor in
+// the context. Without it the service trusts its caller, which is how it runs
+// when authentication is disabled.
+func WithAccessControl() Option {
+	return func(s *Service) { s.enforce = true }
+}
+
+func forbidden() error { return errors.New(string(domain.ErrForbidden)) }
+
+// caller returns the actor to check. skip is true when access control is off.
+func (s *Service) caller(ctx context.Context) (a auth.Actor, skip bool, err error) {
+	if !s.enforce {
+		return auth.Actor{}, true, nil
+	}
+	a, ok := auth.ActorFrom(ctx)
+	if !ok {
+		return auth.Actor{}, false, forbidden()
+	}
+	return a, a.Role == domain.RoleAdmin, nil
+}
+
+func (s *Service) requireAdmin(ctx context.Context) error {
+	_, skip, err := s.caller(ctx)
+	if err != nil || skip {
+		return err
+	}
+	return forbidden()
+}
+
+// requireWriter rejects read-only callers.
+func (s *Service) requireWriter(ctx context.Context) error {
+	a, skip, err := s.caller(ctx)
+	if err != nil || skip {
+		return err
+	}
+	if a.Role == domain.RoleBot {
+		return forbidden()
+	}
+	return nil
+}
+
+// requireLead allows admins and team leads who lead every one of teams.
+func (s *Service) requireLead(ctx context.Context, teams ...string) error {
+	a, skip, err := s.caller(ctx)
+	if err != nil || skip {
+		return err
+	}
+	if a.Role != domain.RoleTeamLead {
+		return forbidden()
+	}
+	for _, t := range teams {
+		ok, err := s.leads(ctx, a.UserID, t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return forbidden()
+		}
+	}
+	return nil
+}
+
+// requireSelfOrLead allows admins, userID acting as themselves, and leads of
+// team.
+func (s *Service) requireSelfOrLead(ctx context.Context, userID, team string) error {
+	a, skip, err := s.caller(ctx)
+	if err != nil || skip {
+		return err
+	}
+	switch a.Role {
+	case domain.RoleMember:
+		if a.UserID == userID {
+			return nil
+		}
+	case domain.RoleTeamLead:
+		if a.UserID == userID {
+			return nil
+		}
+		ok, err := s.leads(ctx, a.UserID, team)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return forbidden()
+}
+
+// requireSelfOrPrimaryLead is requireSelfOrLead for per-user preferences:
+// leads of the user's primary team qualify, and a missing user is NOT_FOUND.
+func (s *Service) requireSelfOrPrimaryLead(ctx context.Context, userID string) error {
+	team, err := s.r.UserTeam(ctx, userID)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return err
+	}
+	if err := s.requireSelfOrLead(ctx, userID, team); err != nil {
+		return err
+	}
+	exists, err := s.r.UserExists(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New(string(domain.ErrNotFound))
+	}
+	return nil
+}
+
+// leads reports whether a team lead holds the lead role in team or one of its
+// ancestors. Plain memberships grant nothing.
+func (s *Service) leads(ctx context.Context, userID, team string) (bool, error) {
+	if userID == "" || team == "" {
+		return false, nil
+	}
+	chain, err := s.r.TeamAncestry(ctx, team)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	memberships, err := s.r.UserMemberships(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range chain {
+		for _, m := range memberships {
+			if m.Team == t.Team && m.Role == string(domain.MemberRoleLead) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("service")
+
+const (
+	auditOK    = "ok"
+	auditError = "error"
+	// auditAnonymous is recorded as the actor when authentication is disabled.
+	auditAnonymous = "anonymous"
+)
+
+// audit runs fn in a transaction and records the call in the audit log. A
+// successful change is logged in the same transaction, so one never commits
+// without the other; a failed attempt is logged on its own after the rollback.
+func (s *Service) audit(ctx context.Context, action, target string, input any, fn func(ts *Service) error) error {
+	entry := repo.AuditRow{Actor: actorName(ctx), Action: action, Target: target, PayloadDigest: payloadDigest(input)}
+	if entry.Actor == "" {
+		entry.Actor = auditAnonymous
+	}
+	var emitted []domain.Event
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		ts := s.withRepo(tx)
+		ts.emitted = &emitted
+		if err := fn(ts); err != nil {
+			return err
+		}
+		entry.Result = auditOK
+		return tx.InsertAudit(ctx, entry)
+	})
+	if err == nil {
+		logger.DebugContext(ctx, "change committed", "action", action, "target", target, "events", len(emitted))
+		if len(emitted) > 0 && s.notify != nil {
+			s.notify()
+		}
+		if len(emitted) > 0 && s.observer != nil {
+			s.observer.Committed(emitted)
+		}
+		return nil
+	}
+	entry.Result = auditError
+	if code, ok := apiErrorCode(err); ok {
+		entry.Result = string(code)
+		if s.observer != nil {
+			s.observer.Rejected(action, code)
+		}
+	}
+	logger.DebugContext(ctx, "change rejected", "action", action, "target", target, "result", entry.Result)
+	if aerr := s.r.InsertAudit(ctx, entry); aerr != nil {
+		logger.ErrorContext(ctx, "audit write failed", "action", action, "target", target, logging.Err(aerr))
+	}
+	return err
+}
+
+// idTarget formats a numeric id as an audit target.
+func idTarget(id int64) string { return strconv.FormatInt(id, 10) }
+
+// payloadDigest is the hex SHA-256 of the call's input as JSON.
+func payloadDigest(input any) string {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Result string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+func (s *Service) ListAudit(ctx context.Context, f AuditFilter) (_ []domain.AuditEntry, err error) {
+	ctx, span := startSpan(ctx, "ListAudit")
+	defer endSpan(span, &err)
+	out := []domain.AuditEntry{}
+	err = s.EachAudit(ctx, f, func(e domain.AuditEntry) error {
+		out = append(out, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EachAudit streams matching entries oldest first; a zero Limit means all.
+func (s *Service) EachAudit(ctx context.Context, f AuditFilter, fn func(domain.AuditEntry) error) (err error) {
+	ctx, span := startSpan(ctx, "EachAudit")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	rf := repo.AuditFilter{Actor: f.Actor, Action: f.Action, Target: f.Target, Result: f.Result, From: f.From, To: f.To, Limit: f.Limit, Offset: f.Offset}
+	return s.r.EachAudit(ctx, rf, func(row repo.AuditRow) error {
+		return fn(domain.AuditEntry{
+			ID:            row.ID,
+			OccurredAt:    row.OccurredAt.Time,
+			Actor:         row.Actor,
+			Action:        row.Action,
+			Target:        row.Target,
+			PayloadDigest: row.PayloadDigest,
+			Result:        row.Result,
+		})
+	})
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// MaxBatchSize caps the number of PRs accepted by CreatePRBatch.
+const MaxBatchSize = 1000
+
+type BatchPRInput struct {
+	ID       string
+	Name     string
+	AuthorID string
+	// TeamName is the target team; empty means the author's primary team.
+	TeamName string
+}
+
+// batchAssigner picks reviewers for a batch the way a single create would.
+// For least_loaded teams it starts from the open-review counts in the
+// database and adds the assignments made so far in the batch; other teams
+// get a random pick.
+type batchAssigner struct {
+	load     map[string]int
+	seeded   map[string]bool
+	members  map[string][]string
+	settings map[string]domain.EffectiveTeamSettings
+}
+
+func newBatchAssigner() *batchAssigner {
+	return &batchAssigner{load: map[string]int{}, seeded: map[string]bool{}, members: map[string][]string{}, settings: map[string]domain.EffectiveTeamSettings{}}
+}
+
+func (a *batchAssigner) teamMembers(ctx context.Context, r *repo.Repo, team string, strategy domain.AssignmentStrategy) ([]string, error) {
+	m, ok := a.members[team]
+	if !ok {
+		var err error
+		if m, err = r.TeamMembers(ctx, team, true); err != nil {
+			return nil, err
+		}
+		a.members[team] = m
+	}
+	if strategy != domain.StrategyLeastLoaded {
+		return m, nil
+	}
+	var unseeded []string
+	for _, id := range m {
+		if !a.seeded[id] {
+			unseeded = append(unseeded, id)
+		}
+	}
+	if len(unseeded) == 0 {
+		return m, nil
+	}
+	// The counts include PRs created earlier in the batch, as they share the
+	// transaction, so they replace whatever commit recorded.
+	counts, err := r.OpenReviewCounts(ctx, unseeded)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range unseeded {
+		a.load[id] = counts[id]
+		a.seeded[id] = true
+	}
+	return m, nil
+}
+
+func (a *batchAssigner) pick(members []string, author string, n int, strategy domain.AssignmentStrategy) []string {
+	cands := make([]string, 0, len(members))
+	for _, m := range members {
+		if m != author {
+			cands = append(cands, m)
+		}
+	}
+	rand.Shuffle(len(cands), func(i, j int) { cands[i], cands[j] = cands[j], cands[i] })
+	if strategy == domain.StrategyLeastLoaded {
+		sort.SliceStable(cands, func(i, j int) bool { return a.load[cands[i]] < a.load[cands[j]] })
+	}
+	if len(cands) > n {
+		cands = cands[:n]
+	}
+	return cands
+}
+
+func (a *batchAssigner) commit(ids []string) {
+	for _, id := range ids {
+		a.load[id]++
+	}
+}
+
+// CreatePRBatch creates all PRs in one transaction. Every item runs in its own
+// savepoint, so an item failing with an API error is rolled back and reported
+// in its result while the rest of the batch is still committed.
+// Items authored by someone the caller may not act for fail with FORBIDDEN.
+func (s *Service) CreatePRBatch(ctx context.Context, items []BatchPRInput) (out []domain.BatchPRResult, err error) {
+	ctx, span := startSpan(ctx, "CreatePRBatch")
+	defer endSpan(span, &err)
+	// The target only counts the items; their ids are in the payload digest.
+	err = s.audit(ctx, "pr.create_batch", strconv.Itoa(len(items))+" items", items, func(ts *Service) (err error) {
+		if out, err = ts.createPRBatch(ctx, items); err != nil {
+			return err
+		}
+		for _, res := range out {
+			if res.PR == nil {
+				continue
+			}
+			if err := ts.emit(ctx, domain.EventPRCreated, map[string]any{"pull_request": res.PR}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *Service) createPRBatch(ctx context.Context, items []BatchPRInput) ([]domain.BatchPRResult, error) {
+	if err := s.requireWriter(ctx); err != nil {
+		return nil, err
+	}
+	results := make([]domain.BatchPRResult, 0, len(items))
+	assigner := newBatchAssigner()
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		for _, it := range items {
+			var pr domain.PullRequest
+			var picked []string
+			err := tx.InTx(ctx, func(sp *repo.Repo) error {
+				var err error
+				pr, picked, err = s.withRepo(sp).createBatchItem(ctx, assigner, it)
+				return err
+			})
+			if err != nil {
+				code, ok := apiErrorCode(err)
+				if !ok {
+					return err
+				}
+				results = append(results, domain.BatchPRResult{ID: it.ID, Error: &domain.APIErrorBody{Code: code, Message: batchErrorMessage(code)}})
+				continue
+			}
+			assigner.commit(picked)
+			results = append(results, domain.BatchPRResult{ID: it.ID, PR: &pr})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Service) createBatchItem(ctx context.Context, a *batchAssigner, it BatchPRInput) (domain.PullRequest, []string, error) {
+	team, teamErr := s.targetTeam(ctx, it.AuthorID, it.TeamName)
+	if err := s.requireSelfOrLead(ctx, it.AuthorID, team); err != nil {
+		return domain.PullRequest{}, nil, err
+	}
+	if teamErr != nil {
+		return domain.PullRequest{}, nil, teamErr
+	}
+	exists, err := s.r.PRExists(ctx, it.ID)
+	if err != nil {
+		return domain.PullRequest{}, nil, err
+	}
+	if exists {
+		return domain.PullRequest{}, nil, errors.New(string(domain.ErrPRExists))
+	}
+	settings, ok := a.settings[team]
+	if !ok {
+		if settings, err = s.effectiveSettings(ctx, team); err != nil {
+			return domain.PullRequest{}, nil, err
+		}
+		a.settings[team] = settings
+	}
+	members, err := a.teamMembers(ctx, s.r, team, settings.Strategy)
+	if err != nil {
+		return domain.PullRequest{}, nil, err
+	}
+	if err := s.r.CreatePR(ctx, it.ID, it.Name, it.AuthorID, team, settings.ReviewerCount, false); err != nil {
+		return domain.PullRequest{}, nil, err
+	}
+	picked := a.pick(members, it.AuthorID, settings.ReviewerCount, settings.Strategy)
+	if err := s.r.AddReviewers(ctx, it.ID, picked); err != nil {
+		return domain.PullRequest{}, nil, err
+	}
+	pr, err := s.GetPR(ctx, it.ID)
+	return pr, picked, err
+}
+
+// apiErrorCode reports whether err carries one of the domain API error codes.
+func apiErrorCode(err error) (domain.APIErrorCode, bool) {
+	switch code := domain.APIErrorCode(err.Error()); code {
+	case domain.ErrTeamExists, domain.ErrPRExists, domain.ErrPRMerged, domain.ErrPRClosed, domain.ErrNotAssigned, domain.ErrNoCandidate, domain.ErrNotFound,
+		domain.ErrTeamNotEmpty, domain.ErrTeamHasChildren, domain.ErrTeamCycle, domain.ErrMergeBlocked, domain.ErrForbidden:
+		return code, true
+	}
+	return "", false
+}
+
+func batchErrorMessage(code domain.APIErrorCode) string {
+	switch code {
+	case domain.ErrPRExists:
+		return "PR id already exists"
+	case domain.ErrNotFound:
+		return "author or team not found"
+	case domain.ErrForbidden:
+		return "not allowed to create PRs for this author"
+	}
+	return string(code)
+}
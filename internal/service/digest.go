@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// SetDigestPreferences replaces the user's email digest schedule.
+func (s *Service) SetDigestPreferences(ctx context.Context, p domain.DigestPreferences) (out domain.DigestPreferences, err error) {
+	ctx, span := startSpan(ctx, "SetDigestPreferences")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "user.set_digest", p.UserID, p, func(ts *Service) error {
+		if err := ts.requireSelfOrPrimaryLead(ctx, p.UserID); err != nil {
+			return err
+		}
+		if err := ts.r.SetDigestPref(ctx, repo.DigestPrefRow{
+			UserID:   p.UserID,
+			Email:    p.Email,
+			Schedule: string(p.Schedule),
+			Hour:     p.Hour,
+			Weekday:  p.Weekday,
+			Timezone: p.Timezone,
+		}); err != nil {
+			return err
+		}
+		out, err = ts.DigestPreferences(ctx, p.UserID)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) DeleteDigestPreferences(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteDigestPreferences")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "user.delete_digest", userID, map[string]any{"user_id": userID}, func(ts *Service) error {
+		if err := ts.requireSelfOrPrimaryLead(ctx, userID); err != nil {
+			return err
+		}
+		if err := ts.r.DeleteDigestPref(ctx, userID); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Service) DigestPreferences(ctx context.Context, userID string) (_ domain.DigestPreferences, err error) {
+	ctx, span := startSpan(ctx, "DigestPreferences")
+	defer endSpan(span, &err)
+	row, err := s.r.DigestPref(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return domain.DigestPreferences{}, errors.New(string(domain.ErrNotFound))
+		}
+		return domain.DigestPreferences{}, err
+	}
+	return domain.DigestPreferences{
+		UserID:     row.UserID,
+		Email:      row.Email,
+		Schedule:   domain.DigestSchedule(row.Schedule),
+		Hour:       row.Hour,
+		Weekday:    row.Weekday,
+		Timezone:   row.Timezone,
+		LastSentAt: row.LastSentAt,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+)
+
+// WithEventNotifier registers a callback run after a transaction that wrote
+// events to the outbox has committed, typically outbox.Relay.Notify.
+func WithEventNotifier(notify func()) Option {
+	return func(s *Service) { s.notify = notify }
+}
+
+// Observer learns the outcome of audited calls once it is final, typically to
+// keep metrics.
+type Observer interface {
+	// Committed receives the events a call emitted, after they committed.
+	Committed(events []domain.Event)
+	// Rejected receives the API error code a call failed with.
+	Rejected(action string, code domain.APIErrorCode)
+}
+
+func WithObserver(o Observer) Option {
+	return func(s *Service) { s.observer = o }
+}
+
+// emit writes an event to the outbox. It must run on the transaction of the
+// change it describes, so the event is stored if and only if the change is.
+func (s *Service) emit(ctx context.Context, eventType string, data any) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	ev := domain.Event{ID: hex.EncodeToString(b), Type: eventType, OccurredAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if err := s.r.InsertOutbox(ctx, ev.ID, ev.Type, string(payload)); err != nil {
+		return err
+	}
+	if s.emitted != nil {
+		*s.emitted = append(*s.emitted, ev)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/domain"
+)
+
+const (
+	// DefaultFairnessWindow is how far back a fairness report looks when
+	// no start is given.
+	DefaultFairnessWindow = 30 * 24 * time.Hour
+	// DefaultFairnessThreshold is how far, in percent, a member's load may
+	// exceed the team mean before they are flagged.
+	DefaultFairnessThreshold = 20.0
+)
+
+type FairnessFilter struct {
+	Team string
+	From time.Time
+	To   time.Time
+	// ThresholdPercent flags members whose load ratio exceeds
+	// 1 + ThresholdPercent/100.
+	ThresholdPercent float64
+}
+
+// Fairness reports how the team's assignments started in [From, To) were
+// spread over its members relative to the time each was active in the team.
+// To defaults to now and From to DefaultFairnessWindow before it.
+func (s *Service) Fairness(ctx context.Context, f FairnessFilter) (_ domain.FairnessReport, err error) {
+	ctx, span := startSpan(ctx, "Fairness")
+	defer endSpan(span, &err)
+	exists, err := s.r.TeamExists(ctx, f.Team)
+	if err != nil {
+		return domain.FairnessReport{}, err
+	}
+	if !exists {
+		return domain.FairnessReport{}, errors.New(string(domain.ErrNotFound))
+	}
+	if f.To.IsZero() {
+		f.To = time.Now().UTC()
+	}
+	if f.From.IsZero() {
+		f.From = f.To.Add(-DefaultFairnessWindow)
+	}
+	rows, err := s.r.TeamFairness(ctx, f.Team, f.From, f.To)
+	if err != nil {
+		return domain.FairnessReport{}, err
+	}
+	out := domain.FairnessReport{TeamName: f.Team, From: f.From, To: f.To, ThresholdPercent: f.ThresholdPercent, Members: make([]domain.MemberFairness, 0, len(rows))}
+	var days float64
+	for _, r := range rows {
+		out.Assignments += r.Assignments
+		days += r.ActiveDays
+	}
+	var rates []float64
+	for _, r := range rows {
+		m := domain.MemberFairness{UserID: r.UserID, Assignments: r.Assignments, ActiveDays: r.ActiveDays}
+		if out.Assignments > 0 {
+			m.AssignmentShare = float64(r.Assignments) / float64(out.Assignments)
+		}
+		if days > 0 {
+			m.ActiveShare = r.ActiveDays / days
+		}
+		if r.ActiveDays > 0 {
+			rates = append(rates, float64(r.Assignments)/r.ActiveDays)
+			if out.Assignments > 0 {
+				ratio := m.AssignmentShare / m.ActiveShare
+				m.LoadRatio = &ratio
+				m.Overloaded = ratio > 1+f.ThresholdPercent/100
+			}
+		}
+		out.Members = append(out.Members, m)
+	}
+	out.Gini = gini(rates)
+	return out, nil
+}
+
+// gini is the Gini coefficient of xs: the mean absolute difference between
+// all pairs over twice the mean. It is 0 for no values or all zeros.
+func gini(xs []float64) float64 {
+	var sum, diff float64
+	for _, x := range xs {
+		sum += x
+		for _, y := range xs {
+			diff += math.Abs(x - y)
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return diff / (2 * float64(len(xs)) * sum)
+}
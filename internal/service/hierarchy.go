@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// DefaultTeamSettings apply when neither a team nor any of its ancestors
+// override a setting, unless WithTeamDefaults replaces them.
+func DefaultTeamSettings() domain.EffectiveTeamSettings {
+	return domain.EffectiveTeamSettings{ReviewerCount: 2, Strategy: domain.StrategyRandom, MergePolicy: domain.MergeAny}
+}
+
+// WithTeamDefaults sets the settings of teams that neither set them nor
+// inherit them from an ancestor.
+func WithTeamDefaults(d domain.EffectiveTeamSettings) Option {
+	return func(s *Service) { s.defaults = d }
+}
+
+// effectiveSettings resolves team settings by walking up the tree: the
+// nearest team that sets a value wins, otherwise the default applies.
+func (s *Service) effectiveSettings(ctx context.Context, team string) (domain.EffectiveTeamSettings, error) {
+	eff := s.defaults
+	if team == "" {
+		return eff, nil
+	}
+	chain, err := s.r.TeamAncestry(ctx, team)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return domain.EffectiveTeamSettings{}, errors.New(string(domain.ErrNotFound))
+		}
+		return domain.EffectiveTeamSettings{}, err
+	}
+	var countSet, strategySet, policySet bool
+	for _, t := range chain {
+		if !countSet && t.ReviewerCount != nil {
+			eff.ReviewerCount, countSet = *t.ReviewerCount, true
+		}
+		if !strategySet && t.Strategy != nil {
+			eff.Strategy, strategySet = domain.AssignmentStrategy(*t.Strategy), true
+		}
+		if !policySet && t.MergePolicy != nil {
+			eff.MergePolicy, policySet = domain.MergePolicy(*t.MergePolicy), true
+		}
+	}
+	return eff, nil
+}
+
+// TeamSettings returns the team's parent, its own overrides and the settings
+// in effect after inheritance.
+func (s *Service) TeamSettings(ctx context.Context, team string) (_ string, _ domain.TeamSettings, _ domain.EffectiveTeamSettings, err error) {
+	ctx, span := startSpan(ctx, "TeamSettings")
+	defer endSpan(span, &err)
+	chain, err := s.r.TeamAncestry(ctx, team)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return "", domain.TeamSettings{}, domain.EffectiveTeamSettings{}, errors.New(string(domain.ErrNotFound))
+		}
+		return "", domain.TeamSettings{}, domain.EffectiveTeamSettings{}, err
+	}
+	own := chain[0]
+	settings := domain.TeamSettings{ReviewerCount: own.ReviewerCount}
+	if own.Strategy != nil {
+		v := domain.AssignmentStrategy(*own.Strategy)
+		settings.Strategy = &v
+	}
+	if own.MergePolicy != nil {
+		v := domain.MergePolicy(*own.MergePolicy)
+		settings.MergePolicy = &v
+	}
+	eff, err := s.effectiveSettings(ctx, team)
+	if err != nil {
+		return "", domain.TeamSettings{}, domain.EffectiveTeamSettings{}, err
+	}
+	return own.Parent, settings, eff, nil
+}
+
+// SetTeamSettings replaces the team's overrides; nil fields fall back to
+// inheritance.
+func (s *Service) SetTeamSettings(ctx context.Context, team string, settings domain.TeamSettings) (err error) {
+	ctx, span := startSpan(ctx, "SetTeamSettings")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "team.set_settings", team, settings, func(ts *Service) error {
+		return ts.setTeamSettings(ctx, team, settings)
+	})
+}
+
+func (s *Service) setTeamSettings(ctx context.Context, team string, settings domain.TeamSettings) error {
+	if err := s.requireLead(ctx, team); err != nil {
+		return err
+	}
+	row := repo.TeamSettingsRow{Team: team, ReviewerCount: settings.ReviewerCount}
+	if settings.Strategy != nil {
+		v := string(*settings.Strategy)
+		row.Strategy = &v
+	}
+	if settings.MergePolicy != nil {
+		v := string(*settings.MergePolicy)
+		row.MergePolicy = &v
+	}
+	if err := s.r.SetTeamSettings(ctx, row); err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return errors.New(string(domain.ErrNotFound))
+		}
+		return err
+	}
+	return nil
+}
+
+// SetTeamParent moves team under parent, or to the top level when parent is
+// empty. Moving a team under itself or one of its descendants is rejected.
+// Team leads must lead both the team and the new parent; only admins move
+// teams to the top level.
+func (s *Service) SetTeamParent(ctx context.Context, team, parent string) (err error) {
+	ctx, span := startSpan(ctx, "SetTeamParent")
+	defer endSpan(span, &err)
+	in := map[string]any{"team_name": team, "parent_team_name": parent}
+	return s.audit(ctx, "team.set_parent", team, in, func(ts *Service) error {
+		return ts.setTeamParent(ctx, team, parent)
+	})
+}
+
+func (s *Service) setTeamParent(ctx context.Context, team, parent string) error {
+	err := s.requireAdmin(ctx)
+	if parent != "" {
+		err = s.requireLead(ctx, team, parent)
+	}
+	if err != nil {
+		return err
+	}
+	return s.r.InTx(ctx, func(tx *repo.Repo) error {
+		if err := tx.LockTeamHierarchy(ctx); err != nil {
+			return err
+		}
+		if parent != "" {
+			chain, err := tx.TeamAncestry(ctx, parent)
+			if err != nil {
+				if errors.Is(err, repo.ErrNotFound) {
+					return errors.New(string(domain.ErrNotFound))
+				}
+				return err
+			}
+			for _, t := range chain {
+				if t.Team == team {
+					return errors.New(string(domain.ErrTeamCycle))
+				}
+			}
+		}
+		if err := tx.SetTeamParent(ctx, team, parent); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// teamChildren maps every team to its direct sub-teams.
+func (s *Service) teamChildren(ctx context.Context) (map[string][]string, map[string]string, error) {
+	teams, err := s.r.ListTeams(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	children := make(map[string][]string, len(teams))
+	parents := make(map[string]string, len(teams))
+	for _, t := range teams {
+		parents[t.Name] = t.Parent
+		if t.Parent != "" {
+			children[t.Parent] = append(children[t.Parent], t.Name)
+		}
+	}
+	return children, parents, nil
+}
+
+// TeamTree returns the subtree rooted at root, or the whole forest when root
+// is empty.
+func (s *Service) TeamTree(ctx context.Context, root string) (_ []domain.TeamNode, err error) {
+	ctx, span := startSpan(ctx, "TeamTree")
+	defer endSpan(span, &err)
+	children, parents, err := s.teamChildren(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// seen stops the walk at a team already placed, should the stored
+	// parents ever form a cycle.
+	seen := map[string]bool{}
+	var build func(name string) domain.TeamNode
+	build = func(name string) domain.TeamNode {
+		seen[name] = true
+		node := domain.TeamNode{TeamName: name, Parent: parents[name], Children: []domain.TeamNode{}}
+		for _, c := range children[name] {
+			if !seen[c] {
+				node.Children = append(node.Children, build(c))
+			}
+		}
+		return node
+	}
+	if root != "" {
+		if _, ok := parents[root]; !ok {
+			return nil, errors.New(string(domain.ErrNotFound))
+		}
+		return []domain.TeamNode{build(root)}, nil
+	}
+	out := []domain.TeamNode{}
+	for name, parent := range parents {
+		if parent == "" {
+			out = append(out, build(name))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TeamName < out[j].TeamName })
+	return out, nil
+}
+
+// subtree lists team followed by all of its descendants, each once even if
+// the stored parents form a cycle.
+func subtree(children map[string][]string, team string) []string {
+	out := []string{team}
+	seen := map[string]bool{team: true}
+	for i := 0; i < len(out); i++ {
+		for _, c := range children[out[i]] {
+			if !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// TeamStats reports PR and assignment counts per team, both for the team
+// alone and aggregated over its subtree. An empty root reports every team.
+func (s *Service) TeamStats(ctx context.Context, root string) (_ []domain.TeamStats, err error) {
+	ctx, span := startSpan(ctx, "TeamStats")
+	defer endSpan(span, &err)
+	children, parents, err := s.teamChildren(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.r.TeamStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	own := make(map[string]domain.TeamStatsPart, len(rows))
+	for _, r := range rows {
+		own[r.Team] = domain.TeamStatsPart{OpenPRs: r.OpenPRs, MergedPRs: r.MergedPRs, Assignments: r.Assignments}
+	}
+	totals := map[string]domain.TeamStatsPart{}
+	var total func(name string) domain.TeamStatsPart
+	total = func(name string) domain.TeamStatsPart {
+		if t, ok := totals[name]; ok {
+			return t
+		}
+		// Seed the entry first, so a cycle in the stored parents ends here.
+		totals[name] = own[name]
+		t := own[name]
+		for _, c := range children[name] {
+			ct := total(c)
+			t.OpenPRs += ct.OpenPRs
+			t.MergedPRs += ct.MergedPRs
+			t.Assignments += ct.Assignments
+		}
+		totals[name] = t
+		return t
+	}
+
+	names := make([]string, 0, len(rows))
+	if root != "" {
+		if _, ok := parents[root]; !ok {
+			return nil, errors.New(string(domain.ErrNotFound))
+		}
+		names = subtree(children, root)
+	} else {
+		for _, r := range rows {
+			names = append(names, r.Team)
+		}
+	}
+	out := make([]domain.TeamStats, 0, len(names))
+	for _, name := range names {
+		out = append(out, domain.TeamStats{TeamName: name, Own: own[name], Total: total(name)})
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// SetNotifyChannel routes chat notifications about the team's PRs, and those
+// of sub-teams without a channel of their own, to channel. An empty
+// webhookURL posts through the default incoming webhook.
+func (s *Service) SetNotifyChannel(ctx context.Context, team, channel, webhookURL string) (out domain.NotifyChannel, err error) {
+	ctx, span := startSpan(ctx, "SetNotifyChannel")
+	defer endSpan(span, &err)
+	in := map[string]any{"team_name": team, "channel": channel, "custom_webhook": webhookURL != ""}
+	err = s.audit(ctx, "team.set_notifications", team, in, func(ts *Service) error {
+		if err := ts.requireLead(ctx, team); err != nil {
+			return err
+		}
+		exists, err := ts.r.TeamExists(ctx, team)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New(string(domain.ErrNotFound))
+		}
+		row, err := ts.r.SetNotifyChannel(ctx, repo.NotifyChannelRow{Team: team, Channel: channel, WebhookURL: webhookURL, UpdatedBy: actorName(ctx)})
+		out = notifyChannelFromRow(row)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) DeleteNotifyChannel(ctx context.Context, team string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNotifyChannel")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "team.delete_notifications", team, map[string]any{"team_name": team}, func(ts *Service) error {
+		if err := ts.requireLead(ctx, team); err != nil {
+			return err
+		}
+		if err := ts.r.DeleteNotifyChannel(ctx, team); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// NotifyChannel returns the team's own channel and the one in effect after
+// inheritance; either is nil when not configured.
+func (s *Service) NotifyChannel(ctx context.Context, team string) (own, effective *domain.NotifyChannel, err error) {
+	ctx, span := startSpan(ctx, "NotifyChannel")
+	defer endSpan(span, &err)
+	exists, err := s.r.TeamExists(ctx, team)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, errors.New(string(domain.ErrNotFound))
+	}
+	row, err := s.r.NotifyChannel(ctx, team)
+	if err == nil {
+		c := notifyChannelFromRow(row)
+		own = &c
+	} else if !errors.Is(err, repo.ErrNotFound) {
+		return nil, nil, err
+	}
+	row, err = s.r.EffectiveNotifyChannel(ctx, team)
+	if err == nil {
+		c := notifyChannelFromRow(row)
+		effective = &c
+	} else if !errors.Is(err, repo.ErrNotFound) {
+		return nil, nil, err
+	}
+	return own, effective, nil
+}
+
+// SetNotifyUser maps userID to a chat handle used for mentions; with dm set
+// the user also gets direct messages about their reviews.
+func (s *Service) SetNotifyUser(ctx context.Context, userID, handle string, dm bool) (out domain.NotifyUser, err error) {
+	ctx, span := startSpan(ctx, "SetNotifyUser")
+	defer endSpan(span, &err)
+	in := map[string]any{"user_id": userID, "handle": handle, "dm": dm}
+	err = s.audit(ctx, "user.set_notifications", userID, in, func(ts *Service) error {
+		if err := ts.requireSelfOrPrimaryLead(ctx, userID); err != nil {
+			return err
+		}
+		row, err := ts.r.SetNotifyUser(ctx, repo.NotifyUserRow{UserID: userID, Handle: handle, DM: dm})
+		out = notifyUserFromRow(row)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) DeleteNotifyUser(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNotifyUser")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "user.delete_notifications", userID, map[string]any{"user_id": userID}, func(ts *Service) error {
+		if err := ts.requireSelfOrPrimaryLead(ctx, userID); err != nil {
+			return err
+		}
+		if err := ts.r.DeleteNotifyUser(ctx, userID); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Service) NotifyUser(ctx context.Context, userID string) (_ domain.NotifyUser, err error) {
+	ctx, span := startSpan(ctx, "NotifyUser")
+	defer endSpan(span, &err)
+	row, err := s.r.NotifyUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return domain.NotifyUser{}, errors.New(string(domain.ErrNotFound))
+		}
+		return domain.NotifyUser{}, err
+	}
+	return notifyUserFromRow(row), nil
+}
+
+func notifyChannelFromRow(row repo.NotifyChannelRow) domain.NotifyChannel {
+	return domain.NotifyChannel{
+		TeamName:      row.Team,
+		Channel:       row.Channel,
+		WebhookURL:    row.WebhookURL,
+		CustomWebhook: row.WebhookURL != "",
+		UpdatedBy:     row.UpdatedBy,
+		UpdatedAt:     row.UpdatedAt.Time,
+	}
+}
+
+func notifyUserFromRow(row repo.NotifyUserRow) domain.NotifyUser {
+	return domain.NotifyUser{UserID: row.UserID, Handle: row.Handle, DM: row.DM, UpdatedAt: row.UpdatedAt.Time}
+}
@@ -3,16 +3,64 @@ package service
 import (
 	"context"
 	"errors"
+	"slices"
+	"time"
 
+	"github.com/example/avito-pr-service/internal/auth"
 	"github.com/example/avito-pr-service/internal/domain"
 	"github.com/example/avito-pr-service/internal/repo"
 )
 
-type Service struct{ r *repo.Repo }
+type Service struct {
+	r        *repo.Repo
+	enforce  bool
+	notify   func()
+	observer Observer
+	// defaults are the team settings in effect where no team sets them.
+	defaults domain.EffectiveTeamSettings
+	// emitted collects the events emit writes inside an audited transaction.
+	emitted *[]domain.Event
+}
 
-func New(r *repo.Repo) *Service { return &Service{r: r} }
+func New(r *repo.Repo, opts ...Option) *Service {
+	s := &Service{r: r, defaults: DefaultTeamSettings()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
 
-func (s *Service) CreateTeam(ctx context.Context, team domain.Team) (domain.Team, error) {
+// actorName names the authenticated caller for attribution, or returns ""
+// when the request was not authenticated.
+func actorName(ctx context.Context) string {
+	if a, ok := auth.ActorFrom(ctx); ok {
+		return a.Name()
+	}
+	return ""
+}
+
+// withRepo returns a copy of the service that talks to r, typically a
+// transaction-bound Repo obtained from Repo.InTx.
+func (s *Service) withRepo(r *repo.Repo) *Service {
+	c := *s
+	c.r = r
+	return &c
+}
+
+func (s *Service) CreateTeam(ctx context.Context, team domain.Team) (out domain.Team, err error) {
+	ctx, span := startSpan(ctx, "CreateTeam")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "team.create", team.TeamName, team, func(ts *Service) (err error) {
+		out, err = ts.createTeam(ctx, team)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) createTeam(ctx context.Context, team domain.Team) (domain.Team, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return domain.Team{}, err
+	}
 	exists, err := s.r.TeamExists(ctx, team.TeamName)
 	if err != nil {
 		return domain.Team{}, err
@@ -24,7 +72,7 @@ func (s *Service) CreateTeam(ctx context.Context, team domain.Team) (domain.Team
 		return domain.Team{}, err
 	}
 	for _, m := range team.Members {
-		if err := s.r.UpsertUser(ctx, m.UserID, m.Username, team.TeamName, m.IsActive); err != nil {
+		if err := s.r.UpsertUser(ctx, m.UserID, m.Username, team.TeamName, m.IsActive, string(m.Role)); err != nil {
 			return domain.Team{}, err
 		}
 	}
@@ -34,12 +82,14 @@ func (s *Service) CreateTeam(ctx context.Context, team domain.Team) (domain.Team
 	}
 	members := make([]domain.TeamMember, 0, len(rows))
 	for _, row := range rows {
-		members = append(members, domain.TeamMember{UserID: row.UserID, Username: row.Username, IsActive: row.IsActive})
+		members = append(members, domain.TeamMember{UserID: row.UserID, Username: row.Username, IsActive: row.IsActive, Role: domain.MemberRole(row.Role)})
 	}
 	return domain.Team{TeamName: team.TeamName, Members: members}, nil
 }
 
-func (s *Service) GetTeam(ctx context.Context, name string) (domain.Team, error) {
+func (s *Service) GetTeam(ctx context.Context, name string) (_ domain.Team, err error) {
+	ctx, span := startSpan(ctx, "GetTeam")
+	defer endSpan(span, &err)
 	rows, err := s.r.GetTeam(ctx, name)
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
@@ -49,23 +99,95 @@ func (s *Service) GetTeam(ctx context.Context, name string) (domain.Team, error)
 	}
 	members := make([]domain.TeamMember, 0, len(rows))
 	for _, row := range rows {
-		members = append(members, domain.TeamMember{UserID: row.UserID, Username: row.Username, IsActive: row.IsActive})
+		members = append(members, domain.TeamMember{UserID: row.UserID, Username: row.Username, IsActive: row.IsActive, Role: domain.MemberRole(row.Role)})
 	}
 	return domain.Team{TeamName: name, Members: members}, nil
 }
 
-func (s *Service) SetUserActive(ctx context.Context, userID string, active bool) (domain.User, error) {
-	username, team, isActive, err := s.r.SetUserActive(ctx, userID, active)
+// SetUserActive toggles the user globally, or only the membership in team
+// when team is not empty.
+func (s *Service) SetUserActive(ctx context.Context, userID, team string, active bool) (out domain.User, err error) {
+	ctx, span := startSpan(ctx, "SetUserActive")
+	defer endSpan(span, &err)
+	in := map[string]any{"user_id": userID, "team_name": team, "is_active": active}
+	err = s.audit(ctx, "user.set_active", userID, in, func(ts *Service) (err error) {
+		if out, err = ts.setUserActive(ctx, userID, team, active); err != nil {
+			return err
+		}
+		return ts.emit(ctx, domain.EventUserActiveChanged, map[string]any{"user": out, "team_name": team, "is_active": active})
+	})
+	return out, err
+}
+
+func (s *Service) setUserActive(ctx context.Context, userID, team string, active bool) (domain.User, error) {
+	err := s.requireAdmin(ctx)
+	if team != "" {
+		err = s.requireLead(ctx, team)
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	if team == "" {
+		_, _, _, err = s.r.SetUserActive(ctx, userID, active)
+	} else {
+		err = s.r.SetMembershipActive(ctx, team, userID, active)
+	}
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
 			return domain.User{}, errors.New(string(domain.ErrNotFound))
 		}
 		return domain.User{}, err
 	}
-	return domain.User{UserID: userID, Username: username, TeamName: team, IsActive: isActive}, nil
+	return s.GetUser(ctx, userID)
 }
 
-func (s *Service) CreatePR(ctx context.Context, id, name, author string) (domain.PullRequest, error) {
+func (s *Service) GetUser(ctx context.Context, userID string) (_ domain.User, err error) {
+	ctx, span := startSpan(ctx, "GetUser")
+	defer endSpan(span, &err)
+	username, team, active, err := s.r.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return domain.User{}, errors.New(string(domain.ErrNotFound))
+		}
+		return domain.User{}, err
+	}
+	rows, err := s.r.UserMemberships(ctx, userID)
+	if err != nil {
+		return domain.User{}, err
+	}
+	teams := make([]domain.Membership, 0, len(rows))
+	for _, m := range rows {
+		teams = append(teams, domain.Membership{TeamName: m.Team, IsActive: m.IsActive, Role: domain.MemberRole(m.Role)})
+	}
+	return domain.User{UserID: userID, Username: username, TeamName: team, IsActive: active, Teams: teams}, nil
+}
+
+// CreatePR opens a PR targeting team, or the author's primary team when team
+// is empty. Reviewers are drawn from the target team according to its
+// effective settings; drafts get them once marked ready (see SetPRDraft).
+func (s *Service) CreatePR(ctx context.Context, id, name, author, team string, draft bool) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "CreatePR")
+	defer endSpan(span, &err)
+	in := map[string]any{"pull_request_id": id, "pull_request_name": name, "author_id": author, "team_name": team, "is_draft": draft}
+	err = s.audit(ctx, "pr.create", id, in, func(ts *Service) (err error) {
+		if out, err = ts.createPR(ctx, id, name, author, team, draft); err != nil {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRCreated, map[string]any{"pull_request": out})
+	})
+	return out, err
+}
+
+func (s *Service) createPR(ctx context.Context, id, name, author, team string, draft bool) (domain.PullRequest, error) {
+	// Leads of the PR's team may create it on a member's behalf; a caller
+	// who may not is refused before learning whether the author exists.
+	team, teamErr := s.targetTeam(ctx, author, team)
+	if err := s.requireSelfOrLead(ctx, author, team); err != nil {
+		return domain.PullRequest{}, err
+	}
+	if teamErr != nil {
+		return domain.PullRequest{}, teamErr
+	}
 	exists, err := s.r.PRExists(ctx, id)
 	if err != nil {
 		return domain.PullRequest{}, err
@@ -73,56 +195,330 @@ func (s *Service) CreatePR(ctx context.Context, id, name, author string) (domain
 	if exists {
 		return domain.PullRequest{}, errors.New(string(domain.ErrPRExists))
 	}
-	team, err := s.r.UserTeam(ctx, author)
+	settings, err := s.effectiveSettings(ctx, team)
 	if err != nil {
-		if errors.Is(err, repo.ErrNotFound) {
-			return domain.PullRequest{}, errors.New(string(domain.ErrNotFound))
-		}
 		return domain.PullRequest{}, err
 	}
-	if err := s.r.CreatePR(ctx, id, name, author); err != nil {
+	if err := s.r.CreatePR(ctx, id, name, author, team, settings.ReviewerCount, draft); err != nil {
 		return domain.PullRequest{}, err
 	}
-	_, err = s.r.AssignReviewersRandom(ctx, id, team, author, "", 2)
-	if err != nil {
-		return domain.PullRequest{}, err
+	if !draft {
+		_, err = s.r.AssignReviewers(ctx, id, team, author, settings.ReviewerCount, string(settings.Strategy))
+		if err != nil {
+			return domain.PullRequest{}, err
+		}
 	}
 	return s.GetPR(ctx, id)
 }
 
-func (s *Service) GetPR(ctx context.Context, id string) (domain.PullRequest, error) {
-	name, author, status, createdAt, mergedAt, reviewers, err := s.r.GetPR(ctx, id)
+// SetPRDraft converts an open PR to a draft or marks it ready for review.
+// Marking it ready tops the reviewers up to the required count; converting to
+// a draft keeps the reviewers already assigned. Repeating the current state is
+// a no-op.
+func (s *Service) SetPRDraft(ctx context.Context, id string, draft bool) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "SetPRDraft")
+	defer endSpan(span, &err)
+	in := map[string]any{"pull_request_id": id, "is_draft": draft}
+	err = s.audit(ctx, "pr.set_draft", id, in, func(ts *Service) (err error) {
+		var changed bool
+		if out, changed, err = ts.setPRDraft(ctx, id, draft); err != nil || !changed {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRDraftChanged, map[string]any{"pull_request": out, "is_draft": draft})
+	})
+	return out, err
+}
+
+func (s *Service) setPRDraft(ctx context.Context, id string, draft bool) (domain.PullRequest, bool, error) {
+	pr, err := s.GetPR(ctx, id)
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if err := s.requireSelfOrLead(ctx, pr.AuthorID, pr.TeamName); err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if pr.Status != domain.PROpen {
+		return domain.PullRequest{}, false, errNotOpen(pr.Status)
+	}
+	if pr.IsDraft == draft {
+		return pr, false, nil
+	}
+	if err := s.r.SetPRDraft(ctx, id, draft); err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if missing := pr.RequiredReviewers - len(pr.Reviewers); !draft && missing > 0 && pr.TeamName != "" {
+		settings, err := s.effectiveSettings(ctx, pr.TeamName)
+		if err != nil {
+			return domain.PullRequest{}, false, err
+		}
+		for range missing {
+			uid, err := s.r.ReplacementCandidate(ctx, pr.TeamName, pr.AuthorID, pr.Reviewers, string(settings.Strategy))
+			if errors.Is(err, repo.ErrNotFound) {
+				break
+			}
+			if err != nil {
+				return domain.PullRequest{}, false, err
+			}
+			if err := s.r.AddReviewers(ctx, id, []string{uid}); err != nil {
+				return domain.PullRequest{}, false, err
+			}
+			pr.Reviewers = append(pr.Reviewers, uid)
+		}
+	}
+	updated, err := s.GetPR(ctx, id)
+	return updated, true, err
+}
+
+// targetTeam resolves the team a new PR of author goes to.
+func (s *Service) targetTeam(ctx context.Context, author, team string) (string, error) {
+	if team == "" {
+		primary, err := s.r.UserTeam(ctx, author)
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return "", errors.New(string(domain.ErrNotFound))
+			}
+			return "", err
+		}
+		return primary, nil
+	}
+	userExists, err := s.r.UserExists(ctx, author)
+	if err != nil {
+		return "", err
+	}
+	teamExists, err := s.r.TeamExists(ctx, team)
+	if err != nil {
+		return "", err
+	}
+	if !userExists || !teamExists {
+		return "", errors.New(string(domain.ErrNotFound))
+	}
+	return team, nil
+}
+
+func (s *Service) GetPR(ctx context.Context, id string) (_ domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "GetPR")
+	defer endSpan(span, &err)
+	row, err := s.r.GetPR(ctx, id)
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
 			return domain.PullRequest{}, errors.New(string(domain.ErrNotFound))
 		}
 		return domain.PullRequest{}, err
 	}
-	pr := domain.PullRequest{ID: id, Name: name, AuthorID: author, Status: domain.PRStatus(status), Reviewers: reviewers}
-	if createdAt.Valid {
-		pr.CreatedAt = createdAt.Time
+	return prFromRow(row), nil
+}
+
+type PRFilter struct {
+	TeamName   string
+	AuthorID   string
+	ReviewerID string
+	Status     domain.PRStatus
+	Name       string
+	MinAge     time.Duration
+	// Understaffed keeps only PRs with fewer reviewers than their team required.
+	Understaffed bool
+	Limit        int
+	Offset       int
+}
+
+func (s *Service) ListPRs(ctx context.Context, f PRFilter) (_ []domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "ListPRs")
+	defer endSpan(span, &err)
+	rf := repo.PRFilter{
+		Team:     f.TeamName,
+		Author:   f.AuthorID,
+		Reviewer: f.ReviewerID,
+		Status:   string(f.Status),
+		Name:     f.Name,
+		Limit:    f.Limit,
+		Offset:   f.Offset,
+	}
+	if f.MinAge > 0 {
+		rf.CreatedBefore = time.Now().Add(-f.MinAge)
+	}
+	rf.Understaffed = f.Understaffed
+	rows, err := s.r.ListPRs(ctx, rf)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.PullRequest, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, prFromRow(row))
 	}
-	if mergedAt.Valid {
-		t := mergedAt.Time
+	return out, nil
+}
+
+func prFromRow(row repo.PRRow) domain.PullRequest {
+	pr := domain.PullRequest{
+		ID:                row.ID,
+		Name:              row.Name,
+		AuthorID:          row.Author,
+		TeamName:          row.Team,
+		Status:            domain.PRStatus(row.Status),
+		Reviewers:         row.Reviewers,
+		RequiredReviewers: row.RequiredReviewers,
+		MergedBy:          row.MergedBy,
+		IsDraft:           row.IsDraft,
+	}
+	if row.CreatedAt.Valid {
+		pr.CreatedAt = row.CreatedAt.Time
+	}
+	if row.MergedAt.Valid {
+		t := row.MergedAt.Time
 		pr.MergedAt = &t
 	}
-	return pr, nil
+	if row.ClosedAt.Valid {
+		t := row.ClosedAt.Time
+		pr.ClosedAt = &t
+	}
+	return pr
 }
 
-func (s *Service) MergePR(ctx context.Context, id string) (domain.PullRequest, error) {
-	if _, err := s.r.PRStatus(ctx, id); err != nil {
-		if errors.Is(err, repo.ErrNotFound) {
-			return domain.PullRequest{}, errors.New(string(domain.ErrNotFound))
+// errNotOpen is the error for changing a PR that is merged or closed.
+func errNotOpen(status domain.PRStatus) error {
+	if status == domain.PRClosed {
+		return errors.New(string(domain.ErrPRClosed))
+	}
+	return errors.New(string(domain.ErrPRMerged))
+}
+
+// MergePR is idempotent. Open PRs of teams with the require_reviewers merge
+// policy are only merged once they have their required reviewer count.
+// Closed PRs have to be reopened first.
+func (s *Service) MergePR(ctx context.Context, id string) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "MergePR")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "pr.merge", id, map[string]any{"pull_request_id": id}, func(ts *Service) (err error) {
+		var merged bool
+		if out, merged, err = ts.mergePR(ctx, id, false); err != nil || !merged {
+			return err
 		}
-		return domain.PullRequest{}, err
+		return ts.emit(ctx, domain.EventPRMerged, map[string]any{"pull_request": out})
+	})
+	return out, err
+}
+
+// RecordUpstreamMerge records a merge that already happened on the VCS host,
+// as reported by its webhook. The merge policy is not consulted: the merge
+// can no longer be refused, and blocking it would leave the PR open here
+// forever. Only admins (the ingest endpoints act as one) may record it.
+func (s *Service) RecordUpstreamMerge(ctx context.Context, id string) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "RecordUpstreamMerge")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "pr.merge_upstream", id, map[string]any{"pull_request_id": id}, func(ts *Service) (err error) {
+		var merged bool
+		if out, merged, err = ts.mergePR(ctx, id, true); err != nil || !merged {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRMerged, map[string]any{"pull_request": out})
+	})
+	return out, err
+}
+
+// mergePR also reports whether this call merged the PR, as opposed to finding
+// it already merged. An upstream merge skips the merge policy.
+func (s *Service) mergePR(ctx context.Context, id string, upstream bool) (domain.PullRequest, bool, error) {
+	pr, err := s.GetPR(ctx, id)
+	if err != nil {
+		return domain.PullRequest{}, false, err
 	}
-	if err := s.r.MergePR(ctx, id); err != nil {
-		return domain.PullRequest{}, err
+	if upstream {
+		err = s.requireAdmin(ctx)
+	} else {
+		err = s.requireSelfOrLead(ctx, pr.AuthorID, pr.TeamName)
 	}
-	return s.GetPR(ctx, id)
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if pr.Status == domain.PRClosed {
+		return domain.PullRequest{}, false, errors.New(string(domain.ErrPRClosed))
+	}
+	if pr.Status == domain.PROpen && !upstream {
+		settings, err := s.effectiveSettings(ctx, pr.TeamName)
+		if err != nil {
+			return domain.PullRequest{}, false, err
+		}
+		if settings.MergePolicy == domain.MergeRequireReviewers && len(pr.Reviewers) < pr.RequiredReviewers {
+			return domain.PullRequest{}, false, errors.New(string(domain.ErrMergeBlocked))
+		}
+	}
+	if err := s.r.MergePR(ctx, id, actorName(ctx)); err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	merged, err := s.GetPR(ctx, id)
+	return merged, pr.Status == domain.PROpen, err
+}
+
+// ClosePR closes an open PR without merging it; its reviewers stay assigned
+// in case it is reopened. Closing a closed PR is a no-op.
+func (s *Service) ClosePR(ctx context.Context, id string) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "ClosePR")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "pr.close", id, map[string]any{"pull_request_id": id}, func(ts *Service) (err error) {
+		var changed bool
+		if out, changed, err = ts.setPRClosed(ctx, id, true); err != nil || !changed {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRClosed, map[string]any{"pull_request": out})
+	})
+	return out, err
+}
+
+// ReopenPR opens a closed PR again. Reopening an open PR is a no-op.
+func (s *Service) ReopenPR(ctx context.Context, id string) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "ReopenPR")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "pr.reopen", id, map[string]any{"pull_request_id": id}, func(ts *Service) (err error) {
+		var changed bool
+		if out, changed, err = ts.setPRClosed(ctx, id, false); err != nil || !changed {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRReopened, map[string]any{"pull_request": out})
+	})
+	return out, err
 }
 
-func (s *Service) ReassignReviewer(ctx context.Context, prID, oldUser string) (domain.PullRequest, string, error) {
+// setPRClosed also reports whether the PR changed state. Merged PRs can be
+// neither closed nor reopened.
+func (s *Service) setPRClosed(ctx context.Context, id string, closed bool) (domain.PullRequest, bool, error) {
+	pr, err := s.GetPR(ctx, id)
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if err := s.requireSelfOrLead(ctx, pr.AuthorID, pr.TeamName); err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	switch {
+	case pr.Status == domain.PRMerged:
+		return domain.PullRequest{}, false, errors.New(string(domain.ErrPRMerged))
+	case (pr.Status == domain.PRClosed) == closed:
+		return pr, false, nil
+	case closed:
+		err = s.r.ClosePR(ctx, id)
+	default:
+		err = s.r.ReopenPR(ctx, id)
+	}
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	updated, err := s.GetPR(ctx, id)
+	return updated, true, err
+}
+
+func (s *Service) ReassignReviewer(ctx context.Context, prID, oldUser string) (out domain.PullRequest, replacedBy string, err error) {
+	ctx, span := startSpan(ctx, "ReassignReviewer")
+	defer endSpan(span, &err)
+	in := map[string]any{"pull_request_id": prID, "old_user_id": oldUser}
+	err = s.audit(ctx, "pr.reassign", prID, in, func(ts *Service) (err error) {
+		if out, replacedBy, err = ts.reassignReviewer(ctx, prID, oldUser); err != nil {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRReassigned, map[string]any{"pull_request": out, "old_user_id": oldUser, "replaced_by": replacedBy})
+	})
+	return out, replacedBy, err
+}
+
+func (s *Service) reassignReviewer(ctx context.Context, prID, oldUser string) (domain.PullRequest, string, error) {
 	status, err := s.r.PRStatus(ctx, prID)
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
@@ -130,8 +526,15 @@ func (s *Service) ReassignReviewer(ctx context.Context, prID, oldUser string) (d
 		}
 		return domain.PullRequest{}, "", err
 	}
-	if status == string(domain.PRMerged) {
-		return domain.PullRequest{}, "", errors.New(string(domain.ErrPRMerged))
+	if status != string(domain.PROpen) {
+		return domain.PullRequest{}, "", errNotOpen(domain.PRStatus(status))
+	}
+	pr, err := s.GetPR(ctx, prID)
+	if err != nil {
+		return domain.PullRequest{}, "", err
+	}
+	if err := s.requireSelfOrLead(ctx, oldUser, pr.TeamName); err != nil {
+		return domain.PullRequest{}, "", err
 	}
 	assigned, err := s.r.IsReviewerAssigned(ctx, prID, oldUser)
 	if err != nil {
@@ -140,17 +543,22 @@ func (s *Service) ReassignReviewer(ctx context.Context, prID, oldUser string) (d
 	if !assigned {
 		return domain.PullRequest{}, "", errors.New(string(domain.ErrNotAssigned))
 	}
-	pr, err := s.GetPR(ctx, prID)
-	if err != nil {
-		return domain.PullRequest{}, "", err
+	team := pr.TeamName
+	if team == "" {
+		if team, err = s.r.UserTeam(ctx, oldUser); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return domain.PullRequest{}, "", errors.New(string(domain.ErrNoCandidate))
+			}
+			return domain.PullRequest{}, "", err
+		}
 	}
-	team, err := s.r.UserTeam(ctx, oldUser)
+	settings, err := s.effectiveSettings(ctx, team)
 	if err != nil {
 		return domain.PullRequest{}, "", err
 	}
 	exclude := append([]string{}, pr.Reviewers...)
 	exclude = append(exclude, oldUser)
-	uid, err := s.r.RandomReplacementCandidate(ctx, team, pr.AuthorID, exclude)
+	uid, err := s.r.ReplacementCandidate(ctx, team, pr.AuthorID, exclude, string(settings.Strategy))
 	if err != nil {
 		if errors.Is(err, repo.ErrNotFound) {
 			return domain.PullRequest{}, "", errors.New(string(domain.ErrNoCandidate))
@@ -164,7 +572,46 @@ func (s *Service) ReassignReviewer(ctx context.Context, prID, oldUser string) (d
 	return updated, uid, err
 }
 
-func (s *Service) PRsForReviewer(ctx context.Context, userID string) ([]domain.PullRequestShort, error) {
+// ReviewPR records that userID has reviewed the PR they are assigned to. Only
+// the first review of an assignment counts; repeats return the PR unchanged.
+func (s *Service) ReviewPR(ctx context.Context, prID, userID string) (out domain.PullRequest, err error) {
+	ctx, span := startSpan(ctx, "ReviewPR")
+	defer endSpan(span, &err)
+	in := map[string]any{"pull_request_id": prID, "user_id": userID}
+	err = s.audit(ctx, "pr.review", prID, in, func(ts *Service) (err error) {
+		var changed bool
+		if out, changed, err = ts.reviewPR(ctx, prID, userID); err != nil || !changed {
+			return err
+		}
+		return ts.emit(ctx, domain.EventPRReviewed, map[string]any{"pull_request": out, "user_id": userID})
+	})
+	return out, err
+}
+
+func (s *Service) reviewPR(ctx context.Context, prID, userID string) (domain.PullRequest, bool, error) {
+	pr, err := s.GetPR(ctx, prID)
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if err := s.requireSelfOrLead(ctx, userID, pr.TeamName); err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	if pr.Status != domain.PROpen {
+		return domain.PullRequest{}, false, errNotOpen(pr.Status)
+	}
+	if !slices.Contains(pr.Reviewers, userID) {
+		return domain.PullRequest{}, false, errors.New(string(domain.ErrNotAssigned))
+	}
+	changed, err := s.r.MarkReviewed(ctx, prID, userID)
+	if err != nil {
+		return domain.PullRequest{}, false, err
+	}
+	return pr, changed, nil
+}
+
+func (s *Service) PRsForReviewer(ctx context.Context, userID string) (_ []domain.PullRequestShort, err error) {
+	ctx, span := startSpan(ctx, "PRsForReviewer")
+	defer endSpan(span, &err)
 	rows, err := s.r.PRsForReviewer(ctx, userID)
 	if err != nil {
 		return nil, err
@@ -176,74 +623,191 @@ func (s *Service) PRsForReviewer(ctx context.Context, userID string) ([]domain.P
 	return out, nil
 }
 
-func (s *Service) AssignmentStats(ctx context.Context) ([]struct {
-	UserID string
-	Count  int
-}, error) {
-	rows, err := s.r.AssignmentStats(ctx)
+type AssignmentStatsFilter struct {
+	From    time.Time
+	To      time.Time
+	UserID  string
+	Team    string
+	GroupBy []string
+}
+
+// AssignmentStats counts reviewer assignments started in [From, To) from the
+// assignment history, grouped by the requested dimensions (user by default).
+func (s *Service) AssignmentStats(ctx context.Context, f AssignmentStatsFilter) (_ []domain.AssignmentStat, err error) {
+	ctx, span := startSpan(ctx, "AssignmentStats")
+	defer endSpan(span, &err)
+	if len(f.GroupBy) == 0 {
+		f.GroupBy = []string{repo.GroupUser}
+	}
+	rows, err := s.r.AssignmentStats(ctx, repo.AssignmentStatsFilter{From: f.From, To: f.To, UserID: f.UserID, Team: f.Team, GroupBy: f.GroupBy})
 	if err != nil {
 		return nil, err
 	}
-	out := make([]struct {
-		UserID string
-		Count  int
-	}, 0, len(rows))
+	out := make([]domain.AssignmentStat, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, domain.AssignmentStat{
+			UserID:     r.UserID,
+			TeamName:   r.Team,
+			Week:       r.Week,
+			Count:      r.Count,
+			Open:       r.Open,
+			Completed:  r.Completed,
+			Closed:     r.Closed,
+			Reassigned: r.Reassigned,
+			Removed:    r.Removed,
+		})
+	}
+	return out, nil
+}
+
+type CycleTimeFilter struct {
+	From time.Time
+	To   time.Time
+	Team string
+}
+
+// CycleTimes reports time to first review and time to merge of PRs opened in
+// [From, To), per team and per week, and the response time of each reviewer
+// to assignments started in that window.
+func (s *Service) CycleTimes(ctx context.Context, f CycleTimeFilter) (_ domain.CycleTimeReport, err error) {
+	ctx, span := startSpan(ctx, "CycleTimes")
+	defer endSpan(span, &err)
+	rf := repo.CycleTimeFilter{From: f.From, To: f.To, Team: f.Team}
+	out := domain.CycleTimeReport{}
+	for _, g := range []struct {
+		group string
+		dst   *[]domain.CycleTime
+	}{{repo.GroupTeam, &out.Teams}, {repo.GroupWeek, &out.Weekly}} {
+		rows, err := s.r.CycleTimes(ctx, rf, g.group)
+		if err != nil {
+			return domain.CycleTimeReport{}, err
+		}
+		*g.dst = make([]domain.CycleTime, 0, len(rows))
+		for _, r := range rows {
+			*g.dst = append(*g.dst, domain.CycleTime{
+				TeamName:          r.Team,
+				Week:              r.Week,
+				PRs:               r.PRs,
+				TimeToFirstReview: percentiles(r.FirstReview),
+				TimeToMerge:       percentiles(r.Merge),
+			})
+		}
+	}
+	rows, err := s.r.ResponseTimes(ctx, rf)
+	if err != nil {
+		return domain.CycleTimeReport{}, err
+	}
+	out.Reviewers = make([]domain.ReviewerResponseTime, 0, len(rows))
 	for _, r := range rows {
-		out = append(out, struct {
-			UserID string
-			Count  int
-		}{UserID: r.UserID, Count: r.Cnt})
+		out.Reviewers = append(out.Reviewers, domain.ReviewerResponseTime{UserID: r.UserID, ResponseTime: percentiles(r.Response)})
 	}
 	return out, nil
 }
 
-func (s *Service) MassDeactivate(ctx context.Context, team string) (int, int, error) {
+func percentiles(p repo.Percentiles) domain.Percentiles {
+	return domain.Percentiles{Count: p.Count, P50: p.P50, P90: p.P90, P99: p.P99}
+}
+
+// MassDeactivate deactivates every membership of the team and hands the
+// team's open reviews held by those members to whoever is still active there.
+// With recursive set, every sub-team is processed the same way.
+func (s *Service) MassDeactivate(ctx context.Context, team string, recursive bool) (reassigned, removed int, err error) {
+	ctx, span := startSpan(ctx, "MassDeactivate")
+	defer endSpan(span, &err)
+	in := map[string]any{"team_name": team, "recursive": recursive}
+	err = s.audit(ctx, "team.deactivate_users", team, in, func(ts *Service) error {
+		changes, err := ts.massDeactivate(ctx, team, recursive)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if c.ReplacedBy != "" {
+				reassigned++
+			} else {
+				removed++
+			}
+		}
+		return ts.emit(ctx, domain.EventTeamDeactivated, map[string]any{"team_name": team, "recursive": recursive, "reassigned": reassigned, "removed": removed, "changes": changes})
+	})
+	return reassigned, removed, err
+}
+
+func (s *Service) massDeactivate(ctx context.Context, team string, recursive bool) ([]domain.ReviewerChange, error) {
+	if err := s.requireLead(ctx, team); err != nil {
+		return nil, err
+	}
+	changes, err := s.massDeactivateTeam(ctx, team)
+	if err != nil || !recursive {
+		return changes, err
+	}
+	children, _, err := s.teamChildren(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subtree(children, team)[1:] {
+		c, err := s.massDeactivateTeam(ctx, sub)
+		if err != nil && err.Error() != string(domain.ErrNotFound) {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+	return changes, nil
+}
+
+func (s *Service) massDeactivateTeam(ctx context.Context, team string) ([]domain.ReviewerChange, error) {
+	changes := []domain.ReviewerChange{}
 	activeBefore, err := s.r.TeamMembers(ctx, team, true)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	if len(activeBefore) == 0 {
 		allMembers, err := s.r.TeamMembers(ctx, team, false)
 		if err != nil {
-			return 0, 0, err
+			return nil, err
 		}
 		if len(allMembers) == 0 {
-			return 0, 0, errors.New(string(domain.ErrNotFound))
+			return nil, errors.New(string(domain.ErrNotFound))
 		}
-		return 0, 0, nil
+		return changes, nil
 	}
 
-	if err := s.r.DeactivateTeamUsers(ctx, team); err != nil {
-		return 0, 0, err
+	if err := s.r.DeactivateTeamMemberships(ctx, team); err != nil {
+		return nil, err
 	}
 
-	affected, err := s.r.OpenPRsAffectedByUsers(ctx, activeBefore)
+	affected, err := s.r.OpenPRsAffectedByUsers(ctx, activeBefore, team)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 
-	reassigned := 0
-	removed := 0
 	for _, a := range affected {
-		pr, err := s.GetPR(ctx, a.PRID)
+		replacedBy, err := s.replaceOrRemoveReviewer(ctx, a.PRID, a.Reviewer, team)
 		if err != nil {
-			return 0, 0, err
+			return nil, err
 		}
-		candidate, err := s.r.RandomReplacementCandidate(ctx, team, pr.AuthorID, pr.Reviewers)
-		if err != nil {
-			if errors.Is(err, repo.ErrNotFound) {
-				if derr := s.r.DeleteReviewer(ctx, a.PRID, a.Reviewer); derr != nil {
-					return 0, 0, derr
-				}
-				removed++
-				continue
-			}
-			return 0, 0, err
-		}
-		if err := s.r.ReplaceReviewer(ctx, a.PRID, a.Reviewer, candidate); err != nil {
-			return 0, 0, err
+		changes = append(changes, domain.ReviewerChange{PRID: a.PRID, OldUserID: a.Reviewer, ReplacedBy: replacedBy})
+	}
+	return changes, nil
+}
+
+// replaceOrRemoveReviewer swaps reviewer on the PR for an active member of
+// team picked by the team's strategy and returns who took over, or drops the
+// reviewer and returns "" when team has nobody left to offer.
+func (s *Service) replaceOrRemoveReviewer(ctx context.Context, prID, reviewer, team string) (string, error) {
+	pr, err := s.GetPR(ctx, prID)
+	if err != nil {
+		return "", err
+	}
+	settings, err := s.effectiveSettings(ctx, team)
+	if err != nil {
+		return "", err
+	}
+	candidate, err := s.r.ReplacementCandidate(ctx, team, pr.AuthorID, pr.Reviewers, string(settings.Strategy))
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return "", s.r.DeleteReviewer(ctx, prID, reviewer)
 		}
-		reassigned++
+		return "", err
 	}
-	return reassigned, removed, nil
+	return candidate, s.r.ReplaceReviewer(ctx, prID, reviewer, candidate)
 }
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// AddMembers adds users to an existing team, or sets the activity of their
+// membership there. Users keep their other memberships; their primary team is
+// only set if they had none. Existing users keep their name and global
+// activity, which a lead of this one team may not change.
+func (s *Service) AddMembers(ctx context.Context, teamName string, members []domain.TeamMember) (out domain.Team, err error) {
+	ctx, span := startSpan(ctx, "AddMembers")
+	defer endSpan(span, &err)
+	in := domain.Team{TeamName: teamName, Members: members}
+	err = s.audit(ctx, "team.add_members", teamName, in, func(ts *Service) (err error) {
+		out, err = ts.addMembers(ctx, teamName, members)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) addMembers(ctx context.Context, teamName string, members []domain.TeamMember) (domain.Team, error) {
+	if err := s.requireLead(ctx, teamName); err != nil {
+		return domain.Team{}, err
+	}
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		exists, err := tx.TeamExists(ctx, teamName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New(string(domain.ErrNotFound))
+		}
+		for _, m := range members {
+			if err := tx.AddMember(ctx, m.UserID, m.Username, teamName, m.IsActive, string(m.Role)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Team{}, err
+	}
+	return s.GetTeam(ctx, teamName)
+}
+
+// RemoveMembers ends the users' membership in the team. They keep their
+// accounts, authored PRs and other memberships.
+func (s *Service) RemoveMembers(ctx context.Context, teamName string, userIDs []string, policy domain.ReviewPolicy) (reassigned, removed int, err error) {
+	ctx, span := startSpan(ctx, "RemoveMembers")
+	defer endSpan(span, &err)
+	in := map[string]any{"team_name": teamName, "user_ids": userIDs, "review_policy": policy}
+	err = s.audit(ctx, "team.remove_members", teamName, in, func(ts *Service) (err error) {
+		reassigned, removed, err = ts.removeMembers(ctx, teamName, userIDs, policy)
+		return err
+	})
+	return reassigned, removed, err
+}
+
+func (s *Service) removeMembers(ctx context.Context, teamName string, userIDs []string, policy domain.ReviewPolicy) (int, int, error) {
+	if err := s.requireLead(ctx, teamName); err != nil {
+		return 0, 0, err
+	}
+	reassigned, removed := 0, 0
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		ts := s.withRepo(tx)
+		for _, uid := range userIDs {
+			if err := tx.RemoveMembership(ctx, teamName, uid); err != nil {
+				if errors.Is(err, repo.ErrNotFound) {
+					return errors.New(string(domain.ErrNotFound))
+				}
+				return err
+			}
+			if err := tx.RefreshPrimaryTeam(ctx, uid); err != nil {
+				return err
+			}
+			if policy != domain.PolicyReassignOld {
+				continue
+			}
+			re, rm, err := ts.reassignOpenReviews(ctx, uid, teamName, teamName)
+			if err != nil {
+				return err
+			}
+			reassigned += re
+			removed += rm
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return reassigned, removed, nil
+}
+
+// MoveMember replaces the user's membership in fromTeam (the primary team when
+// empty) with a membership in toTeam. A user without any team just joins toTeam.
+// Team leads must lead both teams.
+func (s *Service) MoveMember(ctx context.Context, userID, fromTeam, toTeam string, policy domain.ReviewPolicy) (out domain.User, reassigned, removed int, err error) {
+	ctx, span := startSpan(ctx, "MoveMember")
+	defer endSpan(span, &err)
+	in := map[string]any{"user_id": userID, "from_team_name": fromTeam, "team_name": toTeam, "review_policy": policy}
+	err = s.audit(ctx, "team.move_member", userID, in, func(ts *Service) (err error) {
+		out, reassigned, removed, err = ts.moveMember(ctx, userID, fromTeam, toTeam, policy)
+		return err
+	})
+	return out, reassigned, removed, err
+}
+
+func (s *Service) moveMember(ctx context.Context, userID, fromTeam, toTeam string, policy domain.ReviewPolicy) (domain.User, int, int, error) {
+	// Authorize before looking anything up, so that missing teams and users
+	// are only told apart by those allowed to manage them.
+	leadOf := []string{toTeam}
+	if fromTeam != "" {
+		leadOf = append(leadOf, fromTeam)
+	}
+	if err := s.requireLead(ctx, leadOf...); err != nil {
+		return domain.User{}, 0, 0, err
+	}
+	reassigned, removed := 0, 0
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		ts := s.withRepo(tx)
+		exists, err := tx.TeamExists(ctx, toTeam)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New(string(domain.ErrNotFound))
+		}
+		_, primary, _, err := tx.GetUser(ctx, userID)
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		if fromTeam == "" && primary != "" {
+			fromTeam = primary
+			if err := ts.requireLead(ctx, fromTeam); err != nil {
+				return err
+			}
+		}
+		if fromTeam == toTeam {
+			return nil
+		}
+		if fromTeam != "" {
+			if err := tx.RemoveMembership(ctx, fromTeam, userID); err != nil {
+				if errors.Is(err, repo.ErrNotFound) {
+					return errors.New(string(domain.ErrNotFound))
+				}
+				return err
+			}
+		}
+		if err := tx.AddMembership(ctx, toTeam, userID, true, ""); err != nil {
+			return err
+		}
+		if primary == "" || primary == fromTeam {
+			if err := tx.SetUserTeam(ctx, userID, toTeam); err != nil {
+				return err
+			}
+		}
+		var reassignWithin string
+		switch policy {
+		case domain.PolicyReassignOld:
+			reassignWithin = fromTeam
+		case domain.PolicyReassignNew:
+			reassignWithin = toTeam
+		}
+		if reassignWithin != "" && fromTeam != "" {
+			if reassigned, removed, err = ts.reassignOpenReviews(ctx, userID, fromTeam, reassignWithin); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.User{}, 0, 0, err
+	}
+	user, err := s.GetUser(ctx, userID)
+	return user, reassigned, removed, err
+}
+
+func (s *Service) RenameTeam(ctx context.Context, name, newName string) (out domain.Team, err error) {
+	ctx, span := startSpan(ctx, "RenameTeam")
+	defer endSpan(span, &err)
+	in := map[string]any{"team_name": name, "new_team_name": newName}
+	err = s.audit(ctx, "team.rename", name, in, func(ts *Service) (err error) {
+		out, err = ts.renameTeam(ctx, name, newName)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) renameTeam(ctx context.Context, name, newName string) (domain.Team, error) {
+	if err := s.requireLead(ctx, name); err != nil {
+		return domain.Team{}, err
+	}
+	err := s.r.InTx(ctx, func(tx *repo.Repo) error {
+		taken, err := tx.TeamExists(ctx, newName)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errors.New(string(domain.ErrTeamExists))
+		}
+		if err := tx.RenameTeam(ctx, name, newName); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Team{}, err
+	}
+	return s.GetTeam(ctx, newName)
+}
+
+// DeleteTeam removes a team that has no members and no sub-teams left.
+func (s *Service) DeleteTeam(ctx context.Context, name string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteTeam")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "team.delete", name, map[string]any{"team_name": name}, func(ts *Service) error {
+		return ts.deleteTeam(ctx, name)
+	})
+}
+
+func (s *Service) deleteTeam(ctx context.Context, name string) error {
+	if err := s.requireLead(ctx, name); err != nil {
+		return err
+	}
+	return s.r.InTx(ctx, func(tx *repo.Repo) error {
+		members, err := tx.TeamMembers(ctx, name, false)
+		if err != nil {
+			return err
+		}
+		if len(members) > 0 {
+			return errors.New(string(domain.ErrTeamNotEmpty))
+		}
+		children, _, err := s.withRepo(tx).teamChildren(ctx)
+		if err != nil {
+			return err
+		}
+		if len(children[name]) > 0 {
+			return errors.New(string(domain.ErrTeamHasChildren))
+		}
+		if err := tx.DeleteTeam(ctx, name); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// reassignOpenReviews hands the open reviews userID holds on PRs targeting
+// prTeam over to someone from poolTeam, dropping the review where poolTeam has
+// no candidate. Every change is emitted as pull_request.reassigned, with an
+// empty replaced_by for a dropped review.
+func (s *Service) reassignOpenReviews(ctx context.Context, userID, prTeam, poolTeam string) (int, int, error) {
+	affected, err := s.r.OpenPRsAffectedByUsers(ctx, []string{userID}, prTeam)
+	if err != nil {
+		return 0, 0, err
+	}
+	reassigned, removed := 0, 0
+	for _, a := range affected {
+		replacedBy, err := s.replaceOrRemoveReviewer(ctx, a.PRID, a.Reviewer, poolTeam)
+		if err != nil {
+			return 0, 0, err
+		}
+		pr, err := s.GetPR(ctx, a.PRID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := s.emit(ctx, domain.EventPRReassigned, map[string]any{"pull_request": pr, "old_user_id": a.Reviewer, "replaced_by": replacedBy}); err != nil {
+			return 0, 0, err
+		}
+		if replacedBy != "" {
+			reassigned++
+		} else {
+			removed++
+		}
+	}
+	return reassigned, removed, nil
+}
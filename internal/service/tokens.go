@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type TokenRequest struct {
+	Name           string
+	UserID         string
+	ServiceAccount string
+	// Role defaults to member for users and bot for service accounts.
+	Role domain.Role
+	// TTL of zero issues a token that never expires.
+	TTL time.Duration
+}
+
+// IssueToken creates a token bound to a user or a service account. The plain
+// token is returned once and cannot be recovered later.
+func (s *Service) IssueToken(ctx context.Context, req TokenRequest) (plain string, out domain.APIToken, err error) {
+	ctx, span := startSpan(ctx, "IssueToken")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "token.issue", req.Name, req, func(ts *Service) (err error) {
+		plain, out, err = ts.issueToken(ctx, req)
+		return err
+	})
+	return plain, out, err
+}
+
+func (s *Service) issueToken(ctx context.Context, req TokenRequest) (string, domain.APIToken, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return "", domain.APIToken{}, err
+	}
+	if req.Role == "" {
+		req.Role = domain.RoleMember
+		if req.UserID == "" {
+			req.Role = domain.RoleBot
+		}
+	}
+	if req.UserID != "" {
+		exists, err := s.r.UserExists(ctx, req.UserID)
+		if err != nil {
+			return "", domain.APIToken{}, err
+		}
+		if !exists {
+			return "", domain.APIToken{}, errors.New(string(domain.ErrNotFound))
+		}
+	}
+	plain, hash, err := auth.GenerateToken()
+	if err != nil {
+		return "", domain.APIToken{}, err
+	}
+	row := repo.TokenRow{Name: req.Name, UserID: req.UserID, ServiceAccount: req.ServiceAccount, Role: string(req.Role), CreatedBy: actorName(ctx)}
+	if req.TTL > 0 {
+		row.ExpiresAt = pgtype.Timestamptz{Time: time.Now().Add(req.TTL), Valid: true}
+	}
+	row, err = s.r.CreateToken(ctx, hash, row)
+	if err != nil {
+		return "", domain.APIToken{}, err
+	}
+	return plain, tokenFromRow(row), nil
+}
+
+func (s *Service) ListTokens(ctx context.Context) (_ []domain.APIToken, err error) {
+	ctx, span := startSpan(ctx, "ListTokens")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.r.ListTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.APIToken, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, tokenFromRow(row))
+	}
+	return out, nil
+}
+
+func (s *Service) RevokeToken(ctx context.Context, id int64) (out domain.APIToken, err error) {
+	ctx, span := startSpan(ctx, "RevokeToken")
+	defer endSpan(span, &err)
+	err = s.audit(ctx, "token.revoke", idTarget(id), map[string]any{"token_id": id}, func(ts *Service) (err error) {
+		out, err = ts.revokeToken(ctx, id)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) revokeToken(ctx context.Context, id int64) (domain.APIToken, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return domain.APIToken{}, err
+	}
+	row, err := s.r.RevokeToken(ctx, id)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return domain.APIToken{}, errors.New(string(domain.ErrNotFound))
+		}
+		return domain.APIToken{}, err
+	}
+	return tokenFromRow(row), nil
+}
+
+// Authenticate implements auth.Authenticator on top of the stored tokens.
+func (s *Service) Authenticate(ctx context.Context, token string) (_ auth.Actor, err error) {
+	ctx, span := startSpan(ctx, "Authenticate")
+	defer endSpan(span, &err)
+	row, err := s.r.TokenByHash(ctx, auth.HashToken(token))
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return auth.Actor{}, errors.New(string(domain.ErrUnauthorized))
+		}
+		return auth.Actor{}, err
+	}
+	if row.RevokedAt.Valid || (row.ExpiresAt.Valid && row.ExpiresAt.Time.Before(time.Now())) {
+		return auth.Actor{}, errors.New(string(domain.ErrUnauthorized))
+	}
+	if err := s.r.TouchToken(ctx, row.ID); err != nil {
+		return auth.Actor{}, err
+	}
+	return auth.Actor{UserID: row.UserID, ServiceAccount: row.ServiceAccount, TokenID: row.ID, Role: domain.Role(row.Role)}, nil
+}
+
+func tokenFromRow(row repo.TokenRow) domain.APIToken {
+	t := domain.APIToken{
+		ID:             row.ID,
+		Name:           row.Name,
+		UserID:         row.UserID,
+		ServiceAccount: row.ServiceAccount,
+		Role:           domain.Role(row.Role),
+		CreatedBy:      row.CreatedBy,
+		CreatedAt:      row.CreatedAt.Time,
+	}
+	for _, f := range []struct {
+		src pgtype.Timestamptz
+		dst **time.Time
+	}{{row.ExpiresAt, &t.ExpiresAt}, {row.RevokedAt, &t.RevokedAt}, {row.LastUsedAt, &t.LastUsedAt}} {
+		if f.src.Valid {
+			v := f.src.Time
+			*f.dst = &v
+		}
+	}
+	return t
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/example/avito-pr-service/internal/service")
+
+// startSpan starts the span of a Service method; endSpan ends it.
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "Service."+method)
+}
+
+// endSpan ends span with the outcome in *err. API errors are the caller's
+// mistake, so they are only noted on the span; anything else marks it failed.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		if code, ok := apiErrorCode(*err); ok {
+			span.SetAttributes(attribute.String("app.error_code", string(code)))
+		} else {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+	}
+	span.End()
+}
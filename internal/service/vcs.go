@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+// LinkVCSAccount maps a login on a code host to userID so webhooks from that
+// host can be attributed. Linking an already mapped login moves it.
+func (s *Service) LinkVCSAccount(ctx context.Context, provider, login, userID string) (out domain.VCSAccount, err error) {
+	ctx, span := startSpan(ctx, "LinkVCSAccount")
+	defer endSpan(span, &err)
+	in := map[string]any{"provider": provider, "login": login, "user_id": userID}
+	err = s.audit(ctx, "vcs.link", provider+":"+login, in, func(ts *Service) error {
+		if err := ts.requireAdmin(ctx); err != nil {
+			return err
+		}
+		exists, err := ts.r.UserExists(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.New(string(domain.ErrNotFound))
+		}
+		row, err := ts.r.LinkVCSAccount(ctx, repo.VCSAccountRow{Provider: provider, Login: login, UserID: userID, CreatedBy: actorName(ctx)})
+		out = vcsAccountFromRow(row)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) UnlinkVCSAccount(ctx context.Context, provider, login string) (err error) {
+	ctx, span := startSpan(ctx, "UnlinkVCSAccount")
+	defer endSpan(span, &err)
+	in := map[string]any{"provider": provider, "login": login}
+	return s.audit(ctx, "vcs.unlink", provider+":"+login, in, func(ts *Service) error {
+		if err := ts.requireAdmin(ctx); err != nil {
+			return err
+		}
+		if err := ts.r.UnlinkVCSAccount(ctx, provider, login); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Service) ListVCSAccounts(ctx context.Context, provider string) (_ []domain.VCSAccount, err error) {
+	ctx, span := startSpan(ctx, "ListVCSAccounts")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.r.ListVCSAccounts(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.VCSAccount, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, vcsAccountFromRow(row))
+	}
+	return out, nil
+}
+
+// VCSAccountUser resolves a login on a code host to a user ID.
+func (s *Service) VCSAccountUser(ctx context.Context, provider, login string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "VCSAccountUser")
+	defer endSpan(span, &err)
+	userID, err := s.r.VCSAccountUser(ctx, provider, login)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return "", errors.New(string(domain.ErrNotFound))
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+type SyncFailureFilter struct {
+	PRID   string
+	Limit  int
+	Offset int
+}
+
+// ListSyncFailures returns reviewer changes that could not be pushed to
+// provider, newest first.
+func (s *Service) ListSyncFailures(ctx context.Context, provider string, f SyncFailureFilter) (_ []domain.ReviewSyncFailure, err error) {
+	ctx, span := startSpan(ctx, "ListSyncFailures")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.r.ListSyncFailures(ctx, repo.SyncFailureFilter{Provider: provider, PRID: f.PRID, Limit: f.Limit, Offset: f.Offset})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.ReviewSyncFailure, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, domain.ReviewSyncFailure{
+			ID:         row.ID,
+			Provider:   row.Provider,
+			PRID:       row.PRID,
+			EventID:    row.EventID,
+			Operation:  row.Operation,
+			Logins:     row.Logins,
+			StatusCode: row.StatusCode,
+			Error:      row.Error,
+			OccurredAt: row.OccurredAt.Time,
+		})
+	}
+	return out, nil
+}
+
+func vcsAccountFromRow(row repo.VCSAccountRow) domain.VCSAccount {
+	return domain.VCSAccount{Provider: row.Provider, Login: row.Login, UserID: row.UserID, CreatedBy: row.CreatedBy, CreatedAt: row.CreatedAt.Time}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+func (s *Service) CreateSubscription(ctx context.Context, url, secret string, eventTypes []string) (out domain.WebhookSubscription, err error) {
+	ctx, span := startSpan(ctx, "CreateSubscription")
+	defer endSpan(span, &err)
+	in := map[string]any{"url": url, "event_types": eventTypes}
+	err = s.audit(ctx, "webhook.subscribe", url, in, func(ts *Service) error {
+		if err := ts.requireAdmin(ctx); err != nil {
+			return err
+		}
+		row, err := ts.r.CreateSubscription(ctx, repo.SubscriptionRow{URL: url, Secret: secret, EventTypes: eventTypes, CreatedBy: actorName(ctx)})
+		out = subscriptionFromRow(row)
+		return err
+	})
+	return out, err
+}
+
+func (s *Service) ListSubscriptions(ctx context.Context) (_ []domain.WebhookSubscription, err error) {
+	ctx, span := startSpan(ctx, "ListSubscriptions")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.r.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.WebhookSubscription, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, subscriptionFromRow(row))
+	}
+	return out, nil
+}
+
+func (s *Service) DeleteSubscription(ctx context.Context, id int64) (err error) {
+	ctx, span := startSpan(ctx, "DeleteSubscription")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "webhook.unsubscribe", idTarget(id), map[string]any{"subscription_id": id}, func(ts *Service) error {
+		if err := ts.requireAdmin(ctx); err != nil {
+			return err
+		}
+		if err := ts.r.DeleteSubscription(ctx, id); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+type DeliveryFilter struct {
+	SubscriptionID int64
+	EventID        string
+	Status         domain.DeliveryStatus
+	Limit          int
+	Offset         int
+}
+
+// ListDeliveries returns the delivery log, newest first.
+func (s *Service) ListDeliveries(ctx context.Context, f DeliveryFilter) (_ []domain.WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "ListDeliveries")
+	defer endSpan(span, &err)
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.r.ListDeliveries(ctx, repo.DeliveryFilter{SubscriptionID: f.SubscriptionID, EventID: f.EventID, Status: string(f.Status), Limit: f.Limit, Offset: f.Offset})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.WebhookDelivery, 0, len(rows))
+	for _, row := range rows {
+		d := domain.WebhookDelivery{
+			ID:             row.ID,
+			SubscriptionID: row.SubscriptionID,
+			EventID:        row.EventID,
+			EventType:      row.EventType,
+			Status:         domain.DeliveryStatus(row.Status),
+			Attempts:       row.Attempts,
+			LastStatusCode: row.LastStatusCode,
+			LastError:      row.LastError,
+			CreatedAt:      row.CreatedAt.Time,
+		}
+		if row.Status == string(domain.DeliveryPending) && row.NextAttemptAt.Valid {
+			t := row.NextAttemptAt.Time
+			d.NextAttemptAt = &t
+		}
+		if row.DeliveredAt.Valid {
+			t := row.DeliveredAt.Time
+			d.DeliveredAt = &t
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// RetryDelivery requeues a pending or dead delivery with a fresh attempt budget.
+func (s *Service) RetryDelivery(ctx context.Context, id int64) (err error) {
+	ctx, span := startSpan(ctx, "RetryDelivery")
+	defer endSpan(span, &err)
+	return s.audit(ctx, "webhook.retry", idTarget(id), map[string]any{"delivery_id": id}, func(ts *Service) error {
+		if err := ts.requireAdmin(ctx); err != nil {
+			return err
+		}
+		if err := ts.r.RetryDelivery(ctx, id); err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				return errors.New(string(domain.ErrNotFound))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func subscriptionFromRow(row repo.SubscriptionRow) domain.WebhookSubscription {
+	return domain.WebhookSubscription{ID: row.ID, URL: row.URL, EventTypes: row.EventTypes, CreatedBy: row.CreatedBy, CreatedAt: row.CreatedAt.Time}
+}
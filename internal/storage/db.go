@@ -3,11 +3,31 @@ package storage
 import (
 	"context"
 
+	"github.com/example/avito-pr-service/internal/tracing"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Open connects a pool sized by cfg whose queries are traced with
+// tracing.QueryTracer.
 func Open(ctx context.Context, cfg Config) (*pgxpool.Pool, error) {
-	pool, err := pgxpool.New(ctx, cfg.URL)
+	pcfg, err := pgxpool.ParseConfig(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	pcfg.ConnConfig.Tracer = tracing.QueryTracer{}
+	if cfg.MaxConns > 0 {
+		pcfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		pcfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		pcfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		pcfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, pcfg)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,25 @@
+package tracing
+
+// Span exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	// ExporterConsole is the OpenTelemetry spec's name for ExporterStdout.
+	ExporterConsole = "console"
+)
+
+type Config struct {
+	// Exporter is ExporterOTLP, ExporterStdout or ExporterNone. The OTLP
+	// exporter speaks HTTP/protobuf and takes its endpoint, headers and TLS
+	// settings from the standard OTEL_EXPORTER_OTLP_* variables; sampling
+	// follows OTEL_TRACES_SAMPLER, read by the SDK itself.
+	Exporter    string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	ServiceName string `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+func (c Config) Enabled() bool { return c.Exporter != "" && c.Exporter != ExporterNone }
+
+func DefaultConfig() Config {
+	return Config{Exporter: ExporterNone, ServiceName: "avito-pr-service"}
+}
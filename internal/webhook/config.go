@@ -0,0 +1,28 @@
+package webhook
+
+import "time"
+
+type Config struct {
+	// MaxAttempts is how many times a delivery is tried before it goes dead.
+	MaxAttempts int `yaml:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	// The wait before retry n is BaseBackoff*2^(n-1), capped at MaxBackoff.
+	BaseBackoff time.Duration `yaml:"backoff_base" env:"WEBHOOK_BACKOFF_BASE"`
+	MaxBackoff  time.Duration `yaml:"backoff_max" env:"WEBHOOK_BACKOFF_MAX"`
+	// Timeout bounds a single HTTP attempt.
+	Timeout      time.Duration `yaml:"timeout" env:"WEBHOOK_TIMEOUT"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"WEBHOOK_POLL_INTERVAL"`
+	// BatchSize is how many deliveries one pass sends; each is claimed on
+	// its own, just before it is sent.
+	BatchSize int `yaml:"batch_size" env:"WEBHOOK_BATCH_SIZE"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:  8,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+		Timeout:      10 * time.Second,
+		PollInterval: 2 * time.Second,
+		BatchSize:    20,
+	}
+}
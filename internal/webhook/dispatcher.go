@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/repo"
+)
+
+var logger = logging.Logger("webhook")
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature-256"
+)
+
+// Sign returns the signature header value for body: "sha256=" followed by the
+// hex HMAC-SHA256 of the body keyed with the subscription secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends queued deliveries and retries failed ones with exponential
+// backoff until they succeed or run out of attempts.
+type Dispatcher struct {
+	r      *repo.Repo
+	cfg    Config
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewDispatcher(r *repo.Repo, cfg Config) *Dispatcher {
+	return &Dispatcher{r: r, cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, wake: make(chan struct{}, 1)}
+}
+
+// Notify makes Run look for due deliveries now instead of at the next poll.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.cfg.PollInterval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := d.deliverDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				logger.ErrorContext(ctx, "dispatch failed", logging.Err(err))
+			}
+			if err != nil || n < d.cfg.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue sends up to BatchSize due deliveries and reports how many it
+// sent. Sends are sequential, so each delivery is claimed right before its
+// send: a lease of two attempt timeouts then covers the send it guards,
+// however long the batch runs.
+func (d *Dispatcher) deliverDue(ctx context.Context) (int, error) {
+	n := 0
+	for n < d.cfg.BatchSize {
+		due, err := d.r.ClaimDeliveries(ctx, 1, 2*d.cfg.Timeout)
+		if err != nil || len(due) == 0 {
+			return n, err
+		}
+		n++
+		if err := d.deliver(ctx, due[0]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, del repo.DeliveryRow) error {
+	code, sendErr := d.send(ctx, del)
+	if sendErr == nil {
+		return d.r.MarkDelivered(ctx, del.ID, code)
+	}
+	attempt := del.Attempts + 1
+	var status *int
+	if code != 0 {
+		status = &code
+	}
+	dead := attempt >= d.cfg.MaxAttempts
+	return d.r.MarkAttemptFailed(ctx, del.ID, status, sendErr.Error(), time.Now().Add(d.Backoff(attempt)), dead)
+}
+
+// send posts the delivery; any non-2xx answer counts as a failure.
+func (d *Dispatcher) send(ctx context.Context, del repo.DeliveryRow) (int, error) {
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pr-reviewer-service")
+	req.Header.Set(HeaderEvent, del.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(del.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(del.Secret, body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver answered %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Backoff is the wait after the given failed attempt.
+func (d *Dispatcher) Backoff(attempt int) time.Duration {
+	wait := d.cfg.BaseBackoff
+	for i := 1; i < attempt && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.cfg.MaxBackoff)
+}
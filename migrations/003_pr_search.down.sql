@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_pull_requests_name_trgm;
+DROP INDEX IF EXISTS idx_pull_requests_status_created;
+DROP INDEX IF EXISTS idx_pull_requests_created;
+DROP INDEX IF EXISTS idx_pull_requests_author;
+DROP INDEX IF EXISTS idx_users_team;
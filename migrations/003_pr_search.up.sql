@@ -0,0 +1,10 @@
+-- Indexes backing GET /pullRequest/list filters
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_users_team ON users(team_name);
+CREATE INDEX IF NOT EXISTS idx_pull_requests_author ON pull_requests(author_id);
+CREATE INDEX IF NOT EXISTS idx_pull_requests_created ON pull_requests(created_at DESC, pull_request_id);
+CREATE INDEX IF NOT EXISTS idx_pull_requests_status_created ON pull_requests(status, created_at DESC);
+
+-- Trigram index so that ILIKE '%substr%' on the name does not scan the table
+CREATE INDEX IF NOT EXISTS idx_pull_requests_name_trgm ON pull_requests USING gin (pull_request_name gin_trgm_ops);
@@ -0,0 +1,6 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_team_name_fkey;
+ALTER TABLE users ADD CONSTRAINT users_team_name_fkey
+    FOREIGN KEY (team_name) REFERENCES teams(team_name) ON DELETE RESTRICT;
+
+-- Fails while there are users without a team; move or drop them first
+ALTER TABLE users ALTER COLUMN team_name SET NOT NULL;
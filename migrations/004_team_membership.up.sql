@@ -0,0 +1,7 @@
+-- Users removed from a team stay in the table (they may author PRs) but have no team
+ALTER TABLE users ALTER COLUMN team_name DROP NOT NULL;
+
+-- Renaming a team renames it for its members too
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_team_name_fkey;
+ALTER TABLE users ADD CONSTRAINT users_team_name_fkey
+    FOREIGN KEY (team_name) REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE RESTRICT;
@@ -0,0 +1,22 @@
+-- A user may belong to several teams; users.team_name stays as the primary team
+CREATE TABLE IF NOT EXISTS team_members (
+    team_name TEXT    NOT NULL REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE RESTRICT,
+    user_id   TEXT    NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (team_name, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_team_members_user ON team_members(user_id);
+
+INSERT INTO team_members(team_name, user_id)
+SELECT team_name, user_id FROM users WHERE team_name IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- Target team of a PR decides its reviewer pool
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS team_name TEXT
+    REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE SET NULL;
+
+UPDATE pull_requests p SET team_name = u.team_name
+FROM users u WHERE u.user_id = p.author_id AND p.team_name IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_pull_requests_team ON pull_requests(team_name);
@@ -0,0 +1,7 @@
+ALTER TABLE IF EXISTS pull_requests DROP COLUMN IF EXISTS required_reviewers;
+DROP INDEX IF EXISTS idx_teams_parent;
+ALTER TABLE IF EXISTS teams DROP CONSTRAINT IF EXISTS teams_parent_not_self;
+ALTER TABLE IF EXISTS teams DROP COLUMN IF EXISTS merge_policy;
+ALTER TABLE IF EXISTS teams DROP COLUMN IF EXISTS assignment_strategy;
+ALTER TABLE IF EXISTS teams DROP COLUMN IF EXISTS reviewer_count;
+ALTER TABLE IF EXISTS teams DROP COLUMN IF EXISTS parent_team_name;
@@ -0,0 +1,17 @@
+-- Teams form a tree; settings left NULL are inherited from the parent
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS parent_team_name TEXT
+    REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE RESTRICT;
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS reviewer_count INTEGER
+    CHECK (reviewer_count BETWEEN 0 AND 10);
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS assignment_strategy TEXT
+    CHECK (assignment_strategy IN ('random', 'least_loaded'));
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS merge_policy TEXT
+    CHECK (merge_policy IN ('any', 'require_reviewers'));
+
+ALTER TABLE teams DROP CONSTRAINT IF EXISTS teams_parent_not_self;
+ALTER TABLE teams ADD CONSTRAINT teams_parent_not_self CHECK (parent_team_name <> team_name);
+
+CREATE INDEX IF NOT EXISTS idx_teams_parent ON teams(parent_team_name);
+
+-- Reviewer count in effect when the PR was created
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS required_reviewers INTEGER NOT NULL DEFAULT 2;
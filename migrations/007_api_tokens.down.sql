@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS pull_requests DROP COLUMN IF EXISTS merged_by;
+DROP TABLE IF EXISTS api_tokens;
@@ -0,0 +1,18 @@
+-- Bearer tokens; only the SHA-256 of a token is stored
+CREATE TABLE IF NOT EXISTS api_tokens (
+    token_id        BIGSERIAL PRIMARY KEY,
+    token_hash      BYTEA       NOT NULL UNIQUE,
+    name            TEXT        NOT NULL,
+    user_id         TEXT        NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    service_account TEXT        NULL,
+    is_admin        BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_by      TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMPTZ NULL,
+    revoked_at      TIMESTAMPTZ NULL,
+    last_used_at    TIMESTAMPTZ NULL,
+    CONSTRAINT api_tokens_one_principal CHECK ((user_id IS NULL) <> (service_account IS NULL))
+);
+
+-- Who merged a PR, as reported by the authenticated actor
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS merged_by TEXT NULL;
@@ -0,0 +1,11 @@
+ALTER TABLE IF EXISTS api_tokens DROP CONSTRAINT IF EXISTS api_tokens_role_principal;
+ALTER TABLE IF EXISTS api_tokens ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'api_tokens' AND column_name = 'role') THEN
+        UPDATE api_tokens SET is_admin = (role = 'admin');
+    END IF;
+END $$;
+
+ALTER TABLE IF EXISTS api_tokens DROP COLUMN IF EXISTS role;
@@ -0,0 +1,20 @@
+-- Tokens carry a role instead of an admin flag
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+    CHECK (role IN ('admin', 'team_lead', 'member', 'bot'));
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'api_tokens' AND column_name = 'is_admin') THEN
+        UPDATE api_tokens SET role = CASE
+            WHEN is_admin THEN 'admin'
+            WHEN service_account IS NOT NULL THEN 'bot'
+            ELSE 'member'
+        END;
+    END IF;
+END $$;
+
+ALTER TABLE api_tokens DROP COLUMN IF EXISTS is_admin;
+
+-- Team leads and members act as themselves, so they need a user
+ALTER TABLE api_tokens DROP CONSTRAINT IF EXISTS api_tokens_role_principal;
+ALTER TABLE api_tokens ADD CONSTRAINT api_tokens_role_principal CHECK (role IN ('admin', 'bot') OR user_id IS NOT NULL);
@@ -0,0 +1,27 @@
+-- Append-only record of every mutating call
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id       BIGSERIAL PRIMARY KEY,
+    occurred_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor          TEXT        NOT NULL,
+    action         TEXT        NOT NULL,
+    target         TEXT        NOT NULL,
+    payload_digest TEXT        NOT NULL,
+    result         TEXT        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_occurred ON audit_log(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target, occurred_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END $$;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
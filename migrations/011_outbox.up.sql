@@ -0,0 +1,14 @@
+-- Events written in the same transaction as the change they describe
+CREATE TABLE IF NOT EXISTS outbox (
+    outbox_id       BIGSERIAL PRIMARY KEY,
+    event_id        TEXT        NOT NULL UNIQUE,
+    event_type      TEXT        NOT NULL,
+    payload         TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT        NULL,
+    sent_at         TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(next_attempt_at, outbox_id) WHERE sent_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS pull_requests DROP COLUMN IF EXISTS is_draft;
+DROP TABLE IF EXISTS vcs_accounts;
@@ -0,0 +1,12 @@
+-- Maps accounts on code hosts (GitHub logins, ...) to our users
+CREATE TABLE IF NOT EXISTS vcs_accounts (
+    provider   TEXT        NOT NULL,
+    login      TEXT        NOT NULL,
+    user_id    TEXT        NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    created_by TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (provider, login)
+);
+
+-- Draft PRs get their reviewers once they are marked ready for review
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS is_draft BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,16 @@
+ALTER TABLE IF EXISTS pull_requests DROP COLUMN IF EXISTS closed_at;
+
+-- Enum values cannot be dropped: reopen closed PRs and recreate the type
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_enum e JOIN pg_type t ON t.oid = e.enumtypid
+               WHERE t.typname = 'pr_status' AND e.enumlabel = 'CLOSED') THEN
+        UPDATE pull_requests SET status = 'OPEN' WHERE status::text = 'CLOSED';
+        ALTER TYPE pr_status RENAME TO pr_status_old;
+        CREATE TYPE pr_status AS ENUM ('OPEN','MERGED');
+        ALTER TABLE pull_requests ALTER COLUMN status DROP DEFAULT;
+        ALTER TABLE pull_requests ALTER COLUMN status TYPE pr_status USING status::text::pr_status;
+        ALTER TABLE pull_requests ALTER COLUMN status SET DEFAULT 'OPEN';
+        DROP TYPE pr_status_old;
+    END IF;
+END $$;
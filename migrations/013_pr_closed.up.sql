@@ -0,0 +1,3 @@
+-- PRs closed on the code host without merging; they can be reopened
+ALTER TYPE pr_status ADD VALUE IF NOT EXISTS 'CLOSED';
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS closed_at TIMESTAMPTZ NULL;
@@ -0,0 +1,14 @@
+-- Reviewer changes that could not be pushed to the code host
+CREATE TABLE IF NOT EXISTS review_sync_failures (
+    failure_id      BIGSERIAL PRIMARY KEY,
+    provider        TEXT        NOT NULL,
+    pull_request_id TEXT        NOT NULL,
+    event_id        TEXT        NOT NULL,
+    operation       TEXT        NOT NULL CHECK (operation IN ('request', 'remove')),
+    logins          TEXT[]      NOT NULL,
+    status_code     INTEGER     NULL,
+    error           TEXT        NOT NULL,
+    occurred_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_review_sync_failures_pr ON review_sync_failures(pull_request_id);
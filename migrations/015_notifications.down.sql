@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS notify_users;
+DROP TABLE IF EXISTS notify_channels;
@@ -0,0 +1,17 @@
+-- Chat channel a team's notifications are posted to; sub-teams without their
+-- own row inherit the nearest ancestor's
+CREATE TABLE IF NOT EXISTS notify_channels (
+    team_name   TEXT        PRIMARY KEY REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE CASCADE,
+    channel     TEXT        NOT NULL,
+    webhook_url TEXT        NOT NULL DEFAULT '',
+    updated_by  TEXT        NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Chat handles of users, used for mentions and, when dm is set, direct messages
+CREATE TABLE IF NOT EXISTS notify_users (
+    user_id    TEXT        PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    handle     TEXT        NOT NULL,
+    dm         BOOLEAN     NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
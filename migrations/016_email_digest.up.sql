@@ -0,0 +1,12 @@
+-- Per-user email digest of pending reviews. hour and weekday are local to
+-- timezone; weekday (0 = Sunday) only matters for weekly digests.
+CREATE TABLE IF NOT EXISTS digest_preferences (
+    user_id      TEXT        PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    email        TEXT        NOT NULL,
+    schedule     TEXT        NOT NULL CHECK (schedule IN ('off', 'daily', 'weekly')),
+    hour         SMALLINT    NOT NULL DEFAULT 9 CHECK (hour BETWEEN 0 AND 23),
+    weekday      SMALLINT    NOT NULL DEFAULT 1 CHECK (weekday BETWEEN 0 AND 6),
+    timezone     TEXT        NOT NULL DEFAULT 'UTC',
+    last_sent_at TIMESTAMPTZ NULL,
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
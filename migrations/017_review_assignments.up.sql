@@ -0,0 +1,28 @@
+-- Every reviewer assignment with when it started and, once over, when and why
+-- it ended: "reassigned" when someone took over, "removed" when nobody did
+CREATE TABLE IF NOT EXISTS review_assignments (
+    assignment_id   BIGSERIAL   PRIMARY KEY,
+    pull_request_id TEXT        NOT NULL REFERENCES pull_requests(pull_request_id) ON DELETE CASCADE,
+    user_id         TEXT        NOT NULL REFERENCES users(user_id) ON DELETE RESTRICT,
+    assigned_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    unassigned_at   TIMESTAMPTZ NULL,
+    reason          TEXT        NULL CHECK (reason IN ('reassigned', 'removed')),
+    CHECK ((unassigned_at IS NULL) = (reason IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_review_assignments_active ON review_assignments(pull_request_id, user_id) WHERE unassigned_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_review_assignments_user ON review_assignments(user_id, assigned_at);
+CREATE INDEX IF NOT EXISTS idx_review_assignments_assigned ON review_assignments(assigned_at);
+
+-- Backfill the current assignments, dated by PR creation
+INSERT INTO review_assignments(pull_request_id, user_id, assigned_at)
+SELECT r.pull_request_id, r.user_id, p.created_at
+FROM pr_reviewers r JOIN pull_requests p ON p.pull_request_id=r.pull_request_id
+ON CONFLICT DO NOTHING;
+
+-- The history replaces the lifetime counter
+DROP TRIGGER IF EXISTS trg_pr_reviewers_inc ON pr_reviewers;
+DROP TRIGGER IF EXISTS trg_pr_reviewers_dec ON pr_reviewers;
+DROP FUNCTION IF EXISTS pr_reviewers_inc();
+DROP FUNCTION IF EXISTS pr_reviewers_dec();
+DROP TABLE IF EXISTS stats_assignments;
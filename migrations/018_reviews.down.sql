@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_review_assignments_pr;
+ALTER TABLE IF EXISTS review_assignments DROP COLUMN IF EXISTS reviewed_at;
@@ -0,0 +1,4 @@
+-- When the reviewer first responded while holding the assignment
+ALTER TABLE review_assignments ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS idx_review_assignments_pr ON review_assignments(pull_request_id);
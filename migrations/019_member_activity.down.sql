@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS trg_users_activity ON users;
+DROP TRIGGER IF EXISTS trg_team_members_activity_upd ON team_members;
+DROP TRIGGER IF EXISTS trg_team_members_activity ON team_members;
+DROP FUNCTION IF EXISTS users_activity();
+DROP FUNCTION IF EXISTS team_members_activity();
+DROP FUNCTION IF EXISTS member_activity_open(TEXT, TEXT);
+DROP TABLE IF EXISTS member_activity;
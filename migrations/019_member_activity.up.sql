@@ -0,0 +1,64 @@
+-- Periods when a member could review for a team: the membership and the user
+-- were both active. Kept by triggers, so every path that changes either flag
+-- is recorded.
+CREATE TABLE IF NOT EXISTS member_activity (
+    activity_id BIGSERIAL   PRIMARY KEY,
+    team_name   TEXT        NOT NULL REFERENCES teams(team_name) ON UPDATE CASCADE ON DELETE CASCADE,
+    user_id     TEXT        NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    active_from TIMESTAMPTZ NOT NULL DEFAULT now(),
+    active_to   TIMESTAMPTZ NULL,
+    CHECK (active_to IS NULL OR active_to >= active_from)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_member_activity_open ON member_activity(team_name, user_id) WHERE active_to IS NULL;
+CREATE INDEX IF NOT EXISTS idx_member_activity_team ON member_activity(team_name, active_from);
+
+-- Current members count as active since the assignment history began
+INSERT INTO member_activity(team_name, user_id, active_from)
+SELECT m.team_name, m.user_id, COALESCE((SELECT min(assigned_at) FROM review_assignments), now())
+FROM team_members m JOIN users u ON u.user_id=m.user_id
+WHERE m.is_active AND u.is_active
+ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION member_activity_open(team TEXT, uid TEXT) RETURNS VOID AS $$
+BEGIN
+  INSERT INTO member_activity(team_name, user_id) VALUES (team, uid)
+  ON CONFLICT (team_name, user_id) WHERE active_to IS NULL DO NOTHING;
+END; $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION team_members_activity() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP <> 'INSERT' THEN
+    UPDATE member_activity SET active_to=now()
+    WHERE team_name=OLD.team_name AND user_id=OLD.user_id AND active_to IS NULL;
+  END IF;
+  IF TG_OP <> 'DELETE' AND NEW.is_active AND EXISTS (SELECT 1 FROM users WHERE user_id=NEW.user_id AND is_active) THEN
+    PERFORM member_activity_open(NEW.team_name, NEW.user_id);
+  END IF;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION users_activity() RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.is_active THEN
+    PERFORM member_activity_open(m.team_name, m.user_id) FROM team_members m WHERE m.user_id=NEW.user_id AND m.is_active;
+  ELSE
+    UPDATE member_activity SET active_to=now() WHERE user_id=NEW.user_id AND active_to IS NULL;
+  END IF;
+  RETURN NULL;
+END; $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_team_members_activity ON team_members;
+CREATE TRIGGER trg_team_members_activity
+AFTER INSERT OR DELETE ON team_members
+FOR EACH ROW EXECUTE FUNCTION team_members_activity();
+
+DROP TRIGGER IF EXISTS trg_team_members_activity_upd ON team_members;
+CREATE TRIGGER trg_team_members_activity_upd
+AFTER UPDATE OF is_active ON team_members
+FOR EACH ROW WHEN (OLD.is_active IS DISTINCT FROM NEW.is_active) EXECUTE FUNCTION team_members_activity();
+
+DROP TRIGGER IF EXISTS trg_users_activity ON users;
+CREATE TRIGGER trg_users_activity
+AFTER UPDATE OF is_active ON users
+FOR EACH ROW WHEN (OLD.is_active IS DISTINCT FROM NEW.is_active) EXECUTE FUNCTION users_activity();
@@ -0,0 +1,8 @@
+-- Sinks that already published an outbox event, so a retry after a partial
+-- failure only goes to the sinks that failed
+CREATE TABLE IF NOT EXISTS outbox_sink_state (
+    event_id TEXT        NOT NULL REFERENCES outbox(event_id) ON DELETE CASCADE,
+    sink     TEXT        NOT NULL,
+    sent_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (event_id, sink)
+);
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS team_members DROP COLUMN IF EXISTS role;
@@ -0,0 +1,10 @@
+-- Who leads a team is recorded on the membership, so joining a team as a
+-- plain member grants no lead rights over it
+ALTER TABLE team_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+    CHECK (role IN ('member', 'lead'));
+
+-- Users holding a team_lead token keep leading their primary team
+UPDATE team_members m SET role = 'lead'
+FROM users u
+WHERE u.user_id = m.user_id AND u.team_name = m.team_name
+  AND EXISTS (SELECT 1 FROM api_tokens t WHERE t.user_id = u.user_id AND t.role = 'team_lead' AND t.revoked_at IS NULL);
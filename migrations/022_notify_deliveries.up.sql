@@ -0,0 +1,8 @@
+-- Chat recipients that already got their message for an outbox event, so a
+-- retry of the event only posts to the ones that did not
+CREATE TABLE IF NOT EXISTS notify_deliveries (
+    event_id  TEXT        NOT NULL REFERENCES outbox(event_id) ON DELETE CASCADE,
+    recipient TEXT        NOT NULL,
+    sent_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (event_id, recipient)
+);
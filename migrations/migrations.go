@@ -0,0 +1,8 @@
+// Package migrations embeds the schema migrations so the binary can apply
+// them itself. Files come in pairs, NNN_name.up.sql and NNN_name.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
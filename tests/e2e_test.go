@@ -1,40 +1,81 @@
 package tests
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/example/avito-pr-service/internal/auth"
+	"github.com/example/avito-pr-service/internal/config"
+	"github.com/example/avito-pr-service/internal/digest"
+	"github.com/example/avito-pr-service/internal/domain"
+	"github.com/example/avito-pr-service/internal/github"
+	"github.com/example/avito-pr-service/internal/gitlab"
+	"github.com/example/avito-pr-service/internal/health"
+	"github.com/example/avito-pr-service/internal/logging"
+	"github.com/example/avito-pr-service/internal/metrics"
+	"github.com/example/avito-pr-service/internal/migrate"
+	"github.com/example/avito-pr-service/internal/notify"
+	"github.com/example/avito-pr-service/internal/outbox"
+	"github.com/example/avito-pr-service/internal/repo"
 	"github.com/example/avito-pr-service/internal/server"
+	"github.com/example/avito-pr-service/internal/storage"
+	"github.com/example/avito-pr-service/internal/tracing"
+	"github.com/example/avito-pr-service/internal/webhook"
+	"github.com/example/avito-pr-service/migrations"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	postgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
 )
 
+// applyMigrations brings pool to the embedded migrations with the runner the
+// service uses. Everything recorded is reverted first, so an external
+// database starts the tests empty.
 func applyMigrations(t *testing.T, pool *pgxpool.Pool) {
 	t.Helper()
-	dir := filepath.Join("..", "migrations")
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		t.Fatalf("read migrations: %v", err)
+	r := migrate.Embedded(pool)
+	if err := r.Goto(context.Background(), 0); err != nil {
+		t.Fatalf("revert migrations: %v", err)
 	}
-	for _, e := range entries {
-		if e.IsDir() || filepath.Ext(e.Name()) != ".sql" {
-			continue
-		}
-		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
-		if err != nil {
-			t.Fatalf("read %s: %v", e.Name(), err)
-		}
-		if _, err := pool.Exec(context.Background(), string(b)); err != nil {
-			t.Fatalf("apply %s: %v", e.Name(), err)
-		}
+	if err := r.Up(context.Background()); err != nil {
+		t.Fatalf("apply migrations: %v", err)
 	}
 }
 
@@ -139,3 +180,3367 @@ func TestFlow_CreateAssignMerge_AndStats(t *testing.T) {
 		time.Sleep(50 * time.Millisecond)
 	}
 }
+
+func TestPRList_Filters(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	teamBody := `{"team_name":"listing","members":[{"user_id":"l1","username":"Alice","is_active":true},{"user_id":"l2","username":"Bob","is_active":true},{"user_id":"l3","username":"Carol","is_active":false}]}`
+	res, err := http.Post(srv.URL+"/team/add", "application/json", strings.NewReader(teamBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("team add status %d", res.StatusCode)
+	}
+	for _, body := range []string{
+		`{"pull_request_id":"list-1","pull_request_name":"Add search endpoint","author_id":"l1"}`,
+		`{"pull_request_id":"list-2","pull_request_name":"Fix typo","author_id":"l2"}`,
+	} {
+		res, err = http.Post(srv.URL+"/pullRequest/create", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("pr create status %d", res.StatusCode)
+		}
+	}
+
+	list := func(query string) []string {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/pullRequest/list?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("list %q status %d", query, res.StatusCode)
+		}
+		var out struct {
+			PullRequests []struct {
+				ID        string   `json:"pull_request_id"`
+				Reviewers []string `json:"assigned_reviewers"`
+			} `json:"pull_requests"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0, len(out.PullRequests))
+		for _, pr := range out.PullRequests {
+			ids = append(ids, pr.ID)
+		}
+		return ids
+	}
+
+	if ids := list("team_name=listing"); len(ids) != 2 {
+		t.Fatalf("team filter: got %v", ids)
+	}
+	if ids := list("name=SEARCH"); len(ids) != 1 || ids[0] != "list-1" {
+		t.Fatalf("name filter: got %v", ids)
+	}
+	if ids := list("reviewer_id=l1"); len(ids) != 1 || ids[0] != "list-2" {
+		t.Fatalf("reviewer filter: got %v", ids)
+	}
+	// Only one active teammate is available for each author, so both PRs lack a second reviewer.
+	if ids := list("understaffed=true&author_id=l2"); len(ids) != 1 {
+		t.Fatalf("understaffed filter: got %v", ids)
+	}
+	if ids := list("min_age=24h&team_name=listing"); len(ids) != 0 {
+		t.Fatalf("min_age filter: got %v", ids)
+	}
+}
+
+func TestPRCreateBatch_FairAndPerItem(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	teamBody := `{"team_name":"batch","members":[{"user_id":"b0","username":"Author","is_active":true},{"user_id":"b1","username":"A","is_active":true},{"user_id":"b2","username":"B","is_active":true},{"user_id":"b3","username":"C","is_active":true},{"user_id":"b4","username":"D","is_active":true}]}`
+	res, err := http.Post(srv.URL+"/team/add", "application/json", strings.NewReader(teamBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("team add status %d", res.StatusCode)
+	}
+	res, err = http.Post(srv.URL+"/team/settings", "application/json", strings.NewReader(`{"team_name":"batch","assignment_strategy":"least_loaded"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("team settings status %d", res.StatusCode)
+	}
+	res, err = http.Post(srv.URL+"/pullRequest/create", "application/json", strings.NewReader(`{"pull_request_id":"batch-0","pull_request_name":"PR","author_id":"b0"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var single struct {
+		PR struct {
+			Reviewers []string `json:"assigned_reviewers"`
+		} `json:"pr"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&single); err != nil || res.StatusCode != http.StatusCreated {
+		t.Fatalf("single create status %d: %v", res.StatusCode, err)
+	}
+
+	// The open review of batch-0 counts: two of the four start with one
+	// review, and least_loaded evens them out over the 18 batch assignments.
+	var items []string
+	for i := 0; i < 9; i++ {
+		items = append(items, `{"pull_request_id":"batch-`+string(rune('a'+i))+`","pull_request_name":"PR","author_id":"b0"}`)
+	}
+	items = append(items, `{"pull_request_id":"batch-a","pull_request_name":"dup","author_id":"b0"}`)
+	items = append(items, `{"pull_request_id":"batch-x","pull_request_name":"ghost","author_id":"nobody"}`)
+	body := `{"pull_requests":[` + strings.Join(items, ",") + `]}`
+	res, err = http.Post(srv.URL+"/pullRequest/createBatch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("batch status %d", res.StatusCode)
+	}
+	var out struct {
+		Results []struct {
+			ID string `json:"pull_request_id"`
+			PR *struct {
+				Reviewers []string `json:"assigned_reviewers"`
+			} `json:"pr"`
+			Error *struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		} `json:"results"`
+		Created int `json:"created"`
+		Failed  int `json:"failed"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Created != 9 || out.Failed != 2 {
+		t.Fatalf("created=%d failed=%d", out.Created, out.Failed)
+	}
+	if e := out.Results[9].Error; e == nil || e.Code != "PR_EXISTS" {
+		t.Fatalf("duplicate item: %+v", out.Results[9])
+	}
+	if e := out.Results[10].Error; e == nil || e.Code != "NOT_FOUND" {
+		t.Fatalf("unknown author item: %+v", out.Results[10])
+	}
+
+	load := map[string]int{}
+	for _, u := range single.PR.Reviewers {
+		load[u]++
+	}
+	for _, r := range out.Results[:9] {
+		for _, u := range r.PR.Reviewers {
+			load[u]++
+		}
+	}
+	for _, u := range []string{"b1", "b2", "b3", "b4"} {
+		if load[u] != 5 {
+			t.Fatalf("unfair distribution: %v", load)
+		}
+	}
+}
+
+func TestMultiTeamMembership(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	post := func(path, body string, want int) {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+
+	post("/team/add", `{"team_name":"product","members":[{"user_id":"m1","username":"Alice","is_active":true},{"user_id":"m2","username":"Bob","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"guild","members":[{"user_id":"m2","username":"Bob","is_active":true},{"user_id":"m3","username":"Carol","is_active":true}]}`, http.StatusCreated)
+
+	res, err := http.Get(srv.URL + "/users/get?user_id=m2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		User struct {
+			TeamName string `json:"team_name"`
+			Teams    []struct {
+				TeamName string `json:"team_name"`
+			} `json:"teams"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got.User.TeamName != "product" || len(got.User.Teams) != 2 {
+		t.Fatalf("user m2: %+v", got.User)
+	}
+
+	// Deactivating the guild must not take m2 out of the product reviewer pool.
+	post("/team/deactivateUsers", `{"team_name":"guild"}`, http.StatusOK)
+	res, err = http.Post(srv.URL+"/pullRequest/create", "application/json", strings.NewReader(`{"pull_request_id":"mt-1","pull_request_name":"x","author_id":"m1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		PR struct {
+			TeamName  string   `json:"team_name"`
+			Reviewers []string `json:"assigned_reviewers"`
+		} `json:"pr"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if created.PR.TeamName != "product" || len(created.PR.Reviewers) != 1 || created.PR.Reviewers[0] != "m2" {
+		t.Fatalf("pr: %+v", created.PR)
+	}
+
+	// An explicit target team picks reviewers from that team only.
+	post("/team/addMembers", `{"team_name":"guild","members":[{"user_id":"m4","username":"Dan","is_active":true}]}`, http.StatusOK)
+	res, err = http.Post(srv.URL+"/pullRequest/create", "application/json", strings.NewReader(`{"pull_request_id":"mt-2","pull_request_name":"y","author_id":"m1","team_name":"guild"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if created.PR.TeamName != "guild" || len(created.PR.Reviewers) != 1 || created.PR.Reviewers[0] != "m4" {
+		t.Fatalf("pr: %+v", created.PR)
+	}
+}
+
+func TestTeamMembershipChanges(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+	ctx := context.Background()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	reviewers := func(prID string) []string {
+		t.Helper()
+		rows, err := pool.Query(ctx, `SELECT user_id FROM pr_reviewers WHERE pull_request_id=$1 ORDER BY user_id`, prID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	counts := func(out map[string]any, reassigned, removed int) {
+		t.Helper()
+		if out["reassigned"] != float64(reassigned) || out["removed"] != float64(removed) {
+			t.Fatalf("got %v, want reassigned=%d removed=%d", out, reassigned, removed)
+		}
+	}
+
+	// removeMembers: alpha's PR starts with a2 and a3 as reviewers.
+	post("/team/add", `{"team_name":"alpha","members":[{"user_id":"a1","username":"A1","is_active":true},{"user_id":"a2","username":"A2","is_active":true},{"user_id":"a3","username":"A3","is_active":true}]}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"tm-1","pull_request_name":"x","author_id":"a1"}`, http.StatusCreated)
+	post("/team/addMembers", `{"team_name":"alpha","members":[{"user_id":"a4","username":"A4","is_active":true}]}`, http.StatusOK)
+	counts(post("/team/removeMembers", `{"team_name":"alpha","user_ids":["a2"],"review_policy":"keep"}`, http.StatusOK), 0, 0)
+	if got := reviewers("tm-1"); !slices.Equal(got, []string{"a2", "a3"}) {
+		t.Fatalf("keep: reviewers %v", got)
+	}
+	counts(post("/team/removeMembers", `{"team_name":"alpha","user_ids":["a3"]}`, http.StatusOK), 1, 0)
+	if got := reviewers("tm-1"); !slices.Equal(got, []string{"a2", "a4"}) {
+		t.Fatalf("reassign_old: reviewers %v", got)
+	}
+	counts(post("/team/removeMembers", `{"team_name":"alpha","user_ids":["a4"],"review_policy":"reassign_old"}`, http.StatusOK), 0, 1)
+	if got := reviewers("tm-1"); !slices.Equal(got, []string{"a2"}) {
+		t.Fatalf("reassign_old without candidates: reviewers %v", got)
+	}
+
+	// moveMember: gamma's PR starts with g2 and g3 as reviewers.
+	post("/team/add", `{"team_name":"gamma","members":[{"user_id":"g1","username":"G1","is_active":true},{"user_id":"g2","username":"G2","is_active":true},{"user_id":"g3","username":"G3","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"delta","members":[{"user_id":"d1","username":"D1","is_active":true}]}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"tm-2","pull_request_name":"x","author_id":"g1"}`, http.StatusCreated)
+	post("/team/addMembers", `{"team_name":"gamma","members":[{"user_id":"g4","username":"G4","is_active":true}]}`, http.StatusOK)
+	out := post("/team/moveMember", `{"user_id":"g2","team_name":"delta"}`, http.StatusOK)
+	counts(out, 0, 0)
+	if team := out["user"].(map[string]any)["team_name"]; team != "delta" {
+		t.Fatalf("primary team after move: %v", team)
+	}
+	if got := reviewers("tm-2"); !slices.Equal(got, []string{"g2", "g3"}) {
+		t.Fatalf("keep: reviewers %v", got)
+	}
+	counts(post("/team/moveMember", `{"user_id":"g3","from_team_name":"gamma","team_name":"delta","review_policy":"reassign_old"}`, http.StatusOK), 1, 0)
+	if got := reviewers("tm-2"); !slices.Equal(got, []string{"g2", "g4"}) {
+		t.Fatalf("reassign_old: reviewers %v", got)
+	}
+	counts(post("/team/moveMember", `{"user_id":"g4","team_name":"delta","review_policy":"reassign_new"}`, http.StatusOK), 1, 0)
+	if got := reviewers("tm-2"); len(got) != 2 || !slices.Contains(got, "g2") || !slices.Contains(got, "d1") && !slices.Contains(got, "g3") {
+		t.Fatalf("reassign_new: reviewers %v", got)
+	}
+
+	// Every review the policies moved or dropped is published.
+	rows, err := pool.Query(ctx, `SELECT payload::jsonb->'data'->>'old_user_id', payload::jsonb->'data'->>'replaced_by'
+        FROM outbox WHERE event_type=$1 ORDER BY outbox_id`, domain.EventPRReassigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for rows.Next() {
+		var old, by string
+		if err := rows.Scan(&old, &by); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, old+">"+by)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 || events[0] != "a3>a4" || events[1] != "a4>" || events[2] != "g3>g4" || !strings.HasPrefix(events[3], "g4>") {
+		t.Fatalf("reassigned events: %v", events)
+	}
+
+	// Renaming cascades to PRs, memberships and primary teams.
+	post("/team/rename", `{"team_name":"gamma","new_team_name":"omega"}`, http.StatusOK)
+	var prTeam, userTeam string
+	var members int
+	if err := pool.QueryRow(ctx, `SELECT
+            (SELECT team_name FROM pull_requests WHERE pull_request_id='tm-2'),
+            (SELECT team_name FROM users WHERE user_id='g1'),
+            (SELECT count(*) FROM team_members WHERE team_name='omega')`).Scan(&prTeam, &userTeam, &members); err != nil {
+		t.Fatal(err)
+	}
+	if prTeam != "omega" || userTeam != "omega" || members != 1 {
+		t.Fatalf("after rename: pr team %q, user team %q, %d members", prTeam, userTeam, members)
+	}
+
+	// Only empty teams without sub-teams can be deleted.
+	errCode := func(out map[string]any) any { return out["error"].(map[string]any)["code"] }
+	if out := post("/team/delete", `{"team_name":"omega"}`, http.StatusConflict); errCode(out) != string(domain.ErrTeamNotEmpty) {
+		t.Fatalf("delete with members: %v", out)
+	}
+	post("/team/add", `{"team_name":"parent"}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"child"}`, http.StatusCreated)
+	post("/team/setParent", `{"team_name":"child","parent_team_name":"parent"}`, http.StatusOK)
+	if out := post("/team/delete", `{"team_name":"parent"}`, http.StatusConflict); errCode(out) != string(domain.ErrTeamHasChildren) {
+		t.Fatalf("delete with sub-teams: %v", out)
+	}
+	post("/team/delete", `{"team_name":"child"}`, http.StatusOK)
+	post("/team/delete", `{"team_name":"parent"}`, http.StatusOK)
+	post("/team/delete", `{"team_name":"parent"}`, http.StatusNotFound)
+}
+
+func TestTeamHierarchy(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	call := func(method, path, body string, want int, out any) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		raw, _ := io.ReadAll(res.Body)
+		if res.StatusCode != want {
+			t.Fatalf("%s %s status %d, want %d: %s", method, path, res.StatusCode, want, raw)
+		}
+		if out != nil {
+			if err := json.Unmarshal(raw, out); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+	}
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		out := map[string]any{}
+		call(http.MethodPost, path, body, want, &out)
+		return out
+	}
+	reviewersOf := func(out map[string]any) []any { return out["pr"].(map[string]any)["assigned_reviewers"].([]any) }
+
+	// org → dept → squad, plus solo under org.
+	post("/team/add", `{"team_name":"org","members":[{"user_id":"o1","username":"O1","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"dept","members":[{"user_id":"d1","username":"D1","is_active":true},{"user_id":"d2","username":"D2","is_active":true},{"user_id":"d3","username":"D3","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"squad","members":[{"user_id":"s1","username":"S1","is_active":true},{"user_id":"s2","username":"S2","is_active":true},{"user_id":"s3","username":"S3","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"solo","members":[{"user_id":"x1","username":"X1","is_active":true}]}`, http.StatusCreated)
+	post("/team/setParent", `{"team_name":"dept","parent_team_name":"org"}`, http.StatusOK)
+	post("/team/setParent", `{"team_name":"squad","parent_team_name":"dept"}`, http.StatusOK)
+	post("/team/setParent", `{"team_name":"solo","parent_team_name":"org"}`, http.StatusOK)
+
+	// Cycles are rejected.
+	for _, body := range []string{`{"team_name":"org","parent_team_name":"squad"}`, `{"team_name":"squad","parent_team_name":"squad"}`} {
+		if out := post("/team/setParent", body, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrTeamCycle) {
+			t.Fatalf("setParent %s: %v", body, out)
+		}
+	}
+
+	var tree struct {
+		Teams []domain.TeamNode `json:"teams"`
+	}
+	var render func(nodes []domain.TeamNode) string
+	render = func(nodes []domain.TeamNode) string {
+		parts := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			parts = append(parts, n.TeamName+"<"+n.Parent+">("+render(n.Children)+")")
+		}
+		return strings.Join(parts, ",")
+	}
+	call(http.MethodGet, "/team/tree", "", http.StatusOK, &tree)
+	if got, want := render(tree.Teams), "org<>(dept<org>(squad<dept>()),solo<org>())"; got != want {
+		t.Fatalf("tree %s, want %s", got, want)
+	}
+	call(http.MethodGet, "/team/tree?team_name=dept", "", http.StatusOK, &tree)
+	if got, want := render(tree.Teams), "dept<org>(squad<dept>())"; got != want {
+		t.Fatalf("subtree %s, want %s", got, want)
+	}
+
+	// Settings are inherited from the nearest ancestor that sets them.
+	post("/team/settings", `{"team_name":"org","reviewer_count":1,"merge_policy":"require_reviewers"}`, http.StatusOK)
+	post("/team/settings", `{"team_name":"dept","assignment_strategy":"least_loaded"}`, http.StatusOK)
+	var settings struct {
+		Parent    string                       `json:"parent_team_name"`
+		Settings  domain.TeamSettings          `json:"settings"`
+		Effective domain.EffectiveTeamSettings `json:"effective"`
+	}
+	call(http.MethodGet, "/team/settings?team_name=squad", "", http.StatusOK, &settings)
+	want := domain.EffectiveTeamSettings{ReviewerCount: 1, Strategy: domain.StrategyLeastLoaded, MergePolicy: domain.MergeRequireReviewers}
+	if settings.Parent != "dept" || settings.Settings != (domain.TeamSettings{}) || settings.Effective != want {
+		t.Fatalf("squad settings: %+v", settings)
+	}
+
+	// reviewer_count 1 from org, least_loaded from dept: the second squad PR
+	// goes to the squad member the first one skipped.
+	first := reviewersOf(post("/pullRequest/create", `{"pull_request_id":"sq-1","pull_request_name":"x","author_id":"s1"}`, http.StatusCreated))
+	second := reviewersOf(post("/pullRequest/create", `{"pull_request_id":"sq-2","pull_request_name":"x","author_id":"s1"}`, http.StatusCreated))
+	if len(first) != 1 || len(second) != 1 || first[0] == second[0] {
+		t.Fatalf("squad reviewers %v then %v", first, second)
+	}
+	if got := reviewersOf(post("/pullRequest/create", `{"pull_request_id":"dp-1","pull_request_name":"x","author_id":"d1"}`, http.StatusCreated)); len(got) != 1 {
+		t.Fatalf("dept reviewers %v", got)
+	}
+	// require_reviewers from org blocks a PR nobody could review.
+	post("/pullRequest/create", `{"pull_request_id":"solo-1","pull_request_name":"x","author_id":"x1"}`, http.StatusCreated)
+	if out := post("/pullRequest/merge", `{"pull_request_id":"solo-1"}`, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrMergeBlocked) {
+		t.Fatalf("merge understaffed PR: %v", out)
+	}
+	post("/pullRequest/merge", `{"pull_request_id":"sq-1"}`, http.StatusOK)
+
+	// own counts PRs targeting the team, total adds its subtree.
+	var stats struct {
+		Teams []domain.TeamStats `json:"teams"`
+	}
+	call(http.MethodGet, "/stats/teams", "", http.StatusOK, &stats)
+	got := map[string]domain.TeamStats{}
+	for _, st := range stats.Teams {
+		got[st.TeamName] = st
+	}
+	part := func(open, merged, assignments int) domain.TeamStatsPart {
+		return domain.TeamStatsPart{OpenPRs: open, MergedPRs: merged, Assignments: assignments}
+	}
+	for _, w := range []domain.TeamStats{
+		{TeamName: "org", Own: part(0, 0, 0), Total: part(3, 1, 3)},
+		{TeamName: "dept", Own: part(1, 0, 1), Total: part(2, 1, 3)},
+		{TeamName: "squad", Own: part(1, 1, 2), Total: part(1, 1, 2)},
+		{TeamName: "solo", Own: part(1, 0, 0), Total: part(1, 0, 0)},
+	} {
+		if got[w.TeamName] != w {
+			t.Fatalf("stats for %s: %+v, want %+v", w.TeamName, got[w.TeamName], w)
+		}
+	}
+	call(http.MethodGet, "/stats/teams?team_name=dept", "", http.StatusOK, &stats)
+	if len(stats.Teams) != 2 || stats.Teams[0].TeamName != "dept" || stats.Teams[1].TeamName != "squad" {
+		t.Fatalf("dept subtree stats: %+v", stats.Teams)
+	}
+
+	// A recursive deactivation covers dept and squad but not org or solo.
+	// Nobody is left to take over, so both open reviews are dropped.
+	counts := post("/team/deactivateUsers", `{"team_name":"dept","recursive":true}`, http.StatusOK)
+	if counts["reassigned"] != float64(0) || counts["removed"] != float64(2) {
+		t.Fatalf("recursive deactivation: %v", counts)
+	}
+	for team, active := range map[string]bool{"org": true, "dept": false, "squad": false, "solo": true} {
+		var tm domain.Team
+		call(http.MethodGet, "/team/get?team_name="+team, "", http.StatusOK, &tm)
+		for _, m := range tm.Members {
+			if m.IsActive != active {
+				t.Fatalf("%s after deactivating dept: %+v", team, tm.Members)
+			}
+		}
+	}
+	for _, id := range []string{"sq-2", "dp-1"} {
+		var n int
+		if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM pr_reviewers WHERE pull_request_id=$1`, id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("%s still has %d reviewers", id, n)
+		}
+	}
+}
+
+func TestAuth_Tokens(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithAuth(auth.Config{Enabled: true, BootstrapToken: "boot"})))
+	defer srv.Close()
+
+	do := func(method, path, token, body string, want int) map[string]any {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s %s status %d, want %d", method, path, res.StatusCode, want)
+		}
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return out
+	}
+
+	res, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("healthz status %d", res.StatusCode)
+	}
+	do(http.MethodGet, "/team/tree", "", "", http.StatusUnauthorized)
+	do(http.MethodGet, "/team/tree", "prs_bogus", "", http.StatusUnauthorized)
+
+	do(http.MethodPost, "/team/add", "boot", `{"team_name":"auth","members":[{"user_id":"a1","username":"Alice","is_active":true},{"user_id":"a2","username":"Bob","is_active":true}]}`, http.StatusCreated)
+	issued := do(http.MethodPost, "/auth/tokens", "boot", `{"name":"alice laptop","user_id":"a1"}`, http.StatusCreated)
+	alice, _ := issued["token"].(string)
+	if !strings.HasPrefix(alice, auth.TokenPrefix) {
+		t.Fatalf("token: %v", issued)
+	}
+	tokenID := issued["token_info"].(map[string]any)["token_id"]
+
+	who := do(http.MethodGet, "/auth/whoami", alice, "", http.StatusOK)
+	if who["actor"] != "user:a1" {
+		t.Fatalf("whoami: %v", who)
+	}
+	do(http.MethodGet, "/auth/tokens", alice, "", http.StatusForbidden)
+
+	do(http.MethodPost, "/pullRequest/create", alice, `{"pull_request_id":"au-1","pull_request_name":"x","author_id":"a1"}`, http.StatusCreated)
+	merged := do(http.MethodPost, "/pullRequest/merge", alice, `{"pull_request_id":"au-1"}`, http.StatusOK)
+	if pr, _ := merged["pr"].(map[string]any); pr["merged_by"] != "user:a1" {
+		t.Fatalf("merged_by: %v", merged)
+	}
+
+	do(http.MethodPost, "/auth/tokens/revoke", "boot", fmt.Sprintf(`{"token_id":%v}`, tokenID), http.StatusOK)
+	do(http.MethodGet, "/auth/whoami", alice, "", http.StatusUnauthorized)
+}
+
+func TestRBAC_RolesPerEndpoint(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithAuth(auth.Config{Enabled: true, BootstrapToken: "boot"})))
+	defer srv.Close()
+
+	call := func(token, method, path, body string) (int, map[string]any) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+	must := func(method, path, body string, want int) map[string]any {
+		t.Helper()
+		code, out := call("boot", method, path, body)
+		if code != want {
+			t.Fatalf("%s %s status %d, want %d: %v", method, path, code, want, out)
+		}
+		return out
+	}
+
+	// dept is led by "lead"; core is its sub-team.
+	must(http.MethodPost, "/team/add", `{"team_name":"dept","members":[{"user_id":"lead","username":"Lead","is_active":true,"role":"lead"}]}`, http.StatusCreated)
+	must(http.MethodPost, "/team/add", `{"team_name":"core","members":[{"user_id":"mem","username":"Mem","is_active":true},{"user_id":"other","username":"Other","is_active":true}]}`, http.StatusCreated)
+	must(http.MethodPost, "/team/setParent", `{"team_name":"core","parent_team_name":"dept"}`, http.StatusOK)
+	must(http.MethodPost, "/pullRequest/create", `{"pull_request_id":"rb-seed","pull_request_name":"seed","author_id":"mem"}`, http.StatusCreated)
+	must(http.MethodPost, "/pullRequest/create", `{"pull_request_id":"rb-open","pull_request_name":"open","author_id":"other"}`, http.StatusCreated)
+
+	tokens := map[domain.Role]string{}
+	for role, principal := range map[domain.Role]string{
+		domain.RoleAdmin:    `"service_account":"ops"`,
+		domain.RoleTeamLead: `"user_id":"lead"`,
+		domain.RoleMember:   `"user_id":"mem"`,
+		domain.RoleBot:      `"service_account":"dashboard"`,
+	} {
+		out := must(http.MethodPost, "/auth/tokens", fmt.Sprintf(`{"name":"%s",%s,"role":"%s"}`, role, principal, role), http.StatusCreated)
+		tokens[role] = out["token"].(string)
+	}
+
+	all := []domain.Role{domain.RoleAdmin, domain.RoleTeamLead, domain.RoleMember, domain.RoleBot}
+	admin := []domain.Role{domain.RoleAdmin}
+	leads := []domain.Role{domain.RoleAdmin, domain.RoleTeamLead}
+	writers := []domain.Role{domain.RoleAdmin, domain.RoleTeamLead, domain.RoleMember}
+
+	cases := []struct {
+		method, path, body string
+		allowed            []domain.Role
+	}{
+		{http.MethodGet, "/team/get?team_name=core", "", all},
+		{http.MethodGet, "/team/tree", "", all},
+		{http.MethodGet, "/team/settings?team_name=core", "", all},
+		{http.MethodGet, "/users/get?user_id=mem", "", all},
+		{http.MethodGet, "/users/getReview?user_id=mem", "", all},
+		{http.MethodGet, "/pullRequest/list", "", all},
+		{http.MethodGet, "/stats/assignments", "", all},
+		{http.MethodGet, "/stats/teams", "", all},
+		{http.MethodGet, "/auth/whoami", "", all},
+		{http.MethodPost, "/team/add", `{"team_name":"new-%s"}`, admin},
+		{http.MethodPost, "/team/addMembers", `{"team_name":"core","members":[{"user_id":"new","username":"New","is_active":true}]}`, leads},
+		{http.MethodPost, "/team/removeMembers", `{"team_name":"core","user_ids":["nobody"]}`, leads},
+		{http.MethodPost, "/team/moveMember", `{"user_id":"other","from_team_name":"core","team_name":"core"}`, leads},
+		{http.MethodPost, "/team/rename", `{"team_name":"core","new_team_name":"dept"}`, leads},
+		{http.MethodPost, "/team/delete", `{"team_name":"core"}`, leads},
+		{http.MethodPost, "/team/setParent", `{"team_name":"core","parent_team_name":"dept"}`, leads},
+		{http.MethodPost, "/team/settings", `{"team_name":"core"}`, leads},
+		{http.MethodPost, "/users/setIsActive", `{"user_id":"other","team_name":"core","is_active":true}`, leads},
+		{http.MethodPost, "/users/setIsActive", `{"user_id":"other","is_active":true}`, admin},
+		{http.MethodPost, "/pullRequest/create", `{"pull_request_id":"rb-%s","pull_request_name":"x","author_id":"mem"}`, writers},
+		{http.MethodPost, "/pullRequest/createBatch", `{"pull_requests":[{"pull_request_id":"rb-batch-%s","pull_request_name":"x","author_id":"mem"}]}`, writers},
+		{http.MethodPost, "/pullRequest/merge", `{"pull_request_id":"rb-seed"}`, writers},
+		{http.MethodPost, "/pullRequest/reassign", `{"pull_request_id":"rb-open","old_user_id":"mem"}`, writers},
+		{http.MethodPost, "/pullRequest/reassign", `{"pull_request_id":"rb-open","old_user_id":"other"}`, leads},
+		{http.MethodGet, "/auth/tokens", "", admin},
+		{http.MethodPost, "/auth/tokens/revoke", `{"token_id":999999}`, admin},
+		{http.MethodPost, "/team/deactivateUsers", `{"team_name":"core"}`, leads},
+	}
+	// Admin goes last so its changes cannot turn a later check into a 404.
+	for _, role := range []domain.Role{domain.RoleBot, domain.RoleMember, domain.RoleTeamLead, domain.RoleAdmin} {
+		for _, c := range cases {
+			body := c.body
+			if strings.Contains(body, "%s") {
+				body = fmt.Sprintf(body, role)
+			}
+			code, out := call(tokens[role], c.method, c.path, body)
+			allowed := false
+			for _, r := range c.allowed {
+				allowed = allowed || r == role
+			}
+			if allowed && code == http.StatusForbidden {
+				t.Errorf("%s %s %s: forbidden for %s", c.method, c.path, body, role)
+			}
+			if !allowed && code != http.StatusForbidden {
+				t.Errorf("%s %s %s: status %d for %s, want 403 (%v)", c.method, c.path, body, code, role, out)
+			}
+		}
+	}
+
+	// Deleting a team says whether members or sub-teams are in the way.
+	if out := must(http.MethodPost, "/team/delete", `{"team_name":"dept"}`, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrTeamNotEmpty) {
+		t.Fatalf("delete with members: %v", out)
+	}
+	must(http.MethodPost, "/team/add", `{"team_name":"hollow"}`, http.StatusCreated)
+	must(http.MethodPost, "/team/add", `{"team_name":"hollow-sub"}`, http.StatusCreated)
+	must(http.MethodPost, "/team/setParent", `{"team_name":"hollow-sub","parent_team_name":"hollow"}`, http.StatusOK)
+	if out := must(http.MethodPost, "/team/delete", `{"team_name":"hollow"}`, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrTeamHasChildren) {
+		t.Fatalf("delete with sub-teams: %v", out)
+	}
+
+	// Opposite moves racing each other cannot both pass the cycle check.
+	must(http.MethodPost, "/team/add", `{"team_name":"loop-a"}`, http.StatusCreated)
+	must(http.MethodPost, "/team/add", `{"team_name":"loop-b"}`, http.StatusCreated)
+	codes := make(chan int, 2)
+	for _, body := range []string{`{"team_name":"loop-a","parent_team_name":"loop-b"}`, `{"team_name":"loop-b","parent_team_name":"loop-a"}`} {
+		// call may t.Fatal, which only the test goroutine may do.
+		go func() {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/team/setParent", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer boot")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				codes <- 0
+				return
+			}
+			res.Body.Close()
+			codes <- res.StatusCode
+		}()
+	}
+	if a, b := <-codes, <-codes; a+b != http.StatusOK+http.StatusConflict {
+		t.Fatalf("racing moves: %d and %d, want one 200 and one 409", a, b)
+	}
+	must(http.MethodGet, "/team/tree", "", http.StatusOK)
+
+	// A member's batch items for other authors fail individually.
+	code, out := call(tokens[domain.RoleMember], http.MethodPost, "/pullRequest/createBatch",
+		`{"pull_requests":[{"pull_request_id":"rb-own","pull_request_name":"x","author_id":"mem"},{"pull_request_id":"rb-foreign","pull_request_name":"x","author_id":"other"}]}`)
+	if code != http.StatusOK || out["created"] != float64(1) || out["failed"] != float64(1) {
+		t.Fatalf("member batch: %d %v", code, out)
+	}
+
+	// A lead creates PRs for members of the teams they lead, but not for
+	// anyone else. Being a plain member of a team does not make one its lead.
+	must(http.MethodPost, "/team/add", `{"team_name":"outside","members":[{"user_id":"out","username":"Out","is_active":true}]}`, http.StatusCreated)
+	must(http.MethodPost, "/team/addMembers", `{"team_name":"outside","members":[{"user_id":"lead","username":"Lead","is_active":true}]}`, http.StatusOK)
+	user := must(http.MethodGet, "/users/get?user_id=lead", "", http.StatusOK)["user"].(map[string]any)
+	for _, m := range user["teams"].([]any) {
+		m := m.(map[string]any)
+		if want := map[string]string{"dept": "lead", "outside": "member"}[m["team_name"].(string)]; m["role"] != want {
+			t.Fatalf("lead's memberships: %v", user["teams"])
+		}
+	}
+	if code, out := call(tokens[domain.RoleTeamLead], http.MethodPost, "/pullRequest/create", `{"pull_request_id":"rb-lead-out","pull_request_name":"x","author_id":"out"}`); code != http.StatusForbidden {
+		t.Fatalf("lead creating for an outsider: %d %v", code, out)
+	}
+	code, out = call(tokens[domain.RoleTeamLead], http.MethodPost, "/pullRequest/createBatch",
+		`{"pull_requests":[{"pull_request_id":"rb-lead-mem","pull_request_name":"x","author_id":"mem"},{"pull_request_id":"rb-lead-out","pull_request_name":"x","author_id":"out"}]}`)
+	if code != http.StatusOK || out["created"] != float64(1) || out["failed"] != float64(1) {
+		t.Fatalf("lead batch: %d %v", code, out)
+	}
+
+	// A lead adding an existing user only decides the membership in their
+	// team; the user's name and global activity stay as they were.
+	if code, out := call(tokens[domain.RoleTeamLead], http.MethodPost, "/team/addMembers", `{"team_name":"core","members":[{"user_id":"out","username":"Renamed","is_active":false}]}`); code != http.StatusOK {
+		t.Fatalf("lead adding an existing user: %d %v", code, out)
+	}
+	user = must(http.MethodGet, "/users/get?user_id=out", "", http.StatusOK)["user"].(map[string]any)
+	if user["username"] != "Out" || user["is_active"] != true || user["team_name"] != "outside" {
+		t.Fatalf("existing user changed by addMembers: %v", user)
+	}
+	var coreMembership map[string]any
+	for _, m := range user["teams"].([]any) {
+		if m := m.(map[string]any); m["team_name"] == "core" {
+			coreMembership = m
+		}
+	}
+	if coreMembership == nil || coreMembership["is_active"] != false {
+		t.Fatalf("membership in core: %v", user["teams"])
+	}
+
+	// moveMember authorizes before it looks anything up, so missing users and
+	// teams cannot be probed.
+	for role, body := range map[domain.Role]string{
+		domain.RoleMember:   `{"user_id":"ghost","team_name":"no-such-team"}`,
+		domain.RoleTeamLead: `{"user_id":"ghost","team_name":"outside"}`,
+	} {
+		if code, out := call(tokens[role], http.MethodPost, "/team/moveMember", body); code != http.StatusForbidden {
+			t.Fatalf("%s probing with moveMember: %d %v", role, code, out)
+		}
+	}
+}
+
+func TestAuth_JWTAgainstJWKS(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	rsaJWK := map[string]string{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())}
+	ecJWK := func(kid string, k *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	}
+
+	var mu sync.Mutex
+	published := []map[string]string{rsaJWK, ecJWK("ec-1", ecKey)}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": published})
+	}))
+	defer jwks.Close()
+
+	cfg := auth.Config{Enabled: true, BootstrapToken: "boot", JWT: auth.JWTConfig{
+		JWKSURL:  jwks.URL,
+		Issuer:   "https://sso.example.com",
+		Audience: "pr-service",
+		RoleMap:  map[string]domain.Role{"pr-admins": domain.RoleAdmin},
+	}}
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithAuth(cfg)))
+	defer srv.Close()
+
+	sign := func(method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+		t.Helper()
+		base := jwt.MapClaims{"iss": "https://sso.example.com", "aud": "pr-service", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range claims {
+			base[k] = v
+		}
+		tok := jwt.NewWithClaims(method, base)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	whoami := func(token string) (int, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/auth/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+
+	code, out := whoami(sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u1"}))
+	if code != http.StatusOK || out["actor"] != "user:u1" || out["role"] != string(domain.RoleMember) {
+		t.Fatalf("RS256 member: %d %v", code, out)
+	}
+	code, out = whoami(sign(jwt.SigningMethodES256, "ec-1", ecKey, jwt.MapClaims{"sub": "u2", "roles": []string{"staff", "pr-admins"}}))
+	if code != http.StatusOK || out["role"] != string(domain.RoleAdmin) {
+		t.Fatalf("ES256 mapped admin: %d %v", code, out)
+	}
+	// With a role map, a raw role name outside it grants nothing.
+	code, out = whoami(sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u2", "roles": []string{"admin", "team_lead"}}))
+	if code != http.StatusOK || out["role"] != string(domain.RoleMember) {
+		t.Fatalf("unmapped admin: %d %v", code, out)
+	}
+
+	rejected := map[string]string{
+		"expired":        sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u1", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong audience": sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u1", "aud": "other-service"}),
+		"wrong issuer":   sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u1", "iss": "https://evil.example.com"}),
+		"wrong key":      sign(jwt.SigningMethodES256, "ec-1", rotated, jwt.MapClaims{"sub": "u1"}),
+		"no exp":         sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u1", "exp": nil}),
+		"hs256":          sign(jwt.SigningMethodHS256, "rsa-1", []byte("secret"), jwt.MapClaims{"sub": "u1"}),
+	}
+	for name, token := range rejected {
+		if code, out := whoami(token); code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401 (%v)", name, code, out)
+		}
+	}
+
+	// A key published after the cache was filled is picked up on first use.
+	mu.Lock()
+	published = append(published, ecJWK("ec-3", rotated))
+	mu.Unlock()
+	if code, out := whoami(sign(jwt.SigningMethodES256, "ec-3", rotated, jwt.MapClaims{"sub": "u3"})); code != http.StatusOK {
+		t.Fatalf("rotated key: %d %v", code, out)
+	}
+	// Unknown key ids are rejected; the reload they would trigger is rate limited.
+	if code, out := whoami(sign(jwt.SigningMethodES256, "ec-2", rotated, jwt.MapClaims{"sub": "u1"})); code != http.StatusUnauthorized {
+		t.Fatalf("unknown key: %d %v", code, out)
+	}
+
+	// The same set also works from a file.
+	raw, err := json.Marshal(map[string]any{"keys": []map[string]string{rsaJWK}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v := auth.NewJWTValidator(auth.JWTConfig{JWKSFile: file, Audience: "pr-service"})
+	actor, err := v.Validate(context.Background(), sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u4", "roles": "team_lead"}))
+	if err != nil || actor.UserID != "u4" || actor.Role != domain.RoleTeamLead {
+		t.Fatalf("file jwks: %+v %v", actor, err)
+	}
+
+	// While the identity provider is down, the stale keys keep working and
+	// the failed refresh is not retried on every request.
+	var hits, down atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() == 1 {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{rsaJWK}})
+	}))
+	defer flaky.Close()
+	v = auth.NewJWTValidator(auth.JWTConfig{JWKSURL: flaky.URL, Audience: "pr-service", JWKSRefresh: time.Millisecond})
+	token := sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, jwt.MapClaims{"sub": "u5"})
+	if _, err := v.Validate(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(1)
+	time.Sleep(5 * time.Millisecond)
+	// Stale keys are served while the refresh runs in the background.
+	for range 5 {
+		if _, err := v.Validate(context.Background(), token); err != nil {
+			t.Fatalf("stale keys rejected: %v", err)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); hits.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for range 5 {
+		if _, err := v.Validate(context.Background(), token); err != nil {
+			t.Fatalf("stale keys rejected after failed refresh: %v", err)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("jwks fetched %d times, want the initial load and one failed refresh", n)
+	}
+
+	// A caller that gives up does not cancel the shared fetch or count as a
+	// failed reload.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{rsaJWK}})
+	}))
+	defer slow.Close()
+	v = auth.NewJWTValidator(auth.JWTConfig{JWKSURL: slow.URL, Audience: "pr-service"})
+	short, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := v.Validate(short, token); err == nil {
+		t.Fatal("validated before the keys were loaded")
+	}
+	if _, err := v.Validate(context.Background(), token); err != nil {
+		t.Fatalf("after an abandoned load: %v", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithAuth(auth.Config{Enabled: true, BootstrapToken: "boot"})))
+	defer srv.Close()
+
+	call := func(token, method, path, body string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			t.Fatal(err)
+		}
+		return res, buf.Bytes()
+	}
+	entries := func(query string) []domain.AuditEntry {
+		t.Helper()
+		res, body := call("boot", http.MethodGet, "/audit?"+query, "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("audit %s: %d %s", query, res.StatusCode, body)
+		}
+		var out struct {
+			Entries []domain.AuditEntry `json:"entries"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Entries
+	}
+
+	call("boot", http.MethodPost, "/team/add", `{"team_name":"audited","members":[{"user_id":"au1","username":"A","is_active":true},{"user_id":"au2","username":"B","is_active":true}]}`)
+	_, body := call("boot", http.MethodPost, "/auth/tokens", `{"name":"member","user_id":"au1"}`)
+	var issued struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &issued); err != nil {
+		t.Fatal(err)
+	}
+	call(issued.Token, http.MethodPost, "/team/deactivateUsers", `{"team_name":"audited"}`)
+	call("boot", http.MethodPost, "/team/deactivateUsers", `{"team_name":"audited"}`)
+	call("boot", http.MethodPost, "/pullRequest/merge", `{"pull_request_id":"missing"}`)
+
+	got := entries("action=team.deactivate_users")
+	if len(got) != 2 || got[0].Actor != "user:au1" || got[0].Result != "FORBIDDEN" ||
+		got[1].Actor != "service:bootstrap" || got[1].Result != "ok" || got[1].Target != "audited" || len(got[1].PayloadDigest) != 64 {
+		t.Fatalf("deactivate entries: %+v", got)
+	}
+	if got := entries("result=NOT_FOUND"); len(got) != 1 || got[0].Action != "pr.merge" || got[0].Target != "missing" {
+		t.Fatalf("not found entries: %+v", got)
+	}
+	if got := entries("actor=service:bootstrap&limit=1"); len(got) != 1 || got[0].Action != "team.create" {
+		t.Fatalf("limited entries: %+v", got)
+	}
+
+	// The change and its entry commit together: the deactivation really happened.
+	if res, _ := call("boot", http.MethodGet, "/team/get?team_name=audited", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("team get: %d", res.StatusCode)
+	}
+
+	res, export := call("boot", http.MethodGet, "/audit/export?from="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), "")
+	lines := strings.Split(strings.TrimSpace(string(export)), "\n")
+	if res.Header.Get("Content-Type") != "application/x-ndjson" || len(lines) != len(entries("limit=500")) {
+		t.Fatalf("export: %s %q", res.Header.Get("Content-Type"), export)
+	}
+	var first domain.AuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.Action != "team.create" {
+		t.Fatalf("export line: %q %v", lines[0], err)
+	}
+
+	if res, _ := call(issued.Token, http.MethodGet, "/audit", ""); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("member audit access: %d", res.StatusCode)
+	}
+	if _, err := pool.Exec(context.Background(), `DELETE FROM audit_log`); err == nil {
+		t.Fatal("audit_log accepted a DELETE")
+	}
+}
+
+func TestWebhooks_DeliveryRetryAndDeadLetter(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	const secret = "receiver-secret-0123456789"
+	var mu sync.Mutex
+	var received []domain.Event
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhook.HeaderSignature) != webhook.Sign(secret, body) {
+			t.Errorf("bad signature for %s", body)
+		}
+		var ev domain.Event
+		if err := json.Unmarshal(body, &ev); err != nil || ev.Type != r.Header.Get(webhook.HeaderEvent) {
+			t.Errorf("bad event %s: %v", body, err)
+		}
+		mu.Lock()
+		received = append(received, ev)
+		mu.Unlock()
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := webhook.NewDispatcher(repo.New(pool), webhook.Config{
+		MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond,
+		Timeout: time.Second, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	})
+	go d.Run(ctx)
+	relay := outbox.NewRelay(repo.New(pool), []outbox.Sink{outbox.NewSubscriptionSink(repo.New(pool))}, outbox.Config{
+		BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	}, outbox.WithPublishedHook(d.Notify))
+	go relay.Run(ctx)
+
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithOutbox(relay)))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	deliveries := func(query string) []domain.WebhookDelivery {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/webhooks/deliveries?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var out struct {
+			Deliveries []domain.WebhookDelivery `json:"deliveries"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Deliveries
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	post("/webhooks/subscriptions", fmt.Sprintf(`{"url":%q,"secret":%q,"event_types":["*"]}`, good.URL, secret), http.StatusCreated)
+	badSub := post("/webhooks/subscriptions", fmt.Sprintf(`{"url":%q,"secret":%q,"event_types":["pull_request.merged"]}`, bad.URL, secret), http.StatusCreated)
+	post("/webhooks/subscriptions", `{"url":"ftp://x","secret":"0123456789abcdef","event_types":["*"]}`, http.StatusBadRequest)
+	post("/webhooks/subscriptions", fmt.Sprintf(`{"url":%q,"secret":%q,"event_types":["pr.nope"]}`, good.URL, secret), http.StatusBadRequest)
+
+	post("/team/add", `{"team_name":"hooks","members":[{"user_id":"h1","username":"A","is_active":true},{"user_id":"h2","username":"B","is_active":true},{"user_id":"h3","username":"C","is_active":true},{"user_id":"h4","username":"D","is_active":true}]}`, http.StatusCreated)
+	created := post("/pullRequest/create", `{"pull_request_id":"wh-1","pull_request_name":"x","author_id":"h1"}`, http.StatusCreated)
+	old := created["pr"].(map[string]any)["assigned_reviewers"].([]any)[0].(string)
+	post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"wh-1","old_user_id":%q}`, old), http.StatusOK)
+	post("/pullRequest/merge", `{"pull_request_id":"wh-1"}`, http.StatusOK)
+	post("/pullRequest/merge", `{"pull_request_id":"wh-1"}`, http.StatusOK)
+	post("/users/setIsActive", `{"user_id":"h2","is_active":false}`, http.StatusOK)
+	post("/team/deactivateUsers", `{"team_name":"hooks"}`, http.StatusOK)
+
+	want := []string{domain.EventPRCreated, domain.EventPRReassigned, domain.EventPRMerged, domain.EventUserActiveChanged, domain.EventTeamDeactivated}
+	waitFor("events at the good receiver", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) >= len(want)
+	})
+	mu.Lock()
+	got := make([]string, 0, len(received))
+	for _, ev := range received {
+		got = append(got, ev.Type)
+	}
+	mu.Unlock()
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("events %v, want %v (a repeated merge must not emit again)", got, want)
+	}
+
+	badID := int64(badSub["subscription"].(map[string]any)["subscription_id"].(float64))
+	q := fmt.Sprintf("subscription_id=%d", badID)
+	waitFor("dead letter", func() bool { return len(deliveries(q+"&status=dead")) == 1 })
+	dead := deliveries(q)[0]
+	if dead.Attempts != 3 || dead.LastStatusCode == nil || *dead.LastStatusCode != http.StatusInternalServerError || dead.EventType != domain.EventPRMerged {
+		t.Fatalf("dead delivery: %+v", dead)
+	}
+	post("/webhooks/deliveries/retry", fmt.Sprintf(`{"delivery_id":%d}`, dead.ID), http.StatusOK)
+	waitFor("retried delivery to die again", func() bool {
+		d := deliveries(q)
+		return d[0].Status == domain.DeliveryDead && d[0].Attempts == 3
+	})
+	if n := len(deliveries("status=delivered")); n != len(want) {
+		t.Fatalf("delivered log has %d entries, want %d", n, len(want))
+	}
+}
+
+func TestWebhooks_SlowReceiverGetsEachDeliveryOnce(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	// Each send takes most of its timeout, so a whole batch runs far past
+	// the lease of a single delivery; a second dispatcher must still not
+	// pick up deliveries the first one has yet to send.
+	const secret = "receiver-secret-0123456789"
+	var mu sync.Mutex
+	seen := map[string]int{}
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		mu.Lock()
+		seen[r.Header.Get(webhook.HeaderDelivery)]++
+		mu.Unlock()
+	}))
+	defer slow.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := webhook.Config{
+		MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond,
+		Timeout: 200 * time.Millisecond, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	}
+	for range 2 {
+		go webhook.NewDispatcher(repo.New(pool), cfg).Run(ctx)
+	}
+	relay := outbox.NewRelay(repo.New(pool), []outbox.Sink{outbox.NewSubscriptionSink(repo.New(pool))}, outbox.Config{
+		BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	})
+	go relay.Run(ctx)
+
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithOutbox(relay)))
+	defer srv.Close()
+	post := func(path, body string, want int) {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+	post("/webhooks/subscriptions", fmt.Sprintf(`{"url":%q,"secret":%q,"event_types":["pull_request.created"]}`, slow.URL, secret), http.StatusCreated)
+	post("/team/add", `{"team_name":"slow","members":[{"user_id":"s1","username":"A","is_active":true},{"user_id":"s2","username":"B","is_active":true}]}`, http.StatusCreated)
+	const prs = 8
+	for i := range prs {
+		post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":"slow-%d","pull_request_name":"x","author_id":"s1"}`, i), http.StatusCreated)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var delivered int
+		if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM webhook_deliveries WHERE status='delivered'`).Scan(&delivered); err != nil {
+			t.Fatal(err)
+		}
+		if delivered == prs {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d deliveries sent", delivered, prs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("delivery %s sent %d times", id, n)
+		}
+	}
+	if len(seen) != prs {
+		t.Fatalf("receiver saw %d deliveries, want %d", len(seen), prs)
+	}
+}
+
+// recordingSink counts what a relay published and fails the first failFirst
+// messages it sees. Each publish takes delay.
+type recordingSink struct {
+	name      string
+	mu        sync.Mutex
+	seen      map[string]int
+	failFirst int
+	delay     time.Duration
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Publish(_ context.Context, m outbox.Message) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failFirst > 0 {
+		s.failFirst--
+		return fmt.Errorf("sink unavailable")
+	}
+	s.seen[m.EventID]++
+	return nil
+}
+
+func TestOutbox_SlowSinkOutlastingTheBatchLease(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+	post := func(path, body string, want int) {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+	post("/team/add", `{"team_name":"lease","members":[{"user_id":"ls1","username":"A","is_active":true},{"user_id":"ls2","username":"B","is_active":true}]}`, http.StatusCreated)
+	const prs = 8
+	for i := range prs {
+		post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":"ls-%d","pull_request_name":"x","author_id":"ls1"}`, i), http.StatusCreated)
+	}
+
+	// A batch takes far longer than the lease, but every event is leased on
+	// its own, so the second relay never publishes one the first still holds.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := outbox.Config{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, PollInterval: 10 * time.Millisecond, BatchSize: 10, Lease: 200 * time.Millisecond}
+	sinks := []*recordingSink{
+		{name: "slow", seen: map[string]int{}, delay: 80 * time.Millisecond},
+		{name: "slow", seen: map[string]int{}, delay: 80 * time.Millisecond},
+	}
+	for _, s := range sinks {
+		go outbox.NewRelay(repo.New(pool), []outbox.Sink{s}, cfg).Run(ctx)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var pending int
+		if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM outbox WHERE sent_at IS NULL`).Scan(&pending); err != nil {
+			t.Fatal(err)
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events still pending", pending)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+
+	total := map[string]int{}
+	for _, s := range sinks {
+		s.mu.Lock()
+		for id, n := range s.seen {
+			total[id] += n
+		}
+		s.mu.Unlock()
+	}
+	if len(total) != prs {
+		t.Fatalf("published %d events, want %d", len(total), prs)
+	}
+	for id, n := range total {
+		if n != 1 {
+			t.Errorf("event %s published %d times", id, n)
+		}
+	}
+}
+
+func TestOutbox_RelaysPublishEachEventOnce(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+	post := func(path, body string, want int) {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+
+	post("/team/add", `{"team_name":"relay","members":[{"user_id":"ob1","username":"A","is_active":true},{"user_id":"ob2","username":"B","is_active":true}]}`, http.StatusCreated)
+	const prs = 40
+	for i := 0; i < prs; i++ {
+		post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":"ob-%d","pull_request_name":"x","author_id":"ob1"}`, i), http.StatusCreated)
+	}
+	// A failed change must not leave an event behind.
+	post("/pullRequest/create", `{"pull_request_id":"ob-0","pull_request_name":"x","author_id":"ob1"}`, http.StatusConflict)
+	var pending int
+	if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM outbox WHERE sent_at IS NULL`).Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending != prs {
+		t.Fatalf("outbox holds %d events, want %d", pending, prs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := outbox.Config{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, PollInterval: 10 * time.Millisecond, BatchSize: 3, Lease: time.Minute}
+	// Every relay publishes to a flaky and a steady sink; only relay 0's flaky
+	// sink actually fails, and its retries must not reach the steady sink.
+	sinks := make([]*recordingSink, 4)
+	steady := make([]*recordingSink, 4)
+	for i := range sinks {
+		sinks[i] = &recordingSink{name: "flaky", seen: map[string]int{}}
+		steady[i] = &recordingSink{name: "steady", seen: map[string]int{}}
+		if i == 0 {
+			sinks[i].failFirst = 2
+		}
+		go outbox.NewRelay(repo.New(pool), []outbox.Sink{sinks[i], steady[i]}, cfg).Run(ctx)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM outbox WHERE sent_at IS NULL`).Scan(&pending); err != nil {
+			t.Fatal(err)
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events still pending", pending)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+
+	for _, group := range [][]*recordingSink{sinks, steady} {
+		total := map[string]int{}
+		for _, s := range group {
+			s.mu.Lock()
+			for id, n := range s.seen {
+				total[id] += n
+			}
+			s.mu.Unlock()
+		}
+		if len(total) != prs {
+			t.Fatalf("%s: published %d distinct events, want %d", group[0].name, len(total), prs)
+		}
+		for id, n := range total {
+			if n != 1 {
+				t.Fatalf("%s: event %s published %d times", group[0].name, id, n)
+			}
+		}
+	}
+	var retried int
+	if err := pool.QueryRow(context.Background(), `SELECT count(*) FROM outbox WHERE attempts > 1`).Scan(&retried); err != nil {
+		t.Fatal(err)
+	}
+	if retried == 0 {
+		t.Fatal("failed publishes were not retried")
+	}
+}
+
+func TestGitHubIngestion_Fixtures(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	const secret = "gh-webhook-secret"
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithGitHub(github.Config{WebhookSecret: secret})))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	deliver := func(event, fixture, signature string, want int) map[string]any {
+		t.Helper()
+		body, err := os.ReadFile(filepath.Join("testdata", "github", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature == "" {
+			signature = webhook.Sign(secret, body)
+		}
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/github/webhook", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(github.HeaderEvent, event)
+		req.Header.Set(github.HeaderDelivery, fixture)
+		req.Header.Set(github.HeaderSignature, signature)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s: status %d, want %d: %v", fixture, res.StatusCode, want, out)
+		}
+		return out
+	}
+	prOf := func(out map[string]any) map[string]any {
+		t.Helper()
+		pr, ok := out["pr"].(map[string]any)
+		if !ok {
+			t.Fatalf("no pr in %v", out)
+		}
+		return pr
+	}
+
+	post("/team/add", `{"team_name":"gh","members":[{"user_id":"gh1","username":"Alice","is_active":true},{"user_id":"gh2","username":"Bob","is_active":true},{"user_id":"gh3","username":"Carol","is_active":true},{"user_id":"gh4","username":"Dan","is_active":false}]}`, http.StatusCreated)
+	// Only two of the three reviewers the team asks for are active, so its
+	// PRs can only be merged on GitHub, and that merge still has to land here.
+	post("/team/settings", `{"team_name":"gh","reviewer_count":3,"merge_policy":"require_reviewers"}`, http.StatusOK)
+	post("/github/accounts", `{"login":"octo-alice","user_id":"gh1"}`, http.StatusCreated)
+	post("/github/accounts", `{"login":"ghost","user_id":"nobody"}`, http.StatusNotFound)
+
+	deliver("pull_request", "pull_request_opened.json", "sha256=00", http.StatusUnauthorized)
+	deliver("ping", "ping.json", "", http.StatusOK)
+	deliver("issues", "ping.json", "", http.StatusAccepted)
+
+	opened := deliver("pull_request", "pull_request_opened.json", "", http.StatusOK)
+	pr := prOf(opened)
+	if opened["status"] != "created" || pr["pull_request_id"] != "acme/widgets#42" || pr["author_id"] != "gh1" || pr["is_draft"] != false || len(pr["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("opened: %v", opened)
+	}
+	if out := deliver("pull_request", "pull_request_opened.json", "", http.StatusOK); out["status"] != "duplicate" {
+		t.Fatalf("redelivery: %v", out)
+	}
+
+	pr = prOf(deliver("pull_request", "pull_request_opened_draft.json", "", http.StatusOK))
+	if pr["is_draft"] != true || len(pr["assigned_reviewers"].([]any)) != 0 {
+		t.Fatalf("draft PR got reviewers: %v", pr)
+	}
+	pr = prOf(deliver("pull_request", "pull_request_ready_for_review.json", "", http.StatusOK))
+	reviewers := pr["assigned_reviewers"].([]any)
+	if pr["is_draft"] != false || len(reviewers) != 2 || slices.Contains(reviewers, any("gh1")) {
+		t.Fatalf("ready for review: %v", pr)
+	}
+	pr = prOf(deliver("pull_request", "pull_request_converted_to_draft.json", "", http.StatusOK))
+	if pr["is_draft"] != true || len(pr["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("converted to draft must keep reviewers: %v", pr)
+	}
+
+	if out := post("/pullRequest/merge", `{"pull_request_id":"acme/widgets#42"}`, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrMergeBlocked) {
+		t.Fatalf("local merge below the policy: %v", out)
+	}
+	pr = prOf(deliver("pull_request", "pull_request_closed_merged.json", "", http.StatusOK))
+	if pr["status"] != string(domain.PRMerged) || pr["merged_by"] != "service:github" {
+		t.Fatalf("merged: %v", pr)
+	}
+	if pr = prOf(deliver("pull_request", "pull_request_closed_unmerged.json", "", http.StatusOK)); pr["status"] != string(domain.PRClosed) || len(pr["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("closed without merge: %v", pr)
+	}
+	post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"acme/widgets#43","old_user_id":%q}`, pr["assigned_reviewers"].([]any)[0]), http.StatusConflict)
+	if pr = prOf(deliver("pull_request", "pull_request_reopened.json", "", http.StatusOK)); pr["status"] != string(domain.PROpen) {
+		t.Fatalf("reopened: %v", pr)
+	}
+	if out := deliver("pull_request", "pull_request_opened_unlinked.json", "", http.StatusAccepted); out["status"] != "ignored" {
+		t.Fatalf("unlinked author: %v", out)
+	}
+
+	if out := deliver("pull_request_review", "pull_request_review_submitted.json", "", http.StatusAccepted); out["status"] != "ignored" {
+		t.Fatalf("review by unlinked login: %v", out)
+	}
+	post("/github/accounts", fmt.Sprintf(`{"login":"octo-reviewer","user_id":%q}`, pr["assigned_reviewers"].([]any)[0]), http.StatusCreated)
+	for range 2 {
+		if out := deliver("pull_request_review", "pull_request_review_submitted.json", "", http.StatusOK); out["status"] != "reviewed" {
+			t.Fatalf("review: %v", out)
+		}
+	}
+	var reviewed int
+	if err := pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM review_assignments WHERE pull_request_id='acme/widgets#43' AND reviewed_at IS NOT NULL`).Scan(&reviewed); err != nil || reviewed != 1 {
+		t.Fatalf("reviewed assignments: %d, %v", reviewed, err)
+	}
+
+	res, err := http.Get(srv.URL + "/pullRequest/list?author_id=gh1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var list struct {
+		PullRequests []domain.PullRequest `json:"pull_requests"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.PullRequests) != 2 {
+		t.Fatalf("ingested PRs: %+v", list.PullRequests)
+	}
+	post("/pullRequest/setDraft", `{"pull_request_id":"acme/widgets#42","is_draft":true}`, http.StatusConflict)
+	post("/github/accounts/delete", `{"login":"OCTO-ALICE"}`, http.StatusOK)
+}
+
+func TestGitLabIngestion_Fixtures(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	const token = "gl-webhook-token"
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithGitLab(gitlab.Config{WebhookToken: token})))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	deliver := func(fixture, tok string, want int) map[string]any {
+		t.Helper()
+		body, err := os.ReadFile(filepath.Join("testdata", "gitlab", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/gitlab/webhook", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(gitlab.HeaderEvent, gitlab.EventMergeRequest)
+		req.Header.Set(gitlab.HeaderUUID, fixture)
+		req.Header.Set(gitlab.HeaderToken, tok)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s: status %d, want %d: %v", fixture, res.StatusCode, want, out)
+		}
+		return out
+	}
+	prOf := func(out map[string]any) map[string]any {
+		t.Helper()
+		pr, ok := out["pr"].(map[string]any)
+		if !ok {
+			t.Fatalf("no pr in %v", out)
+		}
+		return pr
+	}
+
+	post("/team/add", `{"team_name":"gl","members":[{"user_id":"gl1","username":"Maria","is_active":true},{"user_id":"gl2","username":"Ivan","is_active":true},{"user_id":"gl3","username":"Olga","is_active":true}]}`, http.StatusCreated)
+	post("/gitlab/accounts", `{"login":"maria.k","user_id":"gl1"}`, http.StatusCreated)
+	// The team wants more reviewers than it has: its MRs can only be merged
+	// upstream, and that merge still has to be recorded.
+	post("/team/settings", `{"team_name":"gl","reviewer_count":3,"merge_policy":"require_reviewers"}`, http.StatusOK)
+
+	deliver("merge_request_open.json", "", http.StatusUnauthorized)
+	deliver("merge_request_open.json", "wrong", http.StatusUnauthorized)
+
+	opened := deliver("merge_request_open.json", token, http.StatusOK)
+	pr := prOf(opened)
+	if opened["status"] != "created" || pr["pull_request_id"] != "1357!7" || pr["author_id"] != "gl1" || len(pr["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("open: %v", opened)
+	}
+	if out := deliver("merge_request_open.json", token, http.StatusOK); out["status"] != "duplicate" {
+		t.Fatalf("redelivery: %v", out)
+	}
+	if out := deliver("merge_request_update_title.json", token, http.StatusAccepted); out["status"] != "ignored" {
+		t.Fatalf("title update: %v", out)
+	}
+
+	pr = prOf(deliver("merge_request_open_draft.json", token, http.StatusOK))
+	if pr["is_draft"] != true || len(pr["assigned_reviewers"].([]any)) != 0 {
+		t.Fatalf("draft MR: %v", pr)
+	}
+	ready := deliver("merge_request_update_ready.json", token, http.StatusOK)
+	if pr = prOf(ready); ready["status"] != "ready_for_review" || pr["is_draft"] != false || len(pr["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("ready: %v", ready)
+	}
+	if pr = prOf(deliver("merge_request_close.json", token, http.StatusOK)); pr["status"] != string(domain.PRClosed) || pr["closedAt"] == nil {
+		t.Fatalf("close: %v", pr)
+	}
+	if pr = prOf(deliver("merge_request_close.json", token, http.StatusOK)); pr["status"] != string(domain.PRClosed) {
+		t.Fatalf("repeated close: %v", pr)
+	}
+	post("/pullRequest/merge", `{"pull_request_id":"1357!8"}`, http.StatusConflict)
+	if pr = prOf(deliver("merge_request_reopen.json", token, http.StatusOK)); pr["status"] != string(domain.PROpen) || pr["closedAt"] != nil {
+		t.Fatalf("reopen: %v", pr)
+	}
+
+	if out := post("/pullRequest/merge", `{"pull_request_id":"1357!7"}`, http.StatusConflict); out["error"].(map[string]any)["code"] != string(domain.ErrMergeBlocked) {
+		t.Fatalf("local merge below the policy: %v", out)
+	}
+	if pr = prOf(deliver("merge_request_merge.json", token, http.StatusOK)); pr["status"] != string(domain.PRMerged) || pr["merged_by"] != "service:gitlab" {
+		t.Fatalf("merge: %v", pr)
+	}
+	post("/pullRequest/close", `{"pull_request_id":"1357!7"}`, http.StatusConflict)
+	if out := deliver("merge_request_open_unlinked.json", token, http.StatusAccepted); out["status"] != "ignored" {
+		t.Fatalf("unlinked author: %v", out)
+	}
+
+	res, err := http.Get(srv.URL + "/pullRequest/list?status=CLOSED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var list struct {
+		PullRequests []domain.PullRequest `json:"pull_requests"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.PullRequests) != 0 {
+		t.Fatalf("closed PRs after reopen: %+v", list.PullRequests)
+	}
+}
+
+// fakeGitHubCall is one request received by the fake GitHub API.
+type fakeGitHubCall struct {
+	Method    string
+	Path      string
+	Reviewers []string
+	Status    int
+	At        time.Time
+}
+
+func TestGitHubReviewerSync(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var calls []fakeGitHubCall
+	var resetAt time.Time
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh-api-token" {
+			t.Errorf("missing token on %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			Reviewers []string `json:"reviewers"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		status := http.StatusCreated
+		switch {
+		case len(calls) == 0:
+			status = http.StatusBadGateway
+		case r.Method == http.MethodDelete && !slices.ContainsFunc(calls, func(c fakeGitHubCall) bool { return c.Method == http.MethodDelete }):
+			resetAt = time.Unix(time.Now().Add(time.Second).Unix(), 0)
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(resetAt.Unix()))
+			status = http.StatusForbidden
+		case strings.HasSuffix(r.URL.Path, "/pulls/43/requested_reviewers"):
+			status = http.StatusUnprocessableEntity
+		case r.Method == http.MethodDelete:
+			status = http.StatusOK
+		}
+		calls = append(calls, fakeGitHubCall{Method: r.Method, Path: r.URL.Path, Reviewers: body.Reviewers, Status: status, At: time.Now()})
+		w.WriteHeader(status)
+		if status == http.StatusUnprocessableEntity {
+			_, _ = w.Write([]byte(`{"message":"Reviews may only be requested from collaborators."}`))
+		}
+	}))
+	defer api.Close()
+	succeeded := func(method, path string) []fakeGitHubCall {
+		mu.Lock()
+		defer mu.Unlock()
+		var out []fakeGitHubCall
+		for _, c := range calls {
+			if c.Method == method && c.Path == path && c.Status < 300 {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; calls: %+v", what, calls)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	const secret = "gh-webhook-secret"
+	cfg := github.Config{WebhookSecret: secret, APIToken: "gh-api-token", APIURL: api.URL, Timeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := outbox.NewRelay(repo.New(pool), []outbox.Sink{github.NewReviewerSync(github.NewClient(cfg), repo.New(pool))}, outbox.Config{
+		BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	})
+	go relay.Run(ctx)
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithGitHub(cfg), server.WithOutbox(relay)))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	deliver := func(fixture string) map[string]any {
+		t.Helper()
+		body, err := os.ReadFile(filepath.Join("testdata", "github", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/github/webhook", bytes.NewReader(body))
+		req.Header.Set(github.HeaderEvent, "pull_request")
+		req.Header.Set(github.HeaderSignature, webhook.Sign(secret, body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %v", fixture, res.StatusCode, out)
+		}
+		return out["pr"].(map[string]any)
+	}
+
+	post("/team/add", `{"team_name":"sync","members":[{"user_id":"gs1","username":"Alice","is_active":true},{"user_id":"gs2","username":"Bob","is_active":true},{"user_id":"gs3","username":"Carol","is_active":true},{"user_id":"gs4","username":"Dan","is_active":true}]}`, http.StatusCreated)
+	logins := map[string]string{"gs1": "octo-alice", "gs2": "octo-bob", "gs3": "octo-carol", "gs4": "octo-dan"}
+	for uid, login := range logins {
+		post("/github/accounts", fmt.Sprintf(`{"login":%q,"user_id":%q}`, login, uid), http.StatusCreated)
+	}
+	loginsOf := func(userIDs []any) []string {
+		out := []string{}
+		for _, uid := range userIDs {
+			out = append(out, logins[uid.(string)])
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	const path42 = "/repos/acme/widgets/pulls/42/requested_reviewers"
+	pr := deliver("pull_request_opened.json")
+	waitFor("review request for #42", func() bool { return len(succeeded(http.MethodPost, path42)) == 1 })
+	got := succeeded(http.MethodPost, path42)[0].Reviewers
+	slices.Sort(got)
+	if want := loginsOf(pr["assigned_reviewers"].([]any)); !slices.Equal(got, want) {
+		t.Fatalf("requested %v, want %v", got, want)
+	}
+
+	pr42Reviewers := pr["assigned_reviewers"].([]any)
+	old := pr42Reviewers[0].(string)
+	out := post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"acme/widgets#42","old_user_id":%q}`, old), http.StatusOK)
+	waitFor("rate limited removal to go through", func() bool { return len(succeeded(http.MethodPost, path42)) == 2 })
+	removed := succeeded(http.MethodDelete, path42)
+	if len(removed) != 1 || !slices.Equal(removed[0].Reviewers, []string{logins[old]}) {
+		t.Fatalf("removed: %+v", removed)
+	}
+	mu.Lock()
+	limited := slices.DeleteFunc(slices.Clone(calls), func(c fakeGitHubCall) bool { return c.Status != http.StatusForbidden })
+	mu.Unlock()
+	if len(limited) != 1 || removed[0].At.Before(resetAt) {
+		t.Fatalf("retried the rate limited removal at %s, reset at %s: %+v", removed[0].At, resetAt, limited)
+	}
+	if added := succeeded(http.MethodPost, path42)[1].Reviewers; !slices.Equal(added, []string{logins[out["replaced_by"].(string)]}) {
+		t.Fatalf("requested replacement %v, want %s", added, out["replaced_by"])
+	}
+
+	if pr = deliver("pull_request_opened_draft.json"); len(pr["assigned_reviewers"].([]any)) != 0 {
+		t.Fatalf("draft: %v", pr)
+	}
+	pr = deliver("pull_request_ready_for_review.json")
+	var failures []domain.ReviewSyncFailure
+	waitFor("sync failure for #43", func() bool {
+		res, err := http.Get(srv.URL + "/github/sync/failures?pull_request_id=" + url.QueryEscape("acme/widgets#43"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body struct {
+			Failures []domain.ReviewSyncFailure `json:"failures"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		failures = body.Failures
+		return len(failures) > 0
+	})
+	f := failures[0]
+	if len(failures) != 1 || f.Operation != github.OpRequest || f.StatusCode == nil || *f.StatusCode != http.StatusUnprocessableEntity ||
+		!strings.Contains(f.Error, "collaborators") || !slices.Equal(f.Logins, loginsOf(pr["assigned_reviewers"].([]any))) {
+		t.Fatalf("failure: %+v", failures)
+	}
+	local := post("/pullRequest/setDraft", `{"pull_request_id":"acme/widgets#43","is_draft":false}`, http.StatusOK)["pr"].(map[string]any)
+	if len(local["assigned_reviewers"].([]any)) != 2 {
+		t.Fatalf("local assignment lost: %v", local)
+	}
+
+	// Deactivating the team drops the reviewers of #42 with nobody left to
+	// take over: each of them is removed on GitHub and nobody is requested.
+	current := slices.DeleteFunc(slices.Clone(pr42Reviewers), func(uid any) bool { return uid == old })
+	current = append(current, out["replaced_by"])
+	post("/team/deactivateUsers", `{"team_name":"sync"}`, http.StatusOK)
+	waitFor("removals after the deactivation", func() bool { return len(succeeded(http.MethodDelete, path42)) == 3 })
+	var gone []string
+	for _, c := range succeeded(http.MethodDelete, path42)[1:] {
+		gone = append(gone, c.Reviewers...)
+	}
+	slices.Sort(gone)
+	if want := loginsOf(current); !slices.Equal(gone, want) || len(succeeded(http.MethodPost, path42)) != 2 {
+		t.Fatalf("removed %v after the deactivation, want %v; requests: %+v", gone, want, succeeded(http.MethodPost, path42))
+	}
+}
+
+// chatMessage is one message posted to the fake incoming webhook.
+type chatMessage struct {
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+}
+
+func TestChatNotifications(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var messages []chatMessage
+	// The second post, the first direct message, fails once: the relay's
+	// retry of the event must not post the channel message again.
+	posts := 0
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if posts++; posts == 2 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var m chatMessage
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Errorf("decode message: %v", err)
+		}
+		messages = append(messages, m)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer chat.Close()
+	snapshot := func() []chatMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(messages)
+	}
+	waitFor := func(n int) []chatMessage {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for len(snapshot()) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d chat messages, want %d: %+v", len(snapshot()), n, snapshot())
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		time.Sleep(200 * time.Millisecond)
+		got := snapshot()
+		if len(got) != n {
+			t.Fatalf("got %d chat messages, want %d: %+v", len(got), n, got)
+		}
+		return got
+	}
+
+	templates := t.TempDir()
+	if err := os.WriteFile(filepath.Join(templates, notify.KindMerged+".tmpl"), []byte("merged: {{.PR.ID}} ({{len .Reviewers}} reviewers)\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := notify.NewNotifier(notify.Config{WebhookURL: chat.URL, TemplatesDir: templates, Timeout: time.Second}, repo.New(pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := outbox.NewRelay(repo.New(pool), []outbox.Sink{notifier}, outbox.Config{
+		BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, PollInterval: 20 * time.Millisecond, BatchSize: 10,
+	})
+	go relay.Run(ctx)
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithOutbox(relay)))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+
+	post("/team/add", `{"team_name":"chat","members":[{"user_id":"n1","username":"Nina","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"chat-web","members":[{"user_id":"w1","username":"Wendy","is_active":true},{"user_id":"w2","username":"Walt","is_active":true},{"user_id":"w3","username":"Wes","is_active":true},{"user_id":"w4","username":"Wil","is_active":true}]}`, http.StatusCreated)
+	post("/team/setParent", `{"team_name":"chat-web","parent_team_name":"chat"}`, http.StatusOK)
+	post("/team/notifications", `{"team_name":"chat","channel":"#reviews","webhook_url":"ftp://nope"}`, http.StatusBadRequest)
+	post("/team/notifications", `{"team_name":"ghost","channel":"#reviews"}`, http.StatusNotFound)
+	post("/team/notifications", `{"team_name":"chat","channel":"#reviews"}`, http.StatusOK)
+
+	res, err := http.Get(srv.URL + "/team/notifications?team_name=chat-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inherited struct {
+		Channel   *domain.NotifyChannel `json:"channel"`
+		Effective *domain.NotifyChannel `json:"effective"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&inherited)
+	res.Body.Close()
+	if inherited.Channel != nil || inherited.Effective == nil || inherited.Effective.TeamName != "chat" || inherited.Effective.Channel != "#reviews" {
+		t.Fatalf("inherited channel: %+v", inherited)
+	}
+
+	post("/users/notifications", `{"user_id":"w1","handle":"@wendy","dm":false}`, http.StatusOK)
+	handles := map[string]string{"w2": "walt", "w3": "wes", "w4": "wil"}
+	for uid, h := range handles {
+		post("/users/notifications", fmt.Sprintf(`{"user_id":%q,"handle":%q,"dm":true}`, uid, h), http.StatusOK)
+	}
+	post("/users/notifications", `{"user_id":"ghost","handle":"x"}`, http.StatusNotFound)
+
+	// Creation: one channel message naming everyone, one DM per reviewer.
+	pr := post("/pullRequest/create", `{"pull_request_id":"np-1","pull_request_name":"Add chat","author_id":"w1"}`, http.StatusCreated)["pr"].(map[string]any)
+	reviewers := pr["assigned_reviewers"].([]any)
+	got := waitFor(3)
+	if got[0].Channel != "#reviews" || !strings.Contains(got[0].Text, "@wendy") ||
+		!strings.Contains(got[0].Text, "@"+handles[reviewers[0].(string)]) || !strings.Contains(got[0].Text, "@"+handles[reviewers[1].(string)]) {
+		t.Fatalf("channel message: %+v", got[0])
+	}
+	dms := []string{got[1].Channel, got[2].Channel}
+	slices.Sort(dms)
+	want := []string{"@" + handles[reviewers[0].(string)], "@" + handles[reviewers[1].(string)]}
+	slices.Sort(want)
+	if !slices.Equal(dms, want) {
+		t.Fatalf("direct messages to %v, want %v", dms, want)
+	}
+
+	// Reassignment reaches the channel, the old and the new reviewer.
+	old := reviewers[0].(string)
+	replacedBy := post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"np-1","old_user_id":%q}`, old), http.StatusOK)["replaced_by"].(string)
+	got = waitFor(6)[3:]
+	if got[0].Channel != "#reviews" || got[1].Channel != "@"+handles[old] || got[2].Channel != "@"+handles[replacedBy] {
+		t.Fatalf("reassignment messages: %+v", got)
+	}
+
+	// Merge uses the overridden template.
+	post("/pullRequest/merge", `{"pull_request_id":"np-1"}`, http.StatusOK)
+	got = waitFor(9)[6:]
+	for _, m := range got {
+		if m.Text != "merged: np-1 (2 reviewers)" {
+			t.Fatalf("merge message: %+v", m)
+		}
+	}
+
+	// Deactivating the team batches every dropped review into one channel
+	// message and at most one digest per reviewer.
+	held := map[string]bool{}
+	for i := 2; i <= 5; i++ {
+		pr := post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":"np-%d","pull_request_name":"Batch %d","author_id":"w1"}`, i, i), http.StatusCreated)["pr"].(map[string]any)
+		for _, uid := range pr["assigned_reviewers"].([]any) {
+			held[uid.(string)] = true
+		}
+	}
+	waitFor(9 + 4*3)
+	out := post("/team/deactivateUsers", `{"team_name":"chat-web"}`, http.StatusOK)
+	if out["removed"].(float64) != 8 {
+		t.Fatalf("deactivate: %v", out)
+	}
+	got = waitFor(21 + 1 + len(held))[21:]
+	if got[0].Channel != "#reviews" || !strings.Contains(got[0].Text, "8 dropped") || strings.Count(got[0].Text, "\n") != 8 {
+		t.Fatalf("deactivation summary: %+v", got[0])
+	}
+	seen := map[string]bool{}
+	for _, m := range got[1:] {
+		if seen[m.Channel] || !strings.Contains(m.Text, "You no longer review") {
+			t.Fatalf("digest: %+v", got)
+		}
+		seen[m.Channel] = true
+	}
+}
+
+// smtpStub is a minimal in-process SMTP server recording the mail it accepts.
+// With rejectNext set it refuses the next message at DATA.
+type smtpStub struct {
+	ln         net.Listener
+	mu         sync.Mutex
+	mails      []stubMail
+	rejectNext bool
+}
+
+type stubMail struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 stub ESMTP")
+	var m stubMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		addr := func() string { return strings.Trim(arg[strings.Index(arg, ":")+1:], "<> ") }
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250 stub")
+		case "MAIL":
+			m = stubMail{From: addr()}
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.To = append(m.To, addr())
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			s.mu.Lock()
+			reject := s.rejectNext
+			s.rejectNext = false
+			s.mu.Unlock()
+			if reject {
+				_ = tp.PrintfLine("554 rejected")
+				continue
+			}
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.Data = data
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			_ = tp.PrintfLine("250 OK")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) take() []stubMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.mails
+	s.mails = nil
+	return out
+}
+
+// parsedMail is a digest split into its subject and alternative parts.
+type parsedMail struct {
+	To, Subject, Text, HTML string
+}
+
+func parseDigest(t *testing.T, m stubMail) parsedMail {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := parsedMail{To: msg.Header.Get("To"), Subject: subject}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		switch {
+		case strings.HasPrefix(p.Header.Get("Content-Type"), "text/plain"):
+			out.Text = string(body)
+		case strings.HasPrefix(p.Header.Get("Content-Type"), "text/html"):
+			out.HTML = string(body)
+		}
+	}
+	return out
+}
+
+func TestEmailDigest(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+
+	post("/team/add", `{"team_name":"mail","members":[{"user_id":"m1","username":"Mara","is_active":true},{"user_id":"m2","username":"Milo","is_active":true},{"user_id":"m3","username":"Moss","is_active":true}]}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"dp-1","pull_request_name":"Fix <b>bold</b>","author_id":"m1"}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"dp-2","pull_request_name":"Add mail","author_id":"m2"}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"dp-3","pull_request_name":"Done","author_id":"m3"}`, http.StatusCreated)
+	post("/pullRequest/merge", `{"pull_request_id":"dp-3"}`, http.StatusOK)
+
+	for _, bad := range []string{
+		`{"user_id":"m3","email":"nope","schedule":"daily"}`,
+		`{"user_id":"m3","email":"moss@example.com","schedule":"hourly"}`,
+		`{"user_id":"m3","email":"moss@example.com","schedule":"daily","hour":24}`,
+		`{"user_id":"m3","email":"moss@example.com","schedule":"weekly","weekday":7}`,
+		`{"user_id":"m3","email":"moss@example.com","schedule":"daily","timezone":"Mars/Olympus"}`,
+	} {
+		post("/users/digest", bad, http.StatusBadRequest)
+	}
+	post("/users/digest", `{"user_id":"ghost","email":"g@example.com","schedule":"daily"}`, http.StatusNotFound)
+
+	now := time.Now()
+	post("/users/digest", `{"user_id":"m3","email":"Moss <moss@example.com>","schedule":"daily","hour":9,"timezone":"Asia/Tokyo"}`, http.StatusOK)
+	weekday := now.Add(3 * 24 * time.Hour).UTC().Weekday()
+	post("/users/digest", fmt.Sprintf(`{"user_id":"m2","email":"milo@example.com","schedule":"weekly","weekday":%d}`, weekday), http.StatusOK)
+	post("/users/digest", `{"user_id":"m1","email":"mara@example.com","schedule":"off"}`, http.StatusOK)
+
+	stub := newSMTPStub(t)
+	cfg := digest.Config{SMTPHost: "127.0.0.1", SMTPPort: stub.ln.Addr().(*net.TCPAddr).Port, From: "Reviews <reviews@example.com>", Timeout: 5 * time.Second}
+	job, err := digest.NewJob(cfg, repo.New(pool), digest.NewMailer(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runOnce := func(at time.Time, want int) []stubMail {
+		t.Helper()
+		sent, err := job.RunOnce(context.Background(), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mails := stub.take()
+		if sent != want || len(mails) != want {
+			t.Fatalf("sent %d (%d received), want %d", sent, len(mails), want)
+		}
+		return mails
+	}
+
+	// Slots before the preferences were saved are not due.
+	runOnce(now, 0)
+
+	// A rejected digest is released and goes out on the next check, once.
+	stub.mu.Lock()
+	stub.rejectNext = true
+	stub.mu.Unlock()
+	runOnce(now.Add(25*time.Hour), 0)
+	res, err := http.Get(srv.URL + "/users/digest?user_id=m3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefs struct {
+		Digest domain.DigestPreferences `json:"digest"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&prefs)
+	res.Body.Close()
+	if prefs.Digest.LastSentAt != nil || prefs.Digest.Timezone != "Asia/Tokyo" || prefs.Digest.Email != "moss@example.com" {
+		t.Fatalf("after a failed send: %+v", prefs.Digest)
+	}
+	mails := runOnce(now.Add(25*time.Hour), 1)
+	runOnce(now.Add(26*time.Hour), 0)
+
+	m := parseDigest(t, mails[0])
+	if mails[0].From != "reviews@example.com" || !slices.Equal(mails[0].To, []string{"moss@example.com"}) || m.Subject != "2 pull requests waiting for your review" {
+		t.Fatalf("envelope %+v, subject %q", mails[0], m.Subject)
+	}
+	if !strings.Contains(m.Text, "Hi Moss") || !strings.Contains(m.Text, "JST") ||
+		strings.Index(m.Text, "Fix <b>bold</b> (dp-1) by Mara") > strings.Index(m.Text, "Add mail (dp-2) by Milo") ||
+		!strings.Contains(m.Text, "Add mail (dp-2) by Milo, open for 1d") {
+		t.Fatalf("text part:\n%s", m.Text)
+	}
+	if !strings.Contains(m.HTML, "Fix &lt;b&gt;bold&lt;/b&gt;") || strings.Contains(m.HTML, "<b>bold") {
+		t.Fatalf("html part:\n%s", m.HTML)
+	}
+
+	// Four days on the daily and the weekly digest are due; m1 has opted out
+	// and merged PRs are left out.
+	mails = runOnce(now.Add(4*24*time.Hour), 2)
+	for _, sent := range mails {
+		m := parseDigest(t, sent)
+		switch m.To {
+		case "milo@example.com":
+			if m.Subject != "1 pull request waiting for your review" || strings.Contains(m.Text, "dp-3") {
+				t.Fatalf("weekly digest: %+v", m)
+			}
+		case "moss@example.com":
+		default:
+			t.Fatalf("unexpected digest to %s", m.To)
+		}
+	}
+}
+
+func TestAssignmentStats_History(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	stats := func(query string, want int) []domain.AssignmentStat {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/stats/assignments?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("stats?%s status %d, want %d", query, res.StatusCode, want)
+		}
+		var body struct {
+			Assignments []domain.AssignmentStat `json:"assignments"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return body.Assignments
+	}
+
+	post("/team/add", `{"team_name":"hist","members":[{"user_id":"ah1","username":"A","is_active":true},{"user_id":"ah2","username":"B","is_active":true},{"user_id":"ah3","username":"C","is_active":true},{"user_id":"ah4","username":"D","is_active":true}]}`, http.StatusCreated)
+	pr := post("/pullRequest/create", `{"pull_request_id":"ah-1","pull_request_name":"x","author_id":"ah1"}`, http.StatusCreated)["pr"].(map[string]any)
+	old := pr["assigned_reviewers"].([]any)[0].(string)
+	post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"ah-1","old_user_id":%q}`, old), http.StatusOK)
+	post("/pullRequest/create", `{"pull_request_id":"ah-2","pull_request_name":"x","author_id":"ah1"}`, http.StatusCreated)
+	post("/pullRequest/merge", `{"pull_request_id":"ah-2"}`, http.StatusOK)
+	post("/pullRequest/create", `{"pull_request_id":"ah-3","pull_request_name":"x","author_id":"ah1"}`, http.StatusCreated)
+	post("/pullRequest/close", `{"pull_request_id":"ah-3"}`, http.StatusOK)
+	post("/team/deactivateUsers", `{"team_name":"hist"}`, http.StatusOK)
+
+	want := domain.AssignmentStat{TeamName: "hist", Count: 7, Completed: 2, Closed: 2, Reassigned: 1, Removed: 2}
+	if got := stats("group_by=team&team_name=hist", http.StatusOK); len(got) != 1 || got[0] != want {
+		t.Fatalf("by team: %+v, want %+v", got, want)
+	}
+
+	// The user reassigned off keeps the review in their history.
+	byUser := stats("team_name=hist", http.StatusOK)
+	total := 0
+	for _, s := range byUser {
+		total += s.Count
+		if s.UserID == old && s.Reassigned != 1 {
+			t.Fatalf("reassigned reviewer: %+v", s)
+		}
+		if s.TeamName != "" || s.Week != "" {
+			t.Fatalf("ungrouped fields set: %+v", s)
+		}
+	}
+	if total != 7 {
+		t.Fatalf("by user: %+v", byUser)
+	}
+
+	now := time.Now().UTC()
+	monday := now.AddDate(0, 0, -int((now.Weekday()+6)%7)).Format(time.DateOnly)
+	for _, s := range stats("group_by=week,user&from="+now.AddDate(0, 0, -1).Format(time.DateOnly), http.StatusOK) {
+		if s.Week != monday || s.UserID == "" {
+			t.Fatalf("by week and user: %+v, want week %s", s, monday)
+		}
+	}
+	if got := stats("from="+now.AddDate(0, 0, 1).Format(time.DateOnly), http.StatusOK); len(got) != 0 {
+		t.Fatalf("future window: %+v", got)
+	}
+	if got := stats("to=2000-01-01T00:00:00Z", http.StatusOK); len(got) != 0 {
+		t.Fatalf("past window: %+v", got)
+	}
+	stats("from=2026-02-01&to=2026-01-01", http.StatusBadRequest)
+	stats("from=yesterday", http.StatusBadRequest)
+	stats("group_by=month", http.StatusBadRequest)
+}
+
+func TestCycleTimeStats(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	report := func(query string, want int) domain.CycleTimeReport {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/stats/cycle-time?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("cycle-time?%s status %d, want %d", query, res.StatusCode, want)
+		}
+		var out domain.CycleTimeReport
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return out
+	}
+	same := func(name string, got, want domain.Percentiles) {
+		t.Helper()
+		near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+		if got.Count != want.Count || !near(got.P50, want.P50) || !near(got.P90, want.P90) || !near(got.P99, want.P99) {
+			t.Fatalf("%s: %+v, want %+v", name, got, want)
+		}
+	}
+
+	post("/team/add", `{"team_name":"cyc","members":[{"user_id":"ct1","username":"A","is_active":true},{"user_id":"ct2","username":"B","is_active":true},{"user_id":"ct3","username":"C","is_active":true}]}`, http.StatusCreated)
+	for _, id := range []string{"ct-1", "ct-2", "ct-3"} {
+		post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":%q,"pull_request_name":"x","author_id":"ct1"}`, id), http.StatusCreated)
+	}
+	post("/pullRequest/review", `{"pull_request_id":"ct-1","user_id":"ct1"}`, http.StatusConflict)
+	post("/pullRequest/review", `{"pull_request_id":"ct-404","user_id":"ct2"}`, http.StatusNotFound)
+	for _, rv := range [][2]string{{"ct-1", "ct2"}, {"ct-1", "ct3"}, {"ct-2", "ct2"}, {"ct-3", "ct2"}, {"ct-3", "ct2"}} {
+		post("/pullRequest/review", fmt.Sprintf(`{"pull_request_id":%q,"user_id":%q}`, rv[0], rv[1]), http.StatusOK)
+	}
+	post("/pullRequest/merge", `{"pull_request_id":"ct-1"}`, http.StatusOK)
+	post("/pullRequest/merge", `{"pull_request_id":"ct-2"}`, http.StatusOK)
+	post("/pullRequest/review", `{"pull_request_id":"ct-2","user_id":"ct3"}`, http.StatusConflict)
+
+	// Pin every timestamp to hours after a Monday midnight.
+	ctx := context.Background()
+	t0 := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	exec := func(sql string, args ...any) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, sql, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(`UPDATE pull_requests SET created_at=$1 WHERE pull_request_id LIKE 'ct-%'`, t0)
+	exec(`UPDATE review_assignments SET assigned_at=$1 WHERE pull_request_id LIKE 'ct-%'`, t0)
+	for _, at := range []struct {
+		pr, user string
+		hours    int
+	}{{"ct-1", "ct2", 1}, {"ct-1", "ct3", 3}, {"ct-2", "ct2", 2}, {"ct-3", "ct2", 4}} {
+		exec(`UPDATE review_assignments SET reviewed_at=$3 WHERE pull_request_id=$1 AND user_id=$2`, at.pr, at.user, t0.Add(time.Duration(at.hours)*time.Hour))
+	}
+	exec(`UPDATE pull_requests SET merged_at=$2 WHERE pull_request_id=$1`, "ct-1", t0.Add(5*time.Hour))
+	exec(`UPDATE pull_requests SET merged_at=$2 WHERE pull_request_id=$1`, "ct-2", t0.Add(7*time.Hour))
+
+	// First reviews came after 1h, 2h and 4h; merges after 5h and 7h.
+	firstReview := domain.Percentiles{Count: 3, P50: 7200, P90: 12960, P99: 14256}
+	toMerge := domain.Percentiles{Count: 2, P50: 21600, P90: 24480, P99: 25128}
+	got := report("team_name=cyc&from=2026-03-01&to=2026-03-09", http.StatusOK)
+	if len(got.Teams) != 1 || got.Teams[0].TeamName != "cyc" || got.Teams[0].PRs != 3 {
+		t.Fatalf("teams: %+v", got.Teams)
+	}
+	same("team first review", got.Teams[0].TimeToFirstReview, firstReview)
+	same("team merge", got.Teams[0].TimeToMerge, toMerge)
+	if len(got.Weekly) != 1 || got.Weekly[0].Week != "2026-03-02" || got.Weekly[0].TeamName != "" || got.Weekly[0].PRs != 3 {
+		t.Fatalf("weekly: %+v", got.Weekly)
+	}
+	same("weekly first review", got.Weekly[0].TimeToFirstReview, firstReview)
+	if len(got.Reviewers) != 2 || got.Reviewers[0].UserID != "ct2" || got.Reviewers[1].UserID != "ct3" {
+		t.Fatalf("reviewers: %+v", got.Reviewers)
+	}
+	same("ct2 response", got.Reviewers[0].ResponseTime, firstReview)
+	same("ct3 response", got.Reviewers[1].ResponseTime, domain.Percentiles{Count: 1, P50: 10800, P90: 10800, P99: 10800})
+
+	if got := report("team_name=cyc&from=2026-03-03", http.StatusOK); len(got.Teams) != 0 || len(got.Weekly) != 0 || len(got.Reviewers) != 0 {
+		t.Fatalf("window after the PRs: %+v", got)
+	}
+	if got := report("team_name=other", http.StatusOK); got.Teams == nil || len(got.Teams) != 0 {
+		t.Fatalf("other team: %+v", got)
+	}
+	report("from=2026-03-09&to=2026-03-01", http.StatusBadRequest)
+}
+
+func TestFairnessReport(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+	report := func(query string, want int) domain.FairnessReport {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/stats/fairness?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("fairness?%s status %d, want %d", query, res.StatusCode, want)
+		}
+		var out domain.FairnessReport
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return out
+	}
+	ctx := context.Background()
+	exec := func(sql string, args ...any) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, sql, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	periods := func(user string) (open, closed int) {
+		t.Helper()
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FILTER (WHERE active_to IS NULL), COUNT(*) FILTER (WHERE active_to IS NOT NULL)
+            FROM member_activity WHERE team_name='fair' AND user_id=$1`, user).Scan(&open, &closed); err != nil {
+			t.Fatal(err)
+		}
+		return open, closed
+	}
+
+	post("/team/add", `{"team_name":"fair","members":[{"user_id":"fa","username":"A","is_active":true},{"user_id":"fb","username":"B","is_active":true},{"user_id":"fc","username":"C","is_active":true},{"user_id":"fd","username":"D","is_active":true},{"user_id":"fe","username":"E","is_active":false}]}`, http.StatusCreated)
+
+	// Activity periods follow every switch of the user and membership flags.
+	if open, closed := periods("fe"); open != 0 || closed != 0 {
+		t.Fatalf("inactive user has periods: %d open, %d closed", open, closed)
+	}
+	post("/users/setIsActive", `{"user_id":"fd","is_active":false}`, http.StatusOK)
+	post("/users/setIsActive", `{"user_id":"fd","is_active":true}`, http.StatusOK)
+	if open, closed := periods("fd"); open != 1 || closed != 1 {
+		t.Fatalf("fd after toggling: %d open, %d closed", open, closed)
+	}
+	post("/team/deactivateUsers", `{"team_name":"fair"}`, http.StatusOK)
+	if open, closed := periods("fa"); open != 0 || closed != 1 {
+		t.Fatalf("fa after mass deactivation: %d open, %d closed", open, closed)
+	}
+	post("/team/addMembers", `{"team_name":"fair","members":[{"user_id":"fa","username":"A","is_active":true},{"user_id":"fb","username":"B","is_active":true},{"user_id":"fc","username":"C","is_active":true},{"user_id":"fd","username":"D","is_active":true}]}`, http.StatusOK)
+	if open, _ := periods("fa"); open != 1 {
+		t.Fatalf("fa after re-adding: %d open", open)
+	}
+	for i := 1; i <= 6; i++ {
+		post("/pullRequest/create", fmt.Sprintf(`{"pull_request_id":"fr-%d","pull_request_name":"x","author_id":"fa"}`, i), http.StatusCreated)
+	}
+
+	// Pin a ten-day window: fd was active for half of it. fb took six
+	// reviews, fc and fd two each, fa none.
+	exec(`DELETE FROM member_activity WHERE team_name='fair'`)
+	exec(`INSERT INTO member_activity(team_name, user_id, active_from, active_to) VALUES
+        ('fair','fa','2026-03-01','2026-03-11'), ('fair','fb','2026-03-01','2026-03-11'),
+        ('fair','fc','2026-03-01','2026-03-11'), ('fair','fd','2026-03-01','2026-03-06')`)
+	exec(`DELETE FROM review_assignments WHERE pull_request_id LIKE 'fr-%'`)
+	for i := 1; i <= 6; i++ {
+		exec(`INSERT INTO review_assignments(pull_request_id, user_id, assigned_at) VALUES ($1,'fb','2026-03-02')`, fmt.Sprintf("fr-%d", i))
+	}
+	exec(`INSERT INTO review_assignments(pull_request_id, user_id, assigned_at) VALUES
+        ('fr-1','fc','2026-03-02'), ('fr-2','fc','2026-03-02'), ('fr-3','fd','2026-03-02'), ('fr-4','fd','2026-03-02')`)
+
+	got := report("team_name=fair&from=2026-03-01&to=2026-03-11", http.StatusOK)
+	if got.Assignments != 10 || got.ThresholdPercent != 20 || len(got.Members) != 4 {
+		t.Fatalf("report: %+v", got)
+	}
+	// Loads per active day are 0, 0.6, 0.2 and 0.4.
+	if math.Abs(got.Gini-5.0/12) > 1e-9 {
+		t.Fatalf("gini %v, want %v", got.Gini, 5.0/12)
+	}
+	want := map[string]struct {
+		days, ratio float64
+		overloaded  bool
+	}{"fa": {10, 0, false}, "fb": {10, 2.1, true}, "fc": {10, 0.7, false}, "fd": {5, 1.4, true}}
+	for _, m := range got.Members {
+		w := want[m.UserID]
+		if m.LoadRatio == nil || math.Abs(m.ActiveDays-w.days) > 1e-9 || math.Abs(*m.LoadRatio-w.ratio) > 1e-9 || m.Overloaded != w.overloaded {
+			t.Fatalf("member %+v, want %+v", m, w)
+		}
+	}
+	for _, m := range report("team_name=fair&from=2026-03-01&to=2026-03-11&threshold=50", http.StatusOK).Members {
+		if m.Overloaded != (m.UserID == "fb") {
+			t.Fatalf("threshold 50: %+v", m)
+		}
+	}
+
+	// After the sixth nobody was assigned and fd was no longer active.
+	got = report("team_name=fair&from=2026-03-06&to=2026-03-11", http.StatusOK)
+	if got.Assignments != 0 || got.Gini != 0 || len(got.Members) != 3 {
+		t.Fatalf("second half: %+v", got)
+	}
+	report("team_name=fair", http.StatusOK)
+	report("from=2026-03-01", http.StatusBadRequest)
+	report("team_name=fair&threshold=-5", http.StatusBadRequest)
+	report("team_name=missing", http.StatusNotFound)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithMetrics(metrics.New(pool))))
+	defer srv.Close()
+
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		out := map[string]any{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d: %v", path, res.StatusCode, want, out)
+		}
+		return out
+	}
+
+	post("/team/add", `{"team_name":"met","members":[{"user_id":"mt1","username":"A","is_active":true},{"user_id":"mt2","username":"B","is_active":true},{"user_id":"mt3","username":"C","is_active":true},{"user_id":"mt4","username":"D","is_active":true}]}`, http.StatusCreated)
+	post("/team/add", `{"team_name":"thin","members":[{"user_id":"tn1","username":"E","is_active":true},{"user_id":"tn2","username":"F","is_active":true}]}`, http.StatusCreated)
+	pr := post("/pullRequest/create", `{"pull_request_id":"mp-1","pull_request_name":"x","author_id":"mt1"}`, http.StatusCreated)["pr"].(map[string]any)
+	post("/pullRequest/create", `{"pull_request_id":"mp-2","pull_request_name":"x","author_id":"mt1"}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"tp-1","pull_request_name":"x","author_id":"tn1"}`, http.StatusCreated)
+	post("/pullRequest/reassign", fmt.Sprintf(`{"pull_request_id":"mp-1","old_user_id":%q}`, pr["assigned_reviewers"].([]any)[0]), http.StatusOK)
+	post("/pullRequest/reassign", `{"pull_request_id":"tp-1","old_user_id":"tn2"}`, http.StatusConflict)
+	post("/pullRequest/merge", `{"pull_request_id":"mp-1"}`, http.StatusOK)
+	post("/team/deactivateUsers", `{"team_name":"thin"}`, http.StatusOK)
+	if res, err := http.Get(srv.URL + "/no/such/route"); err == nil {
+		res.Body.Close()
+	}
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("metrics status %d: %s", res.StatusCode, body)
+	}
+	lines := strings.Split(string(body), "\n")
+	for _, want := range []string{
+		`pr_service_prs_created_total 3`,
+		`pr_service_prs_merged_total 1`,
+		`pr_service_reassignments_total 1`,
+		`pr_service_rejected_calls_total{action="pr.reassign",code="NO_CANDIDATE"} 1`,
+		`pr_service_mass_deactivations_total 1`,
+		`pr_service_mass_deactivation_reviews_total{outcome="reassigned"} 0`,
+		`pr_service_mass_deactivation_reviews_total{outcome="removed"} 1`,
+		`pr_service_open_prs 2`,
+		`pr_service_understaffed_prs 1`,
+		`pr_service_http_requests_total{method="POST",route="/pullRequest/create",status="201"} 3`,
+		`pr_service_http_requests_total{method="POST",route="/pullRequest/reassign",status="409"} 1`,
+		`pr_service_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`pr_service_http_request_duration_seconds_count{method="POST",route="/pullRequest/create",status="201"} 3`,
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, prefix := range []string{"pr_service_db_pool_max_conns ", "pr_service_db_pool_acquires_total ", "go_goroutines "} {
+		if !slices.ContainsFunc(lines, func(l string) bool { return strings.HasPrefix(l, prefix) }) {
+			t.Errorf("missing %s", prefix)
+		}
+	}
+}
+
+func TestTracing_SpansAcrossLayers(t *testing.T) {
+	// Tracers bind to the first global provider installed, so this is the
+	// only test that sets one.
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	if _, err := tracing.Setup(context.Background(), tracing.DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	base, cleanup := setupDB(t)
+	defer cleanup()
+	pool, err := storage.Open(context.Background(), storage.Config{URL: base.Config().ConnString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	srv := httptest.NewServer(server.NewRouter(pool))
+	defer srv.Close()
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	post := func(path, body string, want int) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+	}
+	// find waits for a span the server may still be ending after its response.
+	find := func(name string) sdktrace.ReadOnlySpan {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, s := range sr.Ended() {
+				if s.Name() == name {
+					return s
+				}
+			}
+		}
+		t.Fatalf("no span %q", name)
+		return nil
+	}
+	attr := func(s sdktrace.ReadOnlySpan, key string) string {
+		for _, kv := range s.Attributes() {
+			if string(kv.Key) == key {
+				return kv.Value.Emit()
+			}
+		}
+		return ""
+	}
+
+	post("/team/add", `{"team_name":"tr","members":[{"user_id":"tr1","username":"A","is_active":true},{"user_id":"tr2","username":"B","is_active":true},{"user_id":"tr3","username":"C","is_active":true}]}`, http.StatusCreated)
+	post("/pullRequest/create", `{"pull_request_id":"tr-1","pull_request_name":"x","author_id":"tr1"}`, http.StatusCreated)
+	post("/team/deactivateUsers", `{"team_name":"tr"}`, http.StatusOK)
+	post("/pullRequest/reassign", `{"pull_request_id":"tr-404","old_user_id":"tr2"}`, http.StatusNotFound)
+
+	// The incoming traceparent is continued.
+	root := find("POST /team/deactivateUsers")
+	if root.SpanKind() != trace.SpanKindServer || root.SpanContext().TraceID().String() != traceID || root.Parent().SpanID().String() != spanID {
+		t.Fatalf("server span: kind %v, trace %s, parent %s", root.SpanKind(), root.SpanContext().TraceID(), root.Parent().SpanID())
+	}
+	if attr(root, "http.route") != "/team/deactivateUsers" || attr(root, "http.response.status_code") != "200" {
+		t.Fatalf("server span attributes: %v", root.Attributes())
+	}
+	svc := find("Service.MassDeactivate")
+	if svc.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatalf("service span parent %s, want %s", svc.Parent().SpanID(), root.SpanContext().SpanID())
+	}
+
+	// Every query of the deactivation is a span under the service call.
+	byID := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		byID[s.SpanContext().SpanID()] = s
+	}
+	under := func(s sdktrace.ReadOnlySpan, ancestor trace.SpanID) bool {
+		for p := s.Parent().SpanID(); p.IsValid(); {
+			if p == ancestor {
+				return true
+			}
+			parent, ok := byID[p]
+			if !ok {
+				return false
+			}
+			p = parent.Parent().SpanID()
+		}
+		return false
+	}
+	ops := map[string]int{}
+	for _, s := range sr.Ended() {
+		if s.SpanKind() == trace.SpanKindClient && under(s, svc.SpanContext().SpanID()) {
+			if attr(s, "db.system.name") != "postgresql" || attr(s, "db.query.text") == "" {
+				t.Fatalf("query span attributes: %v", s.Attributes())
+			}
+			ops[s.Name()]++
+		}
+	}
+	if ops["UPDATE"] == 0 || ops["SELECT"] == 0 {
+		t.Fatalf("queries under MassDeactivate: %v", ops)
+	}
+
+	// API errors are noted without failing the span.
+	reassign := find("Service.ReassignReviewer")
+	if attr(reassign, "app.error_code") != string(domain.ErrNotFound) || reassign.Status().Code != codes.Unset {
+		t.Fatalf("reassign span: %v, %v", reassign.Attributes(), reassign.Status())
+	}
+	if attr(find("POST /pullRequest/reassign"), "http.response.status_code") != "404" {
+		t.Fatal("reassign server span lacks its status")
+	}
+}
+
+// syncBuffer collects log output written from concurrent handlers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestStructuredLogging_RequestIDs(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("LOG_LEVELS", "service=debug, repo=debug")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out syncBuffer
+	logging.Setup(&out, cfg.Log)
+	t.Cleanup(func() { logging.Setup(os.Stderr, logging.DefaultConfig()) })
+
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithAuth(auth.Config{Enabled: true, BootstrapToken: "boot"})))
+	defer srv.Close()
+
+	post := func(path, body, requestID string, want int) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer boot")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+		return res.Header.Get("X-Request-ID")
+	}
+	// find waits for the access log line the middleware writes after the
+	// response has gone out.
+	find := func(match func(map[string]any) bool) map[string]any {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, l := range out.lines(t) {
+				if match(l) {
+					return l
+				}
+			}
+		}
+		t.Fatalf("no matching log line in %v", out.lines(t))
+		return nil
+	}
+	line := func(component, msg, requestID string) map[string]any {
+		t.Helper()
+		return find(func(l map[string]any) bool {
+			return l["component"] == component && l["msg"] == msg && l["request_id"] == requestID
+		})
+	}
+
+	if got := post("/team/add", `{"team_name":"lg","members":[{"user_id":"lg1","username":"A","is_active":true},{"user_id":"lg2","username":"B","is_active":true}]}`, "req-team", http.StatusCreated); got != "req-team" {
+		t.Fatalf("echoed request id %q, want req-team", got)
+	}
+	access := line("http", "request", "req-team")
+	if access["level"] != "INFO" || access["method"] != "POST" || access["route"] != "/team/add" ||
+		access["status"] != float64(http.StatusCreated) || access["actor"] != "service:bootstrap" {
+		t.Fatalf("access log %v", access)
+	}
+	if _, ok := access["duration_ms"].(float64); !ok {
+		t.Fatalf("access log without duration: %v", access)
+	}
+	if l := line("service", "change committed", "req-team"); l["action"] != "team.create" {
+		t.Fatalf("service log %v", l)
+	}
+
+	// A rejected call is logged by the service and the repo under the
+	// request's ID.
+	post("/pullRequest/create", `{"pull_request_id":"lg-1","pull_request_name":"x","author_id":"nobody"}`, "req-missing", http.StatusNotFound)
+	if l := line("service", "change rejected", "req-missing"); l["result"] != string(domain.ErrNotFound) {
+		t.Fatalf("service log %v", l)
+	}
+	line("repo", "transaction rolled back", "req-missing")
+	if l := line("http", "request", "req-missing"); l["route"] != "/pullRequest/create" || l["status"] != float64(http.StatusNotFound) {
+		t.Fatalf("access log %v", l)
+	}
+
+	// Missing or malformed IDs are replaced by generated ones.
+	for _, sent := range []string{"", "has space", strings.Repeat("x", 129)} {
+		got := post("/team/add", `{"team_name":"lg-`+fmt.Sprint(len(sent))+`","members":[]}`, sent, http.StatusCreated)
+		if len(got) != 32 || got == sent {
+			t.Fatalf("request id %q for %q, want a generated one", got, sent)
+		}
+		line("http", "request", got)
+	}
+
+	// Authentication failures are logged without an actor.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/team/get?team_name=lg", nil)
+	req.Header.Set("X-Request-ID", "req-anon")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if l := line("http", "request", "req-anon"); l["status"] != float64(http.StatusUnauthorized) || l["actor"] != nil {
+		t.Fatalf("access log %v", l)
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	ready := health.New(pool, health.Config{PingTimeout: 2 * time.Second, Saturation: 0.5})
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithHealth(ready), server.WithAuth(auth.Config{Enabled: true, BootstrapToken: "boot"})))
+	defer srv.Close()
+
+	exec := func(sql string, args ...any) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, sql, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// readyz checks the probe needs no token and answers want.
+	readyz := func(want int) health.Report {
+		t.Helper()
+		res, err := http.Get(srv.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var rep health.Report
+		if err := json.NewDecoder(res.Body).Decode(&rep); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != want {
+			t.Fatalf("readyz status %d, want %d: %+v", res.StatusCode, want, rep)
+		}
+		return rep
+	}
+
+	res, err := http.Get(srv.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("livez status %d", res.StatusCode)
+	}
+
+	latest := migrate.Embedded(pool).Latest()
+	rep := readyz(http.StatusOK)
+	if rep.Status != health.StatusOK || rep.Checks.Database.Status != health.StatusOK ||
+		rep.Checks.Schema.Version != latest || rep.Checks.Schema.Expected != latest || rep.Checks.Pool.Max == 0 {
+		t.Fatalf("migrated: %+v", rep)
+	}
+
+	var checksum string
+	if err := pool.QueryRow(ctx, `SELECT checksum FROM schema_migrations WHERE version=1`).Scan(&checksum); err != nil {
+		t.Fatal(err)
+	}
+	exec(`UPDATE schema_migrations SET checksum='edited' WHERE version=1`)
+	if rep = readyz(http.StatusServiceUnavailable); rep.Status != health.StatusUnavailable || rep.Checks.Schema.Error != "schema mismatch: modified since applied 1" {
+		t.Fatalf("modified: %+v", rep)
+	}
+	exec(`UPDATE schema_migrations SET checksum=$1 WHERE version=1`, checksum)
+	exec(`UPDATE schema_migrations SET version=-version WHERE version=$1`, latest)
+	if rep = readyz(http.StatusServiceUnavailable); rep.Checks.Schema.Version != latest-1 || rep.Checks.Schema.Error != fmt.Sprintf("schema mismatch: pending %d; unknown to this build %d", latest, -latest) {
+		t.Fatalf("behind: %+v", rep)
+	}
+	exec(`UPDATE schema_migrations SET version=-version WHERE version=$1`, -latest)
+	readyz(http.StatusOK)
+
+	// Holding half the pool crosses the saturation threshold: still ready,
+	// but degraded.
+	var held []*pgxpool.Conn
+	for n := (pool.Stat().MaxConns() + 1) / 2; n > 0; n-- {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, c)
+	}
+	rep = readyz(http.StatusOK)
+	if rep.Status != health.StatusDegraded || rep.Checks.Pool.Status != health.StatusDegraded || rep.Checks.Pool.Acquired < int32(len(held)) {
+		t.Fatalf("saturated: %+v", rep)
+	}
+	for _, c := range held {
+		c.Release()
+	}
+	readyz(http.StatusOK)
+
+	// Draining fails readiness while liveness and the API keep answering.
+	ready.Drain()
+	if rep = readyz(http.StatusServiceUnavailable); rep.Status != health.StatusDraining {
+		t.Fatalf("draining: %+v", rep)
+	}
+	res, err = http.Get(srv.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("livez while draining: %d", res.StatusCode)
+	}
+}
+
+func TestMigrations_Runner(t *testing.T) {
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	r := migrate.Embedded(pool)
+	latest := r.Latest()
+
+	exec := func(sql string, args ...any) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, sql, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tableExists := func(name string) bool {
+		t.Helper()
+		var ok bool
+		if err := pool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&ok); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	mismatch := func(r *migrate.Runner) *migrate.MismatchError {
+		t.Helper()
+		var m *migrate.MismatchError
+		if err := r.Check(ctx); !errors.As(err, &m) {
+			t.Fatalf("check: %v, want a mismatch", err)
+		}
+		return m
+	}
+	// withFS builds a runner over a copy of the embedded migrations changed
+	// by edit.
+	withFS := func(edit func(fstest.MapFS)) *migrate.Runner {
+		t.Helper()
+		files := fstest.MapFS{}
+		entries, err := fs.ReadDir(migrations.FS, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			b, err := fs.ReadFile(migrations.FS, e.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[e.Name()] = &fstest.MapFile{Data: b}
+		}
+		edit(files)
+		r, err := migrate.New(pool, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	entries, err := r.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(entries)) != latest || migrate.Mismatch(entries) != nil || entries[0].AppliedAt == nil {
+		t.Fatalf("after setup: %+v", entries)
+	}
+
+	// member_activity comes from the newest migration, reviewed_at from the
+	// one before.
+	if err := r.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists("member_activity") {
+		t.Fatal("member_activity survived down")
+	}
+	if m := mismatch(r); !slices.Equal(m.Pending, []int64{latest - 1, latest}) || m.Modified != nil || m.Unknown != nil {
+		t.Fatalf("after down: %+v", m)
+	}
+
+	// Concurrent runners queue on the advisory lock; each migration runs once.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- migrate.Embedded(pool).Up(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent up: %v", err)
+		}
+	}
+	if err := r.Check(ctx); err != nil || !tableExists("member_activity") {
+		t.Fatalf("after up: %v", err)
+	}
+
+	if err := r.Goto(ctx, latest-1); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists("member_activity") {
+		t.Fatal("goto did not revert the newest migration")
+	}
+	if err := r.Goto(ctx, latest); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Goto(ctx, latest+1); err == nil {
+		t.Fatal("goto to a missing version succeeded")
+	}
+
+	// An applied migration edited afterwards is reported and blocks runs.
+	edited := withFS(func(files fstest.MapFS) {
+		f := files["001_init.up.sql"]
+		f.Data = append(slices.Clone(f.Data), "\n-- edited\n"...)
+	})
+	if m := mismatch(edited); !slices.Equal(m.Modified, []int64{1}) || m.Pending != nil {
+		t.Fatalf("edited: %+v", m)
+	}
+	if err := edited.Down(ctx, 1); err == nil || !tableExists("member_activity") {
+		t.Fatalf("down with an edited migration: %v", err)
+	}
+
+	// An older build sees the newest version as unknown and will not touch it.
+	var newest string
+	for _, e := range entries {
+		if e.Version == latest {
+			newest = fmt.Sprintf("%03d_%s", e.Version, e.Name)
+		}
+	}
+	older := withFS(func(files fstest.MapFS) {
+		delete(files, newest+".up.sql")
+		delete(files, newest+".down.sql")
+	})
+	if m := mismatch(older); !slices.Equal(m.Unknown, []int64{latest}) {
+		t.Fatalf("older build: %+v", m)
+	}
+	if err := older.Up(ctx); err == nil {
+		t.Fatal("older build migrated a newer schema")
+	}
+
+	if _, err := migrate.New(pool, fstest.MapFS{"001_x.up.sql": {Data: []byte("SELECT 1")}}); err == nil {
+		t.Fatal("migration without a down file loaded")
+	}
+
+	// A golang-migrate table is adopted: a dirty one is refused, a clean one
+	// is converted with its versions marked applied.
+	exec(`DROP TABLE schema_migrations`)
+	exec(`CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	exec(`INSERT INTO schema_migrations VALUES ($1, true)`, latest)
+	if err := r.Up(ctx); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Fatalf("up over a dirty golang-migrate table: %v", err)
+	}
+	exec(`UPDATE schema_migrations SET dirty=false`)
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("check on golang-migrate table: %v", err)
+	}
+	if err := r.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var recorded int64
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM schema_migrations WHERE checksum <> ''`).Scan(&recorded); err != nil || recorded != latest {
+		t.Fatalf("adopted %d versions (%v), want %d", recorded, err, latest)
+	}
+	if m := mismatch(edited); !slices.Equal(m.Modified, []int64{1}) {
+		t.Fatalf("edited after adoption: %+v", m)
+	}
+}
+
+func TestConfig_FileEnvAndSecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	file := write("config.yaml", `
+http:
+  addr: ":9090"
+  write_timeout: 30s
+database:
+  url: postgres://app:file-pass@db:5432/app
+  max_conns: 8
+log:
+  levels:
+    repo: debug
+auth:
+  enabled: true
+  jwt:
+    role_map:
+      platform-admins: admin
+team_defaults:
+  reviewer_count: 1
+  merge_policy: require_reviewers
+outbox:
+  sinks: [subscriptions, stdout]
+`)
+	// The environment overrides the file, and KEY_FILE overrides KEY.
+	t.Setenv("HTTP_WRITE_TIMEOUT", "45s")
+	t.Setenv("DB_MIN_CONNS", "2")
+	t.Setenv("AUTH_BOOTSTRAP_TOKEN", "from-env")
+	t.Setenv("AUTH_BOOTSTRAP_TOKEN_FILE", write("token", "from-file\n"))
+	t.Setenv("NOTIFY_ENABLED", "false")
+
+	cfg, err := config.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.Addr != ":9090" || cfg.HTTP.WriteTimeout != 45*time.Second || cfg.HTTP.ReadTimeout != 10*time.Second {
+		t.Fatalf("http %+v", cfg.HTTP)
+	}
+	if cfg.Database.MaxConns != 8 || cfg.Database.MinConns != 2 || cfg.Auth.BootstrapToken != "from-file" {
+		t.Fatalf("database %+v, token %q", cfg.Database, cfg.Auth.BootstrapToken)
+	}
+	if cfg.Log.Levels["repo"] != slog.LevelDebug || cfg.Auth.JWT.RoleMap["platform-admins"] != domain.RoleAdmin || len(cfg.Outbox.Sinks) != 2 {
+		t.Fatalf("log %+v, role map %v, sinks %v", cfg.Log, cfg.Auth.JWT.RoleMap, cfg.Outbox.Sinks)
+	}
+	if cfg.Features.Notify || !cfg.Features.Metrics {
+		t.Fatalf("features %+v", cfg.Features)
+	}
+
+	// config print shows effective values and variables, never secrets.
+	var printed bytes.Buffer
+	if err := cfg.Print(&printed); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"addr: :9090 # HTTP_ADDR", "write_timeout: 45s # HTTP_WRITE_TIMEOUT", "bootstrap_token: <redacted> # AUTH_BOOTSTRAP_TOKEN", "url: postgres://app:xxxxx@db:5432/app # DATABASE_URL", "notify: false # NOTIFY_ENABLED"} {
+		if !strings.Contains(printed.String(), want) {
+			t.Fatalf("print lacks %q:\n%s", want, printed.String())
+		}
+	}
+	if strings.Contains(printed.String(), "from-file") || strings.Contains(printed.String(), "file-pass") {
+		t.Fatalf("print leaks a secret:\n%s", printed.String())
+	}
+	var reread config.Config
+	if err := yaml.Unmarshal(printed.Bytes(), &reread); err != nil {
+		t.Fatalf("printed config does not load back: %v", err)
+	}
+
+	// Values that do not parse fail loading; values that parse but make no
+	// sense fail validation, each named by its key.
+	t.Setenv("HTTP_IDLE_TIMEOUT", "soon")
+	if _, err := config.Load(file); err == nil || !strings.Contains(err.Error(), "HTTP_IDLE_TIMEOUT") {
+		t.Fatalf("bad duration: %v", err)
+	}
+	t.Setenv("HTTP_IDLE_TIMEOUT", "")
+	t.Setenv("LOG_LEVELS", "repo=loud")
+	if _, err := config.Load(file); err == nil || !strings.Contains(err.Error(), "LOG_LEVELS") {
+		t.Fatalf("bad log level: %v", err)
+	}
+	t.Setenv("LOG_LEVELS", "")
+	if _, err := config.Load(write("typo.yaml", "http:\n  adr: \":1\"\n")); err == nil {
+		t.Fatal("unknown key loaded")
+	}
+	t.Setenv("SCHEMA_CHECK", "maybe")
+	t.Setenv("TEAM_DEFAULT_REVIEWER_COUNT", "11")
+	t.Setenv("AUTH_JWT_ROLE_MAP", "ops=root")
+	t.Setenv("OUTBOX_SINKS", "kafka")
+	t.Setenv("AUTH_JWKS_URL", "https://sso.example.com/jwks")
+	cfg, err = config.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	for _, want := range []string{"migrate.schema_check", "team_defaults.reviewer_count", "auth.jwt.role_map", "auth.jwt.audience", "unknown sink"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("validate: %v, want %q", err, want)
+		}
+	}
+
+	// Team defaults from the configuration apply to teams that set nothing.
+	pool, cleanup := setupDB(t)
+	defer cleanup()
+	srv := httptest.NewServer(server.NewRouter(pool, server.WithTeamDefaults(domain.EffectiveTeamSettings{
+		ReviewerCount: 1, Strategy: domain.StrategyRandom, MergePolicy: domain.MergeRequireReviewers,
+	})))
+	defer srv.Close()
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("%s status %d, want %d", path, res.StatusCode, want)
+		}
+		var out map[string]any
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	post("/team/add", `{"team_name":"cfg","members":[{"user_id":"cf1","username":"A","is_active":true},{"user_id":"cf2","username":"B","is_active":true},{"user_id":"cf3","username":"C","is_active":true}]}`, http.StatusCreated)
+	pr := post("/pullRequest/create", `{"pull_request_id":"cf-1","pull_request_name":"x","author_id":"cf1"}`, http.StatusCreated)["pr"].(map[string]any)
+	if n := len(pr["assigned_reviewers"].([]any)); n != 1 {
+		t.Fatalf("assigned %d reviewers, want the configured 1", n)
+	}
+}